@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// completionAction is a command whose first argument is a fixed action (maintenance enable ...).
+type completionAction struct {
+	Command string
+	Actions []string
+	WithEnv bool // The action is followed by an env name
+}
+
+type CompletionTemplateData struct {
+	Commands    []string
+	EnvCommands []string // Commands whose positional arguments are env names
+	Actions     []completionAction
+}
+
+func completionData() CompletionTemplateData {
+	data := CompletionTemplateData{
+		EnvCommands: []string{
+			"release", "migrate", "rollback", "logs", "shell", "status", "system-stats", "stats",
+			"start", "stop", "restart", "enable", "disable", "rights", "doctor", "history",
+			"ps", "images", "prune", "env",
+		},
+		Actions: []completionAction{
+			{Command: "maintenance", Actions: []string{"enable", "disable", "status"}, WithEnv: true},
+			{Command: "system-updates", Actions: []string{"status", "enable", "disable"}, WithEnv: true},
+			{Command: "db", Actions: []string{"pull", "push", "diff", "backup", "restore"}, WithEnv: true},
+			{Command: "secrets", Actions: []string{"edit", "push"}, WithEnv: true},
+			{Command: "secret", Actions: []string{"set"}, WithEnv: true},
+			{Command: "server", Actions: []string{"init", "provision", "status"}},
+			{Command: "completion", Actions: []string{"bash", "zsh", "fish"}},
+		},
+	}
+	data.Commands = append([]string{"version", "init", "gen-auth", "validate", "cp", "cleanup"}, data.EnvCommands...)
+	for _, a := range data.Actions {
+		data.Commands = append(data.Commands, a.Command)
+	}
+	sort.Strings(data.Commands)
+	return data
+}
+
+// completionEnvNames lists the env names of deploy.yaml without expanding ${VAR}s,
+// so completion works even when secrets are not exported. Missing files yield nothing.
+func completionEnvNames() []string {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil
+	}
+	var raw struct {
+		Environments map[string]yaml.Node `yaml:"environments"`
+	}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(raw.Environments))
+	for name := range raw.Environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func doCompletion(shell string) {
+	tmpl := map[string]string{"bash": bashCompletionTmpl, "zsh": zshCompletionTmpl, "fish": fishCompletionTmpl}[shell]
+	if tmpl == "" {
+		logFatal("Unsupported shell '%s'. Use 'bash', 'zsh' or 'fish'.", shell)
+	}
+	out, err := renderTemplate(tmpl, completionData())
+	if err != nil {
+		logFatal("Failed to render completion: %v", err)
+	}
+	fmt.Print(out)
+}
+
+// doCompleteEnvs backs the generated scripts ('deploy __complete-envs').
+func doCompleteEnvs() {
+	fmt.Println(strings.Join(completionEnvNames(), "\n"))
+}
+
+// Usage: source <(deploy completion bash)
+const bashCompletionTmpl = `# bash completion for deploy
+_deploy() {
+    local cur=${COMP_WORDS[COMP_CWORD]} prev i
+    local -a pos=()
+    for ((i = 1; i < COMP_CWORD; i++)); do
+        case ${COMP_WORDS[i]} in
+            -c|--config|-config|--server-config|-server-config|--bwlimit|-bwlimit) ((i++)) ;;
+            -*) ;;
+            *) pos+=("${COMP_WORDS[i]}") ;;
+        esac
+    done
+
+    if [ ${#pos[@]} -eq 0 ]; then
+        COMPREPLY=($(compgen -W "{{ join .Commands " " }}" -- "$cur"))
+        return
+    fi
+
+    local envs
+    envs=$(deploy __complete-envs 2>/dev/null)
+    case ${pos[0]} in
+{{- range .Actions }}
+        {{ .Command }})
+            if [ ${#pos[@]} -eq 1 ]; then
+                COMPREPLY=($(compgen -W "{{ join .Actions " " }}" -- "$cur"))
+{{- if .WithEnv }}
+            elif [ ${#pos[@]} -eq 2 ]; then
+                COMPREPLY=($(compgen -W "$envs" -- "$cur"))
+{{- end }}
+            fi
+            ;;
+{{- end }}
+        {{ join .EnvCommands "|" }})
+            COMPREPLY=($(compgen -W "$envs" -- "$cur"))
+            ;;
+    esac
+}
+complete -F _deploy deploy
+`
+
+// Usage: deploy completion zsh > "${fpath[1]}/_deploy"
+const zshCompletionTmpl = `#compdef deploy
+# zsh completion for deploy
+_deploy() {
+    local -a pos envs
+    local i w
+    for ((i = 2; i < CURRENT; i++)); do
+        w=${words[i]}
+        case $w in
+            -c|--config|-config|--server-config|-server-config|--bwlimit|-bwlimit) ((i++)) ;;
+            -*) ;;
+            *) pos+=("$w") ;;
+        esac
+    done
+
+    if (( ${#pos} == 0 )); then
+        compadd -- {{ join .Commands " " }}
+        return
+    fi
+
+    envs=(${(f)"$(deploy __complete-envs 2>/dev/null)"})
+    case ${pos[1]} in
+{{- range .Actions }}
+        {{ .Command }})
+            if (( ${#pos} == 1 )); then
+                compadd -- {{ join .Actions " " }}
+{{- if .WithEnv }}
+            elif (( ${#pos} == 2 )); then
+                compadd -a envs
+{{- end }}
+            fi
+            ;;
+{{- end }}
+        {{ join .EnvCommands "|" }})
+            compadd -a envs
+            ;;
+    esac
+}
+compdef _deploy deploy
+`
+
+// Usage: deploy completion fish > ~/.config/fish/completions/deploy.fish
+const fishCompletionTmpl = `# fish completion for deploy
+function __deploy_envs
+    deploy __complete-envs 2>/dev/null
+end
+
+complete -c deploy -f
+complete -c deploy -n __fish_use_subcommand -a '{{ join .Commands " " }}'
+{{- range .Actions }}
+complete -c deploy -n '__fish_seen_subcommand_from {{ .Command }}; and not __fish_seen_subcommand_from {{ join .Actions " " }}' -a '{{ join .Actions " " }}'
+{{- if .WithEnv }}
+complete -c deploy -n '__fish_seen_subcommand_from {{ .Command }}; and __fish_seen_subcommand_from {{ join .Actions " " }}' -a '(__deploy_envs)'
+{{- end }}
+{{- end }}
+complete -c deploy -n '__fish_seen_subcommand_from {{ join .EnvCommands " " }}' -a '(__deploy_envs)'
+`
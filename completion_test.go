@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompletionScripts(t *testing.T) {
+	for _, tmpl := range []string{bashCompletionTmpl, zshCompletionTmpl, fishCompletionTmpl} {
+		out, err := renderTemplate(tmpl, completionData())
+		if err != nil {
+			t.Fatalf("Render failed: %v", err)
+		}
+		for _, want := range []string{"enable disable status", "deploy __complete-envs", "release"} {
+			if !strings.Contains(out, want) {
+				t.Errorf("Expected %q in:\n%s", want, out)
+			}
+		}
+	}
+}
+
+func TestCompletionEnvNames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deploy.yaml")
+	// Unset variables must not break completion
+	yml := "environments:\n  prod:\n    host: \"${DEPLOY_TEST_UNSET_HOST}\"\n  staging: {}\n"
+	if err := os.WriteFile(path, []byte(yml), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	old := configPath
+	configPath = path
+	t.Cleanup(func() { configPath = old })
+
+	if got := strings.Join(completionEnvNames(), ","); got != "prod,staging" {
+		t.Errorf("Expected prod,staging, got %s", got)
+	}
+
+	configPath = filepath.Join(t.TempDir(), "missing.yaml")
+	if got := completionEnvNames(); len(got) != 0 {
+		t.Errorf("Expected no envs without deploy.yaml, got %v", got)
+	}
+}
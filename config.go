@@ -1,7 +1,15 @@
 package main
 
 import (
+	"fmt"
+	"maps"
+	"net"
+	"net/url"
 	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -12,15 +20,24 @@ type Config struct {
 	Build        BuildConfig            `yaml:"build"`
 	Artifacts    ArtifactsConfig        `yaml:"artifacts"`
 	Maintenance  MaintenanceConfig      `yaml:"maintenance"` // Global Default
+	Notify       NotifyConfig           `yaml:"notify"`
+	AuthProvider string                 `yaml:"auth_provider"`
 	Environments map[string]Environment `yaml:"environments"`
 }
 
+type NotifyConfig struct {
+	WebhookURL string `yaml:"webhook_url"` // Empty disables notifications
+	Template   string `yaml:"template"`    // text/template for the message, receives NotifyPayload
+}
+
 type ServerConfig struct {
-	Host    string      `yaml:"host"`
-	User    string      `yaml:"user"`
-	SSHPort int         `yaml:"ssh_port"`
-	SSHKey  string      `yaml:"ssh_key"`
-	Stack   ServerStack `yaml:"stack"`
+	Host       string      `yaml:"host"`
+	User       string      `yaml:"user"`
+	SSHPort    int         `yaml:"ssh_port"`
+	SSHKey     string      `yaml:"ssh_key"`
+	JumpHost   string      `yaml:"jump_host"`
+	SSHTimeout int         `yaml:"ssh_connect_timeout"`
+	Stack      ServerStack `yaml:"stack"`
 }
 
 type ServerStack struct {
@@ -35,15 +52,30 @@ type TraefikStack struct {
 	Dashboard   bool       `yaml:"dashboard"`
 	NetworkName string     `yaml:"network_name"`
 	Auth        AuthConfig `yaml:"auth"` // Global Auth
+
+	// ACME challenge: "http" (default) or "dns" (wildcards, no public port 80 needed)
+	Challenge   string   `yaml:"challenge"`
+	DNSProvider string   `yaml:"dns_provider"` // Traefik/lego provider name, e.g. "cloudflare"
+	DNSEnv      []string `yaml:"dns_env"`      // Provider credentials, KEY=value; ${VAR} is expanded locally, unset is an error
+
+	// Extra entrypoints next to web/websecure, name -> address (e.g. metrics: ":9100")
+	EntryPoints map[string]string `yaml:"entrypoints"`
+
+	// Load balancers/CDNs in front of Traefik (IPs or CIDRs) whose X-Forwarded-For is trusted,
+	// so apps see the real client IP. proxy_protocol also accepts PROXY protocol from them.
+	TrustedIPs    []string `yaml:"trusted_ips"`
+	ProxyProtocol bool     `yaml:"proxy_protocol"`
 }
 
 type AuthConfig struct {
-	Provider string `yaml:"provider"` // "basic" or "authelia"
+	Provider string   `yaml:"provider"` // "basic" or "authelia"
+	Users    []string `yaml:"users"`    // "user:bcrypt-hash" entries for the shared basic auth middleware
 }
 
 type AutheliaConfig struct {
 	Subdomain string `yaml:"subdomain"`
-	UsersFile string `yaml:"users_file"`
+	Domain    string `yaml:"domain"`     // Session cookie domain, portal is served at <subdomain>.<domain>
+	UsersFile string `yaml:"users_file"` // Local file, synced as the Authelia file backend
 	// We can add SMTP, etc later as needed, keeping it simple for now
 }
 
@@ -52,10 +84,13 @@ type WatchtowerConfig struct {
 }
 
 type BuildConfig struct {
-	Arch    string `yaml:"arch"`
-	Ldflags string `yaml:"ldflags"`
-	Dir     string `yaml:"dir"`
-	Cmd     string `yaml:"cmd"`
+	Arch     string   `yaml:"arch"`   // amd64 (default) or arm64
+	Arches   []string `yaml:"arches"` // Multi-arch: builds build/<binary>-<arch> for each
+	Ldflags  string   `yaml:"ldflags"`
+	Dir      string   `yaml:"dir"`
+	Cmd      string   `yaml:"cmd"`
+	Registry string   `yaml:"registry"` // e.g. "ghcr.io/acme", used when quadlet.pull_image is true
+	OS       string   `yaml:"os"`       // GOOS of the binary (default linux)
 }
 
 type ArtifactsConfig struct {
@@ -68,23 +103,66 @@ type Environment struct {
 	User        string            `yaml:"user"`
 	Port        int               `yaml:"ssh_port"`
 	SSHKey      string            `yaml:"ssh_key"`
+	JumpHost    string            `yaml:"jump_host"`           // Bastion for ssh -J: [user@]host[:port]
+	SSHTimeout  int               `yaml:"ssh_connect_timeout"` // Seconds (default 10)
+	Arch        string            `yaml:"arch"`                // Host arch when build.arches has several (default: first)
+	Extends     string            `yaml:"extends"`             // Inherit from another env (see applyEnvExtends)
 	Dir         string            `yaml:"target_dir"`
 	SyncEnvFile string            `yaml:"sync_env_file"`
+	Secrets     SecretsConfig     `yaml:"secrets"` // age-encrypted alternative to sync_env_file
 	Quadlet     Quadlet           `yaml:"quadlet"`
+	Sidecars    []Quadlet         `yaml:"sidecars"`    // Extra containers (e.g. redis), started before the app
 	Maintenance MaintenanceConfig `yaml:"maintenance"` // Env Override
 	Database    DatabaseConfig    `yaml:"database"`
+	Migrate     MigrateConfig     `yaml:"migrate"`
+	KeepImages  int               `yaml:"keep_images"`  // Versioned image tags kept by 'deploy prune' (default 5)
+	KeepBackups int               `yaml:"keep_backups"` // Rotated <binary>.bak.N copies used for rollback (default 3)
+
+	// Release aborts before syncing if target_dir has less free space (default 500, -1 disables)
+	MinFreeMB int `yaml:"min_free_disk_mb"`
+	// Values for {{ .Vars.KEY }} when the env file contains template delimiters
+	EnvTemplateVars map[string]string `yaml:"env_template_vars"`
+	// Middleware behind router.auth: "basic" (default) or "authelia", as in server.yaml
+	AuthProvider string `yaml:"auth_provider"`
+	// Run the app and its sidecars in one podman pod (shared network namespace)
+	Pod PodConfig `yaml:"pod"`
+	// Appended to rsync's -avz, e.g. ["--no-perms", "--checksum"]; -e and --delete stay managed
+	RsyncArgs []string `yaml:"rsync_args"`
+	// ssh ProxyCommand for hosts only reachable via e.g. Tailscale: "tailscale nc %h %p"
+	SSHProxyCommand string `yaml:"ssh_proxy_command"`
+	// Release only commits on this branch/ref, e.g. "main" for prod (HEAD must be in its history)
+	GitRef string `yaml:"git_ref"`
+	// Share one ssh connection per host (ControlMaster); nil = on, false for MFA/odd jump hosts
+	SSHMultiplex *bool `yaml:"ssh_multiplex"`
+
 	// Traefik config removed from here, now in ServerConfig
 }
 
+// SecretsConfig points at an age-encrypted env file managed by 'deploy secrets'.
+type SecretsConfig struct {
+	File           string `yaml:"file"`            // e.g. "secrets.prod.env.age", committed to git
+	Identity       string `yaml:"identity"`        // age private key used to decrypt, e.g. "~/.config/age/key.txt"
+	RecipientsFile string `yaml:"recipients_file"` // Optional team public keys; default: encrypt to the identity
+}
+
+// MigrateConfig runs Cmd in a one-off container of the app image.
+type MigrateConfig struct {
+	Cmd string `yaml:"cmd"` // e.g. "/app-server migrate up"
+}
+
 type MaintenanceConfig struct {
-	Enabled bool   `yaml:"enabled"`
-	Title   string `yaml:"title"`
-	Text    string `yaml:"text"`
+	Enabled  bool   `yaml:"enabled"`
+	Title    string `yaml:"title"`
+	Text     string `yaml:"text"`
+	HTMLFile string `yaml:"html_file"` // Local page used instead of the built-in one; may use {{ .Title }}/{{ .Text }}
 }
 
 type DatabaseConfig struct {
-	Driver string `yaml:"driver"`
-	Source string `yaml:"source"`
+	Driver   string `yaml:"driver"`    // "sqlite" or "postgres"
+	Source   string `yaml:"source"`    // sqlite: relative file path, postgres: DSN
+	DumpFile string `yaml:"dump_file"` // postgres: local dump path (default: data/<env>.dump)
+
+	BackupRetention int `yaml:"backup_retention"` // Snapshots kept by 'deploy db backup' (0 = keep all)
 }
 
 type TraefikConfig struct {
@@ -94,12 +172,49 @@ type TraefikConfig struct {
 	NetworkName   string `yaml:"network_name"`
 	Dashboard     bool   `yaml:"dashboard"`
 	DashboardAuth string `yaml:"dashboard_auth"`
+
+	Challenge   string   // "http" or "dns"
+	DNSProvider string   // Used with Challenge "dns"
+	DNSEnv      []string // Uploaded as the 0600 EnvironmentFile of traefik.container
+
+	EntryPoints map[string]string // Extra entrypoints, name -> address; each is published
+
+	TrustedIPs    []string // Proxies/LBs whose X-Forwarded-* (and PROXY protocol) headers are honored
+	ProxyProtocol bool     // Also accept the PROXY protocol from TrustedIPs
+}
+
+// PublishPorts maps the extra entrypoint addresses to PublishPort values
+// (":9100" -> "9100:9100", "127.0.0.1:8443" -> "127.0.0.1:8443:8443", ":53/udp" -> "53:53/udp").
+func (t TraefikConfig) PublishPorts() []string {
+	names := make([]string, 0, len(t.EntryPoints))
+	for name := range t.EntryPoints {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var ports []string
+	for _, name := range names {
+		addr, proto, _ := strings.Cut(t.EntryPoints[name], "/")
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || port == "" {
+			continue
+		}
+		p := port + ":" + port
+		if host != "" {
+			p = host + ":" + p
+		}
+		if proto != "" {
+			p += "/" + proto
+		}
+		ports = append(ports, p)
+	}
+	return ports
 }
 
 type RouterConfig struct {
-	Enabled       bool     `yaml:"enabled"`
-	Domain        string   `yaml:"domain"` // Replaces Host/Rule simplicity
-	Host          string   `yaml:"host"`   // Legacy support
+	Enabled       *bool    `yaml:"enabled"` // nil = enabled; only an explicit false disables labels
+	Domain        string   `yaml:"domain"`  // Replaces Host/Rule simplicity
+	Host          string   `yaml:"host"`    // Legacy support
 	Rule          string   `yaml:"rule"`
 	InternalPort  int      `yaml:"internal_port"`
 	EntryPoints   []string `yaml:"entrypoints"`
@@ -110,6 +225,20 @@ type RouterConfig struct {
 	Compress      bool     `yaml:"compress"`
 	Auth          bool     `yaml:"auth"` // Boolean intent
 
+	// "to_apex" (www.example.com -> example.com) or "to_www"; 301, path and query kept
+	RedirectWWW string `yaml:"redirect_www"`
+
+	// Additional hostnames (aliases), each served by its own router with the same middlewares
+	ExtraHosts []string `yaml:"extra_hosts"`
+
+	// Traefik active health check; the path defaults to the path of quadlet.health_url
+	HealthPath     string `yaml:"health_path"`
+	HealthInterval string `yaml:"health_interval"` // Default "10s"
+
+	// Sticky sessions (cookie-based) for stateful apps behind several replicas
+	Sticky       bool               `yaml:"sticky"`
+	StickyCookie StickyCookieConfig `yaml:"sticky_cookie"`
+
 	// Legacy Header/RateLimit support kept for power users
 	BasicAuth     []string          `yaml:"basic_auth_users"`
 	BasicAuthFile string            `yaml:"basic_auth_file"`
@@ -118,9 +247,25 @@ type RouterConfig struct {
 	Headers       map[string]string `yaml:"headers"`
 }
 
+// PodConfig groups the app and its sidecars into a <name>.pod quadlet. Network and
+// published ports move from the containers to the pod; sidecars are reached via localhost.
+type PodConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	Name    string   `yaml:"name"`  // Default: quadlet.service_name
+	Ports   []string `yaml:"ports"` // Published in addition to the containers' ports
+}
+
+// StickyCookieConfig tunes the affinity cookie; all fields are optional.
+type StickyCookieConfig struct {
+	Name     string `yaml:"name"`
+	Secure   bool   `yaml:"secure"`
+	HTTPOnly bool   `yaml:"httponly"`
+}
+
 type RateLimitConfig struct {
-	Average int `yaml:"average"`
-	Burst   int `yaml:"burst"`
+	Average int    `yaml:"average"`
+	Burst   int    `yaml:"burst"`  // Default: average*2
+	Period  string `yaml:"period"` // Window for average, e.g. "1m" (Traefik default 1s)
 }
 
 type Quadlet struct {
@@ -139,15 +284,55 @@ type Quadlet struct {
 	Memory       string       `yaml:"memory"`
 	CPU          string       `yaml:"cpu"`
 	ReadOnly     bool         `yaml:"read_only"`
+	Tmpfs        []string     `yaml:"tmpfs"` // Writable scratch mounts, e.g. "/tmp" or "/run:size=16m"
 	HealthCmd    string       `yaml:"health_cmd"`
 	HealthURL    string       `yaml:"health_url"`
+	HealthTCP    string       `yaml:"health_tcp"` // "host:port" or "port" (127.0.0.1); health_url wins if both are set
 	PodmanArgs   []string     `yaml:"podman_args"`
 	Exec         string       `yaml:"exec"`
 	Dockerfile   string       `yaml:"dockerfile"`
+	PullImage    bool         `yaml:"pull_image"` // Build & push locally to build.registry, server only pulls
+
+	// Podman's own health check (health_cmd). health_retries and health_start_period already
+	// tune the deploy loop below, so podman's two carry the health_cmd_ prefix.
+	HealthCmdInterval    string `yaml:"health_interval"`         // Default "60s"
+	HealthCmdRetries     int    `yaml:"health_cmd_retries"`      // Default 3
+	HealthCmdTimeout     string `yaml:"health_timeout"`          // Podman default 30s
+	HealthCmdStartPeriod string `yaml:"health_cmd_start_period"` // Grace period after start, e.g. "20s"
 
-	ContainerUID int      `yaml:"container_uid"`
-	ContainerGID int      `yaml:"container_gid"`
-	ChownVolumes []string `yaml:"chown_volumes"`
+	// Earlier spellings of health_interval/health_timeout, folded in by resolveEnv
+	HealthCmdIntervalAlias string `yaml:"health_cmd_interval"`
+	HealthCmdTimeoutAlias  string `yaml:"health_cmd_timeout"`
+
+	// Post-deploy health check loop (health_url/health_tcp)
+	HealthRetries     int `yaml:"health_retries"`          // Attempts (default 15)
+	HealthInterval    int `yaml:"health_interval_seconds"` // Seconds between attempts (default 2)
+	HealthStartPeriod int `yaml:"health_start_period"`     // Seconds to wait before the first attempt
+
+	// Podman secrets exposed as env vars; values are set with 'deploy secret set'
+	Secrets []PodmanSecret `yaml:"secrets"`
+
+	// Graceful shutdown: SIGTERM, then SIGKILL after this many seconds (podman default 10)
+	StopTimeout int `yaml:"stop_timeout_seconds"`
+
+	ContainerName string   `yaml:"container_name"` // DNS name on the network; sidecars default to service_name
+	ContainerUID  int      `yaml:"container_uid"`
+	ContainerGID  int      `yaml:"container_gid"`
+	ChownVolumes  []string `yaml:"chown_volumes"`
+}
+
+// PodmanSecret maps a podman secret to an env var inside the container.
+type PodmanSecret struct {
+	Name string `yaml:"name"`
+	Env  string `yaml:"env"` // Default: Name upper-cased
+}
+
+// Target is the env var the secret is exposed as.
+func (s PodmanSecret) Target() string {
+	if s.Env != "" {
+		return s.Env
+	}
+	return strings.ToUpper(s.Name)
 }
 
 type BuildMetadata struct {
@@ -157,28 +342,215 @@ type BuildMetadata struct {
 	Tag         string
 	MainVersion string
 	GoVersion   string
+	Arch        string // GOARCH of the current build
+	Output      string // Binary path the build should write
 }
 
 func loadConfig() Config {
-	data, err := os.ReadFile("deploy.yaml")
+	cfg, err := readConfig()
 	if err != nil {
-		logFatal("Read error: %v", err)
+		logFatal("%v", err)
 	}
+	return cfg
+}
+
+// readConfig is the error-returning variant of loadConfig.
+func readConfig() (Config, error) {
 	var cfg Config
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return cfg, fmt.Errorf("read error: %w", err)
+	}
+	if data, err = applyEnvExtends(data); err != nil {
+		return cfg, err
+	}
+	if err := checkRemovedHealthKeys(data); err != nil {
+		return cfg, err
+	}
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		logFatal("Parse error: %v", err)
+		return cfg, fmt.Errorf("parse error: %w", err)
 	}
-	return cfg
+	if err := expandConfigEnv(&cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// removedHealthKeys maps health keys that briefly existed to the ones to use; yaml decoding
+// would otherwise ignore them silently and fall back to the defaults.
+var removedHealthKeys = map[string]string{
+	"health_check_retries":      "health_retries",
+	"health_check_interval":     "health_interval_seconds",
+	"health_check_start_period": "health_start_period",
+	"health_lb_path":            "router.health_path",
+	"health_lb_interval":        "router.health_interval",
+}
+
+// checkRemovedHealthKeys fails on removedHealthKeys in any env's quadlet, router or sidecar.
+func checkRemovedHealthKeys(data []byte) error {
+	var raw struct {
+		Environments map[string]struct {
+			Quadlet  map[string]any   `yaml:"quadlet"`
+			Sidecars []map[string]any `yaml:"sidecars"`
+		} `yaml:"environments"`
+	}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("parse error: %w", err)
+	}
+	for _, name := range slices.Sorted(maps.Keys(raw.Environments)) {
+		env := raw.Environments[name]
+		for _, q := range append([]map[string]any{env.Quadlet}, env.Sidecars...) {
+			router, _ := q["router"].(map[string]any)
+			for _, m := range []map[string]any{q, router} {
+				for _, key := range slices.Sorted(maps.Keys(m)) {
+					if use, ok := removedHealthKeys[key]; ok {
+						return fmt.Errorf("env %s: '%s' is no longer supported, use '%s'", name, key, use)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// applyEnvExtends merges every environment with its 'extends' base before the config is
+// decoded. Maps (quadlet, router, ...) merge key by key; scalars and lists in the child
+// replace the base value, so an explicit `false` or `[]` overrides too.
+func applyEnvExtends(data []byte) ([]byte, error) {
+	var raw map[string]any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parse error: %w", err)
+	}
+	envs, ok := raw["environments"].(map[string]any)
+	if !ok {
+		return data, nil
+	}
+
+	resolved := map[string]map[string]any{}
+	var resolve func(name string, seen []string) (map[string]any, error)
+	resolve = func(name string, seen []string) (map[string]any, error) {
+		if r, ok := resolved[name]; ok {
+			return r, nil
+		}
+		if slices.Contains(seen, name) {
+			return nil, fmt.Errorf("env %s: circular 'extends' (%s)", seen[0], strings.Join(append(seen, name), " -> "))
+		}
+		env, _ := envs[name].(map[string]any)
+		base, _ := env["extends"].(string)
+		if base == "" {
+			resolved[name] = env
+			return env, nil
+		}
+		if _, ok := envs[base]; !ok {
+			return nil, fmt.Errorf("env %s: extends unknown env '%s'", name, base)
+		}
+		parent, err := resolve(base, append(seen, name))
+		if err != nil {
+			return nil, err
+		}
+		merged := mergeYAMLMaps(parent, env)
+		resolved[name] = merged
+		return merged, nil
+	}
+
+	changed := false
+	for name := range envs {
+		env, _ := envs[name].(map[string]any)
+		if _, ok := env["extends"]; !ok {
+			continue
+		}
+		merged, err := resolve(name, nil)
+		if err != nil {
+			return nil, err
+		}
+		envs[name] = merged
+		changed = true
+	}
+	if !changed {
+		return data, nil
+	}
+	return yaml.Marshal(raw)
+}
+
+// mergeYAMLMaps returns base overlaid with override; nested maps are merged recursively.
+func mergeYAMLMaps(base, override map[string]any) map[string]any {
+	out := make(map[string]any, len(base)+len(override))
+	for k, v := range base {
+		out[k] = v
+	}
+	for k, v := range override {
+		if bm, ok := out[k].(map[string]any); ok {
+			if om, ok := v.(map[string]any); ok {
+				out[k] = mergeYAMLMaps(bm, om)
+				continue
+			}
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// expandConfigEnv resolves ${VAR} / $VAR in the values that typically hold secrets or
+// per-machine paths. Unset variables are an error; "$$" yields a literal "$".
+// Build commands and templates are left alone as they are shell/template code.
+func expandConfigEnv(cfg *Config) error {
+	var missing []string
+	expand := func(v string) string { return expandStrict(v, &missing) }
+	expandQuadlet := func(q *Quadlet) {
+		q.Image = expand(q.Image)
+		for i, v := range q.EnvVars {
+			q.EnvVars[i] = expand(v)
+		}
+	}
+
+	cfg.Build.Registry = expand(cfg.Build.Registry)
+	cfg.Notify.WebhookURL = expand(cfg.Notify.WebhookURL)
+	for name, env := range cfg.Environments {
+		env.Host = expand(env.Host)
+		env.User = expand(env.User)
+		env.SSHKey = expand(env.SSHKey)
+		env.Database.Source = expand(env.Database.Source)
+		expandQuadlet(&env.Quadlet)
+		for i := range env.Sidecars {
+			expandQuadlet(&env.Sidecars[i])
+		}
+		cfg.Environments[name] = env
+	}
+	return unsetVarsError("deploy.yaml", missing)
+}
+
+// expandStrict is os.ExpandEnv that appends unset variables to missing; "$$" yields "$".
+func expandStrict(v string, missing *[]string) string {
+	return os.Expand(v, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			*missing = append(*missing, name)
+		}
+		return val
+	})
+}
+
+// unsetVarsError reports the variables expandStrict found unset in source, or nil.
+func unsetVarsError(source string, missing []string) error {
+	if len(missing) == 0 {
+		return nil
+	}
+	slices.Sort(missing)
+	missing = slices.Compact(missing)
+	return fmt.Errorf("%s references unset environment variable(s): %s", source, strings.Join(missing, ", "))
 }
 
 func loadServerConfig() ServerConfig {
-	data, err := os.ReadFile("server.yaml")
+	data, err := os.ReadFile(serverConfigPath)
 	if err != nil {
-		logFatal("Read error (server.yaml): %v", err)
+		logFatal("Read error (%s): %v", serverConfigPath, err)
 	}
 	var cfg ServerConfig
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		logFatal("Parse error (server.yaml): %v", err)
+		logFatal("Parse error (%s): %v", serverConfigPath, err)
 	}
 	// Defaults
 	if cfg.SSHPort == 0 {
@@ -192,9 +564,32 @@ func loadServerConfig() ServerConfig {
 
 func loadEnv(envName string) (Config, Environment) {
 	cfg := loadConfig()
+	env, err := resolveEnv(cfg, envName)
+	if err != nil {
+		logFatal("%v", err)
+	}
+	return cfg, env
+}
+
+// resolveEnv looks up an environment and applies defaults without exiting.
+func resolveEnv(cfg Config, envName string) (Environment, error) {
 	env, ok := cfg.Environments[envName]
 	if !ok {
-		logFatal("Env %s not found", envName)
+		return env, fmt.Errorf("env %s not found", envName)
+	}
+
+	// Defaults
+	if env.Port == 0 {
+		env.Port = 22
+	}
+	if env.KeepImages == 0 {
+		env.KeepImages = 5
+	}
+	if env.KeepBackups == 0 {
+		env.KeepBackups = 3
+	}
+	if env.MinFreeMB == 0 {
+		env.MinFreeMB = 500
 	}
 
 	// Merge Global Maintenance Defaults into Environment
@@ -204,6 +599,112 @@ func loadEnv(envName string) (Config, Environment) {
 	if env.Maintenance.Text == "" {
 		env.Maintenance.Text = cfg.Maintenance.Text
 	}
+	if env.Maintenance.HTMLFile == "" {
+		env.Maintenance.HTMLFile = cfg.Maintenance.HTMLFile
+	}
+	if env.AuthProvider == "" {
+		env.AuthProvider = cfg.AuthProvider
+	}
+	if err := checkRsyncArgs(env.RsyncArgs); err != nil {
+		return env, fmt.Errorf("env %s: %w", envName, err)
+	}
+	applyHealthAliases(&env.Quadlet)
+	for i := range env.Sidecars {
+		applyHealthAliases(&env.Sidecars[i])
+	}
+	for _, q := range append([]Quadlet{env.Quadlet}, env.Sidecars...) {
+		if err := checkHealthDurations(q); err != nil {
+			return env, fmt.Errorf("env %s: %w", envName, err)
+		}
+		if q.HealthTCP != "" {
+			if _, _, err := splitHealthTCP(q.HealthTCP); err != nil {
+				return env, fmt.Errorf("env %s: %w", envName, err)
+			}
+		}
+	}
 
-	return cfg, env
+	return env, nil
+}
+
+// applyHealthAliases moves health_cmd_interval/health_cmd_timeout into health_interval/
+// health_timeout; the documented names win if both are set.
+func applyHealthAliases(q *Quadlet) {
+	if q.HealthCmdInterval == "" {
+		q.HealthCmdInterval = q.HealthCmdIntervalAlias
+	}
+	if q.HealthCmdTimeout == "" {
+		q.HealthCmdTimeout = q.HealthCmdTimeoutAlias
+	}
+	q.HealthCmdIntervalAlias, q.HealthCmdTimeoutAlias = "", ""
+}
+
+// checkHealthDurations rejects podman and Traefik health durations that are not
+// durations like "2s" or "1m30s".
+func checkHealthDurations(q Quadlet) error {
+	for _, f := range []struct{ key, v string }{
+		{"health_interval", q.HealthCmdInterval},
+		{"health_timeout", q.HealthCmdTimeout},
+		{"health_cmd_start_period", q.HealthCmdStartPeriod},
+		{"router.health_interval", q.Router.HealthInterval},
+	} {
+		if f.v == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(f.v); err != nil || d < 0 {
+			return fmt.Errorf("%s: '%s' is not a duration (e.g. \"2s\", \"1m\")", f.key, f.v)
+		}
+	}
+	return nil
+}
+
+const secretMask = "****"
+
+var dsnPassword = regexp.MustCompile(`(?i)(password=)\S+`)
+
+// maskedEnv returns a copy of env safe to print: env var values, basic auth hashes and
+// database passwords are replaced by a mask. Slices are copied, env stays untouched.
+func maskedEnv(env Environment) Environment {
+	maskQuadlet := func(q Quadlet) Quadlet {
+		q.EnvVars = slices.Clone(q.EnvVars)
+		for i, kv := range q.EnvVars {
+			if k, _, ok := strings.Cut(kv, "="); ok {
+				q.EnvVars[i] = k + "=" + secretMask
+			}
+		}
+		q.Router.BasicAuth = slices.Clone(q.Router.BasicAuth)
+		for i, ua := range q.Router.BasicAuth {
+			if u, _, ok := strings.Cut(ua, ":"); ok {
+				q.Router.BasicAuth[i] = u + ":" + secretMask
+			}
+		}
+		return q
+	}
+
+	env.Quadlet = maskQuadlet(env.Quadlet)
+	env.Sidecars = slices.Clone(env.Sidecars)
+	for i := range env.Sidecars {
+		env.Sidecars[i] = maskQuadlet(env.Sidecars[i])
+	}
+
+	if u, err := url.Parse(env.Database.Source); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			// Swap the raw userinfo; u.String() would percent-encode the mask
+			user := u.User.String()
+			name, _, _ := strings.Cut(user, ":")
+			env.Database.Source = strings.Replace(env.Database.Source, user+"@", name+":"+secretMask+"@", 1)
+		}
+	}
+	env.Database.Source = dsnPassword.ReplaceAllString(env.Database.Source, "${1}"+secretMask)
+	return env
+}
+
+// doEnv prints the fully resolved environment (extends, defaults, global maintenance)
+// as YAML, with secrets masked.
+func doEnv(envName string) {
+	_, env := loadEnv(envName)
+	out, err := yaml.Marshal(maskedEnv(env))
+	if err != nil {
+		logFatal("Failed to render env %s: %v", envName, err)
+	}
+	fmt.Printf("# Effective config of '%s' (secrets masked)\n%s", envName, out)
 }
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"gopkg.in/yaml.v3"
@@ -71,3 +74,263 @@ stack:
 		t.Errorf("Expected Authelia Subdomain 'auth', got '%s'", cfg.Stack.Authelia.Subdomain)
 	}
 }
+
+func TestLoadEnvDefaultsSSHPort(t *testing.T) {
+	dir := t.TempDir()
+	yamlData := `
+app_name: "my-app"
+environments:
+  prod:
+    host: "10.0.0.1"
+    user: "admin"
+    target_dir: "/app"
+`
+	if err := os.WriteFile(filepath.Join(dir, "deploy.yaml"), []byte(yamlData), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	t.Chdir(dir)
+
+	_, env := loadEnv("prod")
+	if env.Port != 22 {
+		t.Errorf("Expected default Port 22, got %d", env.Port)
+	}
+}
+
+func TestResolveEnvUnknown(t *testing.T) {
+	cfg := Config{Environments: map[string]Environment{"prod": {}}}
+	if _, err := resolveEnv(cfg, "qa"); err == nil {
+		t.Error("Expected error for unknown env")
+	}
+	env, err := resolveEnv(cfg, "prod")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if env.KeepBackups != 3 {
+		t.Errorf("Expected default KeepBackups 3, got %d", env.KeepBackups)
+	}
+	if env.MinFreeMB != 500 {
+		t.Errorf("Expected default MinFreeMB 500, got %d", env.MinFreeMB)
+	}
+}
+
+func TestHealthKeysLoad(t *testing.T) {
+	yamlData := `
+environments:
+  prod:
+    quadlet:
+      health_cmd: "/app -health"
+      health_interval: "30s"
+      health_timeout: "5s"
+      health_cmd_retries: 2
+      health_retries: 40
+      health_interval_seconds: 5
+      health_start_period: 30
+      router:
+        health_path: "/healthz"
+        health_interval: "3s"
+    sidecars:
+      - service_name: "worker"
+        health_cmd_interval: "15s"
+        health_cmd_timeout: "2s"
+`
+	var cfg Config
+	if err := yaml.Unmarshal([]byte(yamlData), &cfg); err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if err := checkRemovedHealthKeys([]byte(yamlData)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	env, err := resolveEnv(cfg, "prod")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	q := env.Quadlet
+	if q.HealthCmdInterval != "30s" || q.HealthCmdTimeout != "5s" || q.HealthCmdRetries != 2 {
+		t.Errorf("Expected podman health settings 30s/5s/2, got %s/%s/%d", q.HealthCmdInterval, q.HealthCmdTimeout, q.HealthCmdRetries)
+	}
+	if q.HealthRetries != 40 || q.HealthInterval != 5 || q.HealthStartPeriod != 30 {
+		t.Errorf("Expected deploy loop 40/5/30, got %d/%d/%d", q.HealthRetries, q.HealthInterval, q.HealthStartPeriod)
+	}
+	if q.Router.HealthPath != "/healthz" || q.Router.HealthInterval != "3s" {
+		t.Errorf("Expected router health /healthz every 3s, got %s %s", q.Router.HealthPath, q.Router.HealthInterval)
+	}
+	if w := env.Sidecars[0]; w.HealthCmdInterval != "15s" || w.HealthCmdTimeout != "2s" {
+		t.Errorf("Expected health_cmd_interval/health_cmd_timeout aliases, got %s %s", w.HealthCmdInterval, w.HealthCmdTimeout)
+	}
+
+	cfg.Environments["prod"] = Environment{Quadlet: Quadlet{HealthCmdInterval: "30"}}
+	if _, err := resolveEnv(cfg, "prod"); err == nil || !strings.Contains(err.Error(), "health_interval") {
+		t.Errorf("Expected error for a duration without unit, got %v", err)
+	}
+
+	removed := "environments:\n  prod:\n    quadlet:\n      router:\n        health_lb_interval: \"3s\"\n"
+	if err := checkRemovedHealthKeys([]byte(removed)); err == nil || !strings.Contains(err.Error(), "router.health_interval") {
+		t.Errorf("Expected error pointing to router.health_interval, got %v", err)
+	}
+}
+
+func TestExpandConfigEnv(t *testing.T) {
+	t.Setenv("DEPLOY_TEST_HOST", "10.0.0.9")
+	t.Setenv("DEPLOY_TEST_TOKEN", "s3cret")
+	cfg := Config{Environments: map[string]Environment{
+		"prod": {
+			Host:    "${DEPLOY_TEST_HOST}",
+			SSHKey:  "$HOME/.ssh/id_prod",
+			Quadlet: Quadlet{Image: "ghcr.io/acme/app:latest", EnvVars: []string{"TOKEN=$DEPLOY_TEST_TOKEN", "PRICE=$$5"}},
+		},
+	}}
+	if err := expandConfigEnv(&cfg); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	env := cfg.Environments["prod"]
+	if env.Host != "10.0.0.9" {
+		t.Errorf("Expected host 10.0.0.9, got %s", env.Host)
+	}
+	if env.SSHKey != os.Getenv("HOME")+"/.ssh/id_prod" {
+		t.Errorf("Expected expanded ssh_key, got %s", env.SSHKey)
+	}
+	if env.Quadlet.EnvVars[0] != "TOKEN=s3cret" || env.Quadlet.EnvVars[1] != "PRICE=$5" {
+		t.Errorf("Expected expanded env_vars, got %v", env.Quadlet.EnvVars)
+	}
+
+	cfg = Config{Environments: map[string]Environment{"prod": {Host: "${DEPLOY_TEST_UNSET_VAR}"}}}
+	err := expandConfigEnv(&cfg)
+	if err == nil || !strings.Contains(err.Error(), "DEPLOY_TEST_UNSET_VAR") {
+		t.Errorf("Expected error naming the unset variable, got %v", err)
+	}
+}
+
+func TestReadConfigCustomPath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.yaml")
+	if err := os.WriteFile(path, []byte("app_name: \"api\"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	old := configPath
+	configPath = path
+	t.Cleanup(func() { configPath = old })
+
+	cfg, err := readConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.AppName != "api" {
+		t.Errorf("Expected AppName api, got %s", cfg.AppName)
+	}
+}
+
+func TestEnvExtends(t *testing.T) {
+	yml := `
+environments:
+  staging:
+    host: "staging.example.com"
+    user: "deploy"
+    target_dir: "/srv/app"
+    quadlet:
+      service_name: "app-staging"
+      env_vars: ["LOG_LEVEL=debug", "FEATURE_X=1"]
+      volumes: ["./data:/data:Z"]
+      read_only: true
+      router:
+        domain: "staging.example.com"
+        internal_port: 8080
+  prod:
+    extends: staging
+    host: "prod.example.com"
+    quadlet:
+      service_name: "app"
+      env_vars: ["LOG_LEVEL=info"]
+      read_only: false
+      router:
+        domain: "www.example.com"
+`
+	path := filepath.Join(t.TempDir(), "deploy.yaml")
+	if err := os.WriteFile(path, []byte(yml), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	old := configPath
+	configPath = path
+	t.Cleanup(func() { configPath = old })
+
+	cfg, err := readConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	prod := cfg.Environments["prod"]
+
+	// Scalar overrides win, unset scalars are inherited
+	if prod.Host != "prod.example.com" || prod.User != "deploy" || prod.Dir != "/srv/app" {
+		t.Errorf("Expected overridden host and inherited user/target_dir, got %s %s %s", prod.Host, prod.User, prod.Dir)
+	}
+	if prod.Quadlet.ServiceName != "app" {
+		t.Errorf("Expected service_name app, got %s", prod.Quadlet.ServiceName)
+	}
+	if prod.Quadlet.ReadOnly {
+		t.Error("Expected explicit read_only: false to override the base")
+	}
+	// Lists replace instead of appending
+	if strings.Join(prod.Quadlet.EnvVars, ",") != "LOG_LEVEL=info" {
+		t.Errorf("Expected env_vars to be replaced, got %v", prod.Quadlet.EnvVars)
+	}
+	if len(prod.Quadlet.Volumes) != 1 {
+		t.Errorf("Expected volumes inherited from staging, got %v", prod.Quadlet.Volumes)
+	}
+	// Nested maps merge field by field
+	if prod.Quadlet.Router.Domain != "www.example.com" || prod.Quadlet.Router.InternalPort != 8080 {
+		t.Errorf("Expected merged router, got %+v", prod.Quadlet.Router)
+	}
+	if cfg.Environments["staging"].Quadlet.Router.Domain != "staging.example.com" {
+		t.Error("Expected the base env to be left untouched")
+	}
+}
+
+func TestEnvExtendsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		yml  string
+		want string
+	}{
+		{"Unknown Base", "environments:\n  prod:\n    extends: qa\n", "unknown env 'qa'"},
+		{"Cycle", "environments:\n  a:\n    extends: b\n  b:\n    extends: a\n", "circular"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := applyEnvExtends([]byte(tt.yml))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestMaskedEnv(t *testing.T) {
+	env := Environment{
+		Quadlet: Quadlet{
+			EnvVars: []string{"API_KEY=s3cret", "DEBUG"},
+			Router:  RouterConfig{BasicAuth: []string{"admin:$2y$05$hash"}},
+		},
+		Sidecars: []Quadlet{{EnvVars: []string{"REDIS_PASSWORD=hunter2"}}},
+		Database: DatabaseConfig{Driver: "postgres", Source: "postgres://app:pw@db:5432/app"},
+	}
+	m := maskedEnv(env)
+
+	if m.Quadlet.EnvVars[0] != "API_KEY=****" || m.Quadlet.EnvVars[1] != "DEBUG" {
+		t.Errorf("Expected masked env_vars, got %v", m.Quadlet.EnvVars)
+	}
+	if m.Quadlet.Router.BasicAuth[0] != "admin:****" {
+		t.Errorf("Expected masked basic auth hash, got %v", m.Quadlet.Router.BasicAuth)
+	}
+	if m.Sidecars[0].EnvVars[0] != "REDIS_PASSWORD=****" {
+		t.Errorf("Expected masked sidecar env_vars, got %v", m.Sidecars[0].EnvVars)
+	}
+	if m.Database.Source != "postgres://app:****@db:5432/app" {
+		t.Errorf("Expected masked DSN password, got %s", m.Database.Source)
+	}
+	if env.Quadlet.EnvVars[0] != "API_KEY=s3cret" || env.Sidecars[0].EnvVars[0] != "REDIS_PASSWORD=hunter2" {
+		t.Error("Expected the original env to be left untouched")
+	}
+
+	kv := maskedEnv(Environment{Database: DatabaseConfig{Source: "host=db user=app password=pw dbname=app"}})
+	if kv.Database.Source != "host=db user=app password=**** dbname=app" {
+		t.Errorf("Expected masked key=value DSN, got %s", kv.Database.Source)
+	}
+}
@@ -1,28 +1,177 @@
 package main
 
 import (
+	"compress/gzip"
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
-func doDBPull(envName string) {
+// PullOptions tunes 'deploy db pull'.
+type PullOptions struct {
+	Output   string // Destination instead of database.source / dump_file
+	NoBackup bool   // Overwrite without the confirm prompt and local .bak copy
+
+	Gzip           bool // Compress on the server, decompress locally (sqlite)
+	KeepCompressed bool // With Gzip: save <output>.gz instead of decompressing
+}
+
+func doDBPull(envName string, opts PullOptions) {
 	_, env := loadEnv(envName)
-	if env.Database.Driver != "sqlite" {
-		logFatal("Only sqlite supported")
+	switch env.Database.Driver {
+	case "sqlite":
+		pullSQLite(env, opts)
+	case "postgres":
+		pullPostgres(envName, env, opts)
+	default:
+		logFatal("Unsupported database driver '%s'. Use 'sqlite' or 'postgres'.", env.Database.Driver)
 	}
+}
 
-	local := filepath.Clean(env.Database.Source)
-	remote := fmt.Sprintf("%s/%s", strings.TrimRight(env.Dir, "/"), env.Database.Source)
+func doDBPush(envName string) {
+	_, env := loadEnv(envName)
+	switch env.Database.Driver {
+	case "sqlite":
+		pushSQLite(envName, env)
+	case "postgres":
+		pushPostgres(envName, env)
+	default:
+		logFatal("Unsupported database driver '%s'. Use 'sqlite' or 'postgres'.", env.Database.Driver)
+	}
+}
 
-	logInfo("📥 Pulling DB from %s...", env.Host)
+// remoteBackupDir is where 'deploy db backup' stores server-side snapshots.
+func remoteBackupDir(env Environment) string {
+	return fmt.Sprintf("%s/backups", strings.TrimRight(env.Dir, "/"))
+}
+
+// doDBBackup snapshots the database into <target_dir>/backups/ without downloading it.
+// sqlite's .backup and pg_dump are online-safe, so the service keeps running.
+func doDBBackup(envName string) {
+	_, env := loadEnv(envName)
+	dir := remoteBackupDir(env)
+	stamp := time.Now().UTC().Format("20060102-150405")
+
+	var ext, snapshotCmd string
+	switch env.Database.Driver {
+	case "sqlite":
+		ext = "db"
+		remote := fmt.Sprintf("%s/%s", strings.TrimRight(env.Dir, "/"), env.Database.Source)
+		snapshotCmd = fmt.Sprintf(`
+		%s
+		sqlite3 '%s' ".backup '%s/%s.db'"`, requireRemoteTools("sqlite3"), remote, dir, stamp)
+	case "postgres":
+		ext = "dump"
+		snapshotCmd = fmt.Sprintf(`
+		%s
+		pg_dump --format=custom --no-owner --dbname=%s --file='%s/%s.dump'`, requireRemoteTools("pg_dump"), shellQuote(env.Database.Source), dir, stamp)
+	default:
+		logFatal("Unsupported database driver '%s'. Use 'sqlite' or 'postgres'.", env.Database.Driver)
+	}
+
+	logInfo("📸 Creating server-side snapshot on %s...", env.Host)
+	script := fmt.Sprintf(`
+		set -e
+		mkdir -p '%s'
+		%s
+	`, dir, snapshotCmd)
+	if err := runSSH(env, script); err != nil {
+		logFatal("Backup failed: %v", err)
+	}
+
+	// Optional retention: keep only the newest N snapshots
+	if n := env.Database.BackupRetention; n > 0 {
+		logInfo("🧹 Keeping the newest %d snapshots...", n)
+		prune := fmt.Sprintf("ls -1t '%s'/*.%s 2>/dev/null | tail -n +%d | xargs -r rm -f", dir, ext, n+1)
+		if err := runSSH(env, prune); err != nil {
+			logWarn("Snapshot pruning failed: %v", err)
+		}
+	}
 
-	// Backup Local DB if it exists
-	if _, err := os.Stat(local); err == nil {
+	logSuccess("Snapshot created: %s/%s.%s", dir, stamp, ext)
+}
+
+// resolveBackupPath accepts an absolute remote path or a name relative to the backups directory.
+func resolveBackupPath(env Environment, backup string) string {
+	if strings.HasPrefix(backup, "/") {
+		return backup
+	}
+	return remoteBackupDir(env) + "/" + strings.TrimPrefix(backup, "./")
+}
+
+// doDBRestore copies a server-side snapshot over the live database. The service must be stopped.
+func doDBRestore(envName, backup string) {
+	_, env := loadEnv(envName)
+	snapshot := resolveBackupPath(env, backup)
+
+	// 1. Safety Check: Is service running?
+	ensureServiceStopped(envName, env)
+
+	if err := runSSH(env, fmt.Sprintf("test -f %s", shellQuote(snapshot))); err != nil {
+		logFatal("Snapshot %s not found on %s. List them with: ls %s", snapshot, env.Host, remoteBackupDir(env))
+	}
+
+	logWarn("🔥 RESTORING %s OVER THE REMOTE DB on %s.", snapshot, envName)
+	if !confirm("Are you sure?") {
+		return
+	}
+
+	switch env.Database.Driver {
+	case "sqlite":
+		remote := fmt.Sprintf("%s/%s", strings.TrimRight(env.Dir, "/"), env.Database.Source)
+
+		// 2. Permission Fix (if needed) - Pre-restore
+		if env.Quadlet.ContainerUID > 0 {
+			logInfo("🔧 Reclaiming file permissions...")
+			runSSH(env, fmt.Sprintf("podman unshare chown $(id -u):$(id -g) %s %s-wal %s-shm || true", remote, remote, remote))
+		}
+
+		// 3. Keep the current DB, then put the snapshot in place (the snapshot itself is kept)
+		logInfo("🔄 Restoring snapshot...")
+		script := strings.Join([]string{
+			fmt.Sprintf("{ [ ! -f %s ] || cp %s %s.bak; }", remote, remote, remote),
+			fmt.Sprintf("cp %s %s", shellQuote(snapshot), remote),
+			fmt.Sprintf("rm -f %s-wal %s-shm", remote, remote),
+		}, " && ")
+		if err := runSSH(env, script); err != nil {
+			logFatal("Restore failed: %v", err)
+		}
+
+		// 4. Restore Permissions
+		if env.Quadlet.ContainerUID > 0 {
+			logInfo("🔧 Restoring container permissions...")
+			runSSH(env, fmt.Sprintf("podman unshare chown %d:%d %s %s.bak", env.Quadlet.ContainerUID, env.Quadlet.ContainerGID, remote, remote))
+		}
+	case "postgres":
+		logInfo("🔄 Restoring snapshot into remote database...")
+		script := fmt.Sprintf("pg_restore --clean --if-exists --no-owner --dbname=%s %s", shellQuote(env.Database.Source), shellQuote(snapshot))
+		if err := runSSH(env, script); err != nil {
+			logFatal("Restore failed: %v", err)
+		}
+	default:
+		logFatal("Unsupported database driver '%s'. Use 'sqlite' or 'postgres'.", env.Database.Driver)
+	}
+
+	logSuccess("Database restored from %s.", snapshot)
+	logInfo("ℹ️  Service remains STOPPED. Run 'deploy start %s' when ready.", envName)
+}
+
+// prepareLocalTarget asks before writing to local, backing up an existing file first.
+// With noBackup it only creates the parent directories (scripted snapshots).
+// Returns false if the user declined.
+func prepareLocalTarget(local string, noBackup bool) bool {
+	if noBackup {
+		logDebug("Skipping local backup of %s (--no-backup)", local)
+	} else if _, err := os.Stat(local); err == nil {
 		if !confirm(fmt.Sprintf("Local file %s exists. Backup and overwrite?", local)) {
-			return
+			return false
 		}
 		backup := local + ".bak"
 		logInfo("📦 Backing up local DB to %s...", backup)
@@ -31,43 +180,122 @@ func doDBPull(envName string) {
 		}
 	} else {
 		if !confirm(fmt.Sprintf("Download to %s?", local)) {
-			return
+			return false
 		}
 	}
 
 	if !dryRun {
 		os.MkdirAll(filepath.Dir(local), 0755)
 	}
+	return true
+}
+
+// ensureServiceStopped aborts if the service is running, to prevent DB corruption.
+func ensureServiceStopped(envName string, env Environment) {
+	// In dry-run, we skip this check because runSSH returns nil (success) which would trigger false positive.
+	if dryRun {
+		return
+	}
+	// systemctl is-active returns 0 (success) if running, which means err == nil
+	err := runSSH(env, fmt.Sprintf("systemctl --user is-active -q %s.service", env.Quadlet.ServiceName))
+	if err == nil {
+		logFatal("⛔ Service '%s' is RUNNING on %s.\n   You must manually stop it before pushing a database to prevent corruption.\n   Run: deploy stop %s", env.Quadlet.ServiceName, env.Host, envName)
+	}
+}
+
+// streamSSHToFile runs remoteScript over SSH and writes its stdout to local,
+// decompressing it first if gunzip is set. On failure the partial file is removed.
+func streamSSHToFile(env Environment, remoteScript, local string, gunzip bool) error {
+	if dryRun {
+		logDebug("[SSH] %s > %s", remoteScript, local)
+		return nil
+	}
 
 	f, err := os.Create(local)
 	if err != nil {
-		logFatal("Failed to create local file: %v", err)
+		return fmt.Errorf("failed to create local file: %w", err)
 	}
 	defer f.Close()
 
+	cmd := sshCommand(context.Background(), env, remoteScript)
+	cmd.Stderr = os.Stderr
+
+	if !gunzip {
+		cmd.Stdout = f
+		err = cmd.Run()
+	} else {
+		err = runGunzip(cmd, f)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(local)
+		return err
+	}
+	return nil
+}
+
+// runGunzip runs cmd and decompresses its gzip stdout into dst.
+func runGunzip(cmd *exec.Cmd, dst io.Writer) error {
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	copyErr := gunzipTo(dst, out)
+	io.Copy(io.Discard, out) // Let ssh finish even if decompression failed
+	if err := cmd.Wait(); err != nil {
+		return err
+	}
+	return copyErr
+}
+
+// gunzipTo decompresses a gzip stream from src into dst.
+func gunzipTo(dst io.Writer, src io.Reader) error {
+	zr, err := gzip.NewReader(src)
+	if err != nil {
+		return fmt.Errorf("invalid gzip stream: %w", err)
+	}
+	if _, err := io.Copy(dst, zr); err != nil {
+		return fmt.Errorf("decompression failed: %w", err)
+	}
+	return zr.Close()
+}
+
+func pullSQLite(env Environment, opts PullOptions) {
+	local := filepath.Clean(env.Database.Source)
+	if opts.Output != "" {
+		local = filepath.Clean(opts.Output)
+	}
+	if opts.Gzip && opts.KeepCompressed {
+		local += ".gz"
+	}
+	remote := fmt.Sprintf("%s/%s", strings.TrimRight(env.Dir, "/"), env.Database.Source)
+
+	logInfo("📥 Pulling DB from %s...", env.Host)
+
+	if !prepareLocalTarget(local, opts.NoBackup) {
+		return
+	}
+
+	tools, catCmd := []string{"sqlite3"}, "cat"
+	if opts.Gzip {
+		tools, catCmd = append(tools, "gzip"), "gzip -c"
+	}
+
 	// Robust Backup Strategy
 	remoteScript := fmt.Sprintf(`
 		set -e
 		TEMP_DIR=$(mktemp -d)
 		trap "rm -rf $TEMP_DIR" EXIT
-		if ! command -v sqlite3 &> /dev/null; then
-            echo "sqlite3 not found on remote" >&2
-            exit 1
-        fi
+		%s
 		sqlite3 '%s' ".backup '$TEMP_DIR/backup.db'"
-		cat "$TEMP_DIR/backup.db"
-	`, remote)
+		%s "$TEMP_DIR/backup.db"
+	`, requireRemoteTools(tools...), remote, catCmd)
 
-	sshArgs := getSSHBaseArgs(env)
-	sshArgs = append(sshArgs, remoteScript)
-
-	cmd := exec.Command("ssh", sshArgs...)
-	cmd.Stdout = f
-	cmd.Stderr = os.Stderr
-
-	if err := cmd.Run(); err != nil {
-		f.Close()
-		os.Remove(local)
+	// Only decompress locally when the .gz is not what we want to keep
+	if err := streamSSHToFile(env, remoteScript, local, opts.Gzip && !opts.KeepCompressed); err != nil {
 		logFatal("Pull failed: %v", err)
 	}
 	// Explicitly remove potential WAL/SHM files to ensure clean state with new DB
@@ -77,20 +305,12 @@ func doDBPull(envName string) {
 	logSuccess("Synced to %s", local)
 }
 
-func doDBPush(envName string) {
-	_, env := loadEnv(envName)
+func pushSQLite(envName string, env Environment) {
 	local := filepath.Clean(env.Database.Source)
 	remote := fmt.Sprintf("%s/%s", strings.TrimRight(env.Dir, "/"), env.Database.Source)
 
 	// 1. Safety Check: Is service running?
-	// In dry-run, we skip this check because runSSH returns nil (success) which would trigger false positive.
-	if !dryRun {
-		// systemctl is-active returns 0 (success) if running, which means err == nil
-		err := runSSH(env, fmt.Sprintf("systemctl --user is-active -q %s.service", env.Quadlet.ServiceName))
-		if err == nil {
-			logFatal("⛔ Service '%s' is RUNNING on %s.\n   You must manually stop it before pushing a database to prevent corruption.\n   Run: deploy stop %s", env.Quadlet.ServiceName, env.Host, envName)
-		}
-	}
+	ensureServiceStopped(envName, env)
 
 	logWarn("🔥 OVERWRITING REMOTE DB on %s.", envName)
 	if !confirm("Are you sure?") {
@@ -119,14 +339,14 @@ func doDBPush(envName string) {
 	logInfo("⏳ Creating safe local snapshot...")
 	// cleanup existing temp file if any
 	os.Remove(tempBackup)
-	
+
 	backupCmd := exec.Command("sqlite3", local, fmt.Sprintf(".backup '%s'", tempBackup))
 	if out, err := backupCmd.CombinedOutput(); err != nil {
 		logFatal("Failed to create safe local backup: %v\nOutput: %s", err, string(out))
 	}
 	defer os.Remove(tempBackup)
 
-	if err := runRsyncSafe(env, []string{tempBackup}, fmt.Sprintf("%s@%s:%s", env.User, env.Host, remote)); err != nil {
+	if err := runRsyncSafe(env, []string{tempBackup}, remotePath(env, remote)); err != nil {
 		logError("Rsync failed: %v", err)
 		logInfo("Restoring from backup...")
 		runSSH(env, fmt.Sprintf("mv %s.bak %s", remote, remote))
@@ -142,3 +362,199 @@ func doDBPush(envName string) {
 	logSuccess("Database pushed successfully.")
 	logInfo("ℹ️  Service remains STOPPED. Run 'deploy start %s' or 'deploy release %s' when ready.", envName, envName)
 }
+
+// rowCountScript prints "<table>=<rows>" for every user table of the sqlite file at path.
+func rowCountScript(path string) string {
+	db := shellQuote(path)
+	return fmt.Sprintf(`sqlite3 -readonly %s "SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%%' ORDER BY name" |
+		while IFS= read -r t; do echo "$t=$(sqlite3 -readonly %s "SELECT COUNT(*) FROM \"$t\"")"; done`, db, db)
+}
+
+// parseRowCounts reads the output of rowCountScript.
+func parseRowCounts(out string) map[string]int64 {
+	counts := map[string]int64{}
+	for _, line := range strings.Split(out, "\n") {
+		// Table names may contain '=', the count never does
+		idx := strings.LastIndex(line, "=")
+		if idx <= 0 {
+			continue
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(line[idx+1:]), 10, 64)
+		if err != nil {
+			continue
+		}
+		counts[line[:idx]] = n
+	}
+	return counts
+}
+
+// RowDiff compares one table; a count of -1 means the table is missing on that side.
+type RowDiff struct {
+	Table  string
+	Local  int64
+	Remote int64
+}
+
+// diffRowCounts joins both sides by table name, sorted by name.
+func diffRowCounts(local, remote map[string]int64) []RowDiff {
+	tables := map[string]bool{}
+	for t := range local {
+		tables[t] = true
+	}
+	for t := range remote {
+		tables[t] = true
+	}
+	var diffs []RowDiff
+	for t := range tables {
+		d := RowDiff{Table: t, Local: -1, Remote: -1}
+		if n, ok := local[t]; ok {
+			d.Local = n
+		}
+		if n, ok := remote[t]; ok {
+			d.Remote = n
+		}
+		diffs = append(diffs, d)
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Table < diffs[j].Table })
+	return diffs
+}
+
+// formatRowDiff renders one row of the 'db diff' table.
+func formatRowDiff(d RowDiff) string {
+	count := func(n int64) string {
+		if n < 0 {
+			return "-"
+		}
+		return strconv.FormatInt(n, 10)
+	}
+	delta := ""
+	switch {
+	case d.Local < 0:
+		delta = Yellow + "remote only" + Reset
+	case d.Remote < 0:
+		delta = Yellow + "local only" + Reset
+	case d.Local != d.Remote:
+		delta = fmt.Sprintf("%s%+d%s", Red, d.Remote-d.Local, Reset)
+	default:
+		delta = Green + "=" + Reset
+	}
+	return fmt.Sprintf("%-24s %10s %10s  %s", d.Table, count(d.Local), count(d.Remote), delta)
+}
+
+// doDBDiff prints per-table row counts of the local and remote sqlite databases.
+// Only the counts cross the wire, so it is a cheap check before 'db push' or 'db pull'.
+func doDBDiff(envName string) {
+	_, env := loadEnv(envName)
+	if env.Database.Driver != "sqlite" {
+		logFatal("'deploy db diff' supports the sqlite driver only (got '%s').", env.Database.Driver)
+	}
+	local := filepath.Clean(env.Database.Source)
+	remote := fmt.Sprintf("%s/%s", strings.TrimRight(env.Dir, "/"), env.Database.Source)
+
+	if _, err := os.Stat(local); err != nil {
+		logFatal("Local DB %s not found: %v", local, err)
+	}
+	localOut, err := exec.Command("sh", "-c", rowCountScript(local)).Output()
+	if err != nil {
+		logFatal("Counting local rows failed (is sqlite3 installed?): %v", err)
+	}
+
+	logInfo("🔎 Counting rows on %s...", env.Host)
+	remoteOut, err := runSSHOutput(env, requireRemoteTools("sqlite3")+"\n"+rowCountScript(remote))
+	if err != nil {
+		logFatal("Counting remote rows failed: %v", err)
+	}
+
+	diffs := diffRowCounts(parseRowCounts(string(localOut)), parseRowCounts(remoteOut))
+	if len(diffs) == 0 {
+		logInfo("Both databases have no tables.")
+		return
+	}
+	fmt.Printf("\n%-24s %10s %10s  %s\n", "TABLE", "LOCAL", envName, "DELTA")
+	for _, d := range diffs {
+		fmt.Println(formatRowDiff(d))
+	}
+}
+
+// --- PostgreSQL ---
+
+// postgresDumpPath is the local file used for postgres dumps (custom format).
+func postgresDumpPath(envName string, db DatabaseConfig) string {
+	if db.DumpFile != "" {
+		return filepath.Clean(db.DumpFile)
+	}
+	return filepath.Join("data", envName+".dump")
+}
+
+// postgresDumpScript streams a custom-format dump of the database to stdout.
+func postgresDumpScript(db DatabaseConfig) string {
+	return fmt.Sprintf(`
+		set -e
+		%s
+		pg_dump --format=custom --no-owner --dbname=%s
+	`, requireRemoteTools("pg_dump"), shellQuote(db.Source))
+}
+
+// postgresRestoreScript restores the uploaded dump and removes it afterwards.
+func postgresRestoreScript(db DatabaseConfig, dump string) string {
+	return fmt.Sprintf(`
+		set -e
+		DUMP=%s
+		trap 'rm -f "$DUMP"' EXIT
+		%s
+		pg_restore --clean --if-exists --no-owner --dbname=%s "$DUMP"
+	`, shellQuote(dump), requireRemoteTools("pg_restore"), shellQuote(db.Source))
+}
+
+func pullPostgres(envName string, env Environment, opts PullOptions) {
+	local := postgresDumpPath(envName, env.Database)
+	if opts.Output != "" {
+		local = filepath.Clean(opts.Output)
+	}
+	if opts.Gzip {
+		logWarn("--gzip ignored: pg_dump's custom format is already compressed")
+	}
+
+	logInfo("📥 Pulling Postgres dump from %s...", env.Host)
+
+	if !prepareLocalTarget(local, opts.NoBackup) {
+		return
+	}
+
+	if err := streamSSHToFile(env, postgresDumpScript(env.Database), local, false); err != nil {
+		logFatal("Pull failed: %v", err)
+	}
+
+	logSuccess("Dumped to %s", local)
+}
+
+func pushPostgres(envName string, env Environment) {
+	local := postgresDumpPath(envName, env.Database)
+	if _, err := os.Stat(local); err != nil && !dryRun {
+		logFatal("Local dump %s not found. Run 'deploy db pull' or create it with pg_dump --format=custom.", local)
+	}
+
+	// 1. Safety Check: Is service running?
+	ensureServiceStopped(envName, env)
+
+	logWarn("🔥 OVERWRITING REMOTE DB on %s.", envName)
+	if !confirm("Are you sure?") {
+		return
+	}
+
+	// 2. Upload dump
+	logInfo("📤 Uploading dump...")
+	remoteDump := fmt.Sprintf("%s/.db-restore.dump", strings.TrimRight(env.Dir, "/"))
+	if err := runRsyncSafe(env, []string{local}, remotePath(env, remoteDump)); err != nil {
+		logFatal("Rsync failed: %v", err)
+	}
+
+	// 3. Restore
+	logInfo("🔄 Restoring into remote database...")
+	if err := runSSH(env, postgresRestoreScript(env.Database, remoteDump)); err != nil {
+		logFatal("Restore failed: %v", err)
+	}
+
+	logSuccess("Database pushed successfully.")
+	logInfo("ℹ️  Service remains STOPPED. Run 'deploy start %s' or 'deploy release %s' when ready.", envName, envName)
+}
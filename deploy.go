@@ -2,55 +2,272 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
+
+	"golang.org/x/mod/semver"
 )
 
-func doRelease(explicitVersion, envName string) {
-	// 0. Resolve Version (Strict or Lazy)
-	version := resolveAndValidateVersion(explicitVersion)
+// versionMarkerFile lives in target_dir and holds the currently deployed version.
+const versionMarkerFile = ".deploy-version"
+
+// commitMarkerFile holds the git commit of the deployed version (release --if-changed).
+// A rollback removes it, so the next --if-changed release always deploys.
+const commitMarkerFile = ".deploy-commit"
+
+// lockFile lives in target_dir while a release is in progress.
+const lockFile = ".deploy.lock"
+
+// ReleaseOptions holds the per-invocation flags of 'deploy release'.
+type ReleaseOptions struct {
+	ForceUnlock bool
+	SkipMigrate bool
+	SkipBuild   bool // Reuse an existing build/<binary> (e.g. built by CI)
+	NoRollback  bool // Keep a failed deploy in place for debugging
+	Parallel    int  // Max concurrent environments for multi-env releases
+	Rolling     bool // Keep a transient instance of the old version serving during the restart
+	IfChanged   bool // Skip environments already running the current commit
+
+	// Kill build/ssh/rsync and roll back after this long (0 = no limit)
+	Timeout time.Duration
+	// Sync this .env instead of sync_env_file/secrets (build once, deploy many)
+	EnvFile string
+	// Release a dirty tree as <version>-dirty instead of failing (e.g. staging hotfixes)
+	ForceDirty bool
+}
 
-	cfg, env := loadEnv(envName)
+// rollbackTimeout bounds the rollback that follows an expired --timeout.
+const rollbackTimeout = 5 * time.Minute
 
-	if _, err := exec.LookPath("rsync"); err != nil {
-		logFatal("Local rsync missing")
+// doRelease resolves the version, builds once and deploys to the target environment(s).
+// Errors are returned to the caller; main decides how to exit.
+func doRelease(explicitVersion, target string, opts ReleaseOptions) error {
+	cfg, err := readConfig()
+	if err != nil {
+		return err
+	}
+	envNames, err := releaseTargets(cfg, target)
+	if err != nil {
+		return err
+	}
+	if opts.EnvFile != "" {
+		if _, err := os.Stat(opts.EnvFile); err != nil {
+			return fmt.Errorf("--env-file: %w", err)
+		}
+	}
+	if err := checkGitRefs(cfg, envNames); err != nil {
+		return err
 	}
 
-	// Pre-flight checks
-	logInfo("🔍 Verifying remote environment on %s...", env.Host)
-	if err := runSSH(env, "command -v rsync >/dev/null && command -v podman >/dev/null"); err != nil {
-		logFatal("Remote check failed: 'rsync' and 'podman' are required on the host.")
+	// 0. Resolve Version (Strict or Lazy) once, before any per-env work
+	var version string
+	if opts.ForceDirty && getCmdOutput("git", "status", "--porcelain") != "" {
+		version = dirtyVersion(explicitVersion)
+		logWarn("⚠️  Working tree is dirty; releasing %s without tagging (--force-dirty).", version)
+	} else if version, err = resolveAndValidateVersion(explicitVersion); err != nil {
+		return err
 	}
 
-	logInfo("🚀 Deploying version %s to %s (%s)...", version, cfg.AppName, envName)
+	if opts.IfChanged {
+		envNames = changedEnvs(cfg, envNames)
+		if len(envNames) == 0 {
+			logSuccess("Already deployed: every target runs commit %s.", shortCommit(localCommit()))
+			return nil
+		}
+	}
 
-	if !dryRun {
-		os.MkdirAll("build", 0755)
+	ctx := context.Background()
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
 	}
 
 	// 1. Build
-	arch := cfg.Build.Arch
-	if arch == "" {
-		arch = "amd64"
+	if opts.SkipBuild {
+		if err := checkPrebuiltBinary(cfg); err != nil && !dryRun {
+			return err
+		}
+		logInfo("⏭️  Skipping build, reusing build/%s", cfg.BinaryName)
+	} else if err := buildBinary(ctx, cfg, version); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("build aborted after --timeout %s: %w", opts.Timeout, err)
+		}
+		return err
+	}
+
+	if len(envNames) == 1 {
+		return releaseEnv(ctx, version, envNames[0], opts)
+	}
+	return doReleaseMany(ctx, version, envNames, opts)
+}
+
+// withEnvFile makes a release --env-file replace the env's sync_env_file and secrets.
+func withEnvFile(env Environment, path string) Environment {
+	if path != "" {
+		env.SyncEnvFile = path
+		env.Secrets = SecretsConfig{}
+	}
+	return env
+}
+
+// dirtyVersion is the version of a --force-dirty build: the given version or 'git
+// describe' of HEAD, marked with -dirty. No tag is created for it.
+func dirtyVersion(explicitVersion string) string {
+	v := explicitVersion
+	if v == "" {
+		v = getCmdOutput("git", "describe", "--tags", "--always")
+	}
+	if v == "" {
+		v = "v0.0.0"
+	}
+	return strings.TrimSuffix(v, "-dirty") + "-dirty"
+}
+
+// doTagOnly runs just the version resolution of a release (validate, or create and push
+// the tag) and prints the version, so the deploy can follow later, e.g. from CI.
+func doTagOnly(explicitVersion string) error {
+	version, err := resolveAndValidateVersion(explicitVersion)
+	if err != nil {
+		return err
+	}
+	logSuccess("🏷️  %s is tagged; deploy it with 'deploy release %s <env>'.", version, version)
+	fmt.Println(version)
+	return nil
+}
+
+// headOnRef reports whether HEAD is ref's tip or one of its ancestors, i.e. part of
+// what was merged into ref.
+func headOnRef(ref string) (bool, error) {
+	if err := exec.Command("git", "rev-parse", "--verify", "--quiet", ref+"^{commit}").Run(); err != nil {
+		return false, fmt.Errorf("git_ref '%s' not found locally", ref)
+	}
+	err := exec.Command("git", "merge-base", "--is-ancestor", "HEAD", ref).Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// checkGitRefs aborts the release when a target env pins git_ref and HEAD is not on it,
+// e.g. a staging branch released to prod.
+func checkGitRefs(cfg Config, envNames []string) error {
+	if dryRun {
+		return nil
+	}
+	for _, name := range envNames {
+		env, err := resolveEnv(cfg, name)
+		if err != nil || env.GitRef == "" {
+			continue
+		}
+		ok, err := headOnRef(env.GitRef)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if !ok {
+			return fmt.Errorf("🚫 HEAD (%s) is not on '%s', which env '%s' requires (git_ref)", shortCommit(localCommit()), env.GitRef, name)
+		}
+	}
+	return nil
+}
+
+// localCommit is the checked-out commit, or "" outside a git repository.
+func localCommit() string {
+	return getCmdOutput("git", "rev-parse", "HEAD")
+}
+
+// shortCommit abbreviates a commit hash for log output.
+func shortCommit(c string) string {
+	if len(c) > 12 {
+		return c[:12]
+	}
+	return c
+}
+
+// changedEnvs drops the environments whose commit marker matches HEAD. Commits are
+// compared instead of versions, so a moved tag is still deployed. A dirty working tree
+// or an unknown commit always counts as changed.
+func changedEnvs(cfg Config, envNames []string) []string {
+	commit := localCommit()
+	if commit == "" {
+		logWarn("--if-changed: not a git repository, deploying anyway.")
+		return envNames
+	}
+	if getCmdOutput("git", "status", "--porcelain", "--untracked-files=no") != "" {
+		logWarn("--if-changed: working tree has uncommitted changes, deploying anyway.")
+		return envNames
+	}
+	var changed []string
+	for _, name := range envNames {
+		env, err := resolveEnv(cfg, name)
+		if err != nil {
+			changed = append(changed, name)
+			continue
+		}
+		deployed, _ := runSSHOutput(env, fmt.Sprintf("cat %s/%s 2>/dev/null", env.Dir, commitMarkerFile))
+		if deployed == commit {
+			logInfo("⏭️  %s already runs commit %s, skipping.", name, shortCommit(commit))
+			continue
+		}
+		changed = append(changed, name)
 	}
-	logInfo("🔨 Building binary (%s)...", arch)
+	return changed
+}
+
+// buildBinary compiles the release binary once per configured arch; every target
+// environment ships the same artifact.
+func buildBinary(ctx context.Context, cfg Config, version string) error {
+	arches, err := buildArches(cfg)
+	if err != nil {
+		return err
+	}
+	goos, err := buildOS(cfg)
+	if err != nil {
+		return err
+	}
+	if goos != "linux" {
+		logWarn("⚠️  build.os is '%s': the quadlet/podman activation assumes a Linux host.", goos)
+	}
+	if !dryRun {
+		os.MkdirAll("build", 0755)
+	}
+	for _, arch := range arches {
+		if err := buildBinaryArch(ctx, cfg, version, goos, arch, binaryOutput(cfg, arch)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func buildBinaryArch(ctx context.Context, cfg Config, version, goos, arch, output string) error {
+	logInfo("🔨 Building binary (%s/%s)...", goos, arch)
 
 	buildMeta := getBuildMetadata(version)
+	buildMeta.Arch = arch
+	buildMeta.Output = output
 	var ldflags string
 	if cfg.Build.Ldflags != "" {
 		tmpl, err := template.New("ld").Parse(cfg.Build.Ldflags)
 		if err != nil {
-			logFatal("LDFLAGS template error: %v", err)
+			return fmt.Errorf("LDFLAGS template error: %w", err)
 		}
 		var buf bytes.Buffer
 		if err := tmpl.Execute(&buf, buildMeta); err != nil {
-			logFatal("LDFLAGS exec: %v", err)
+			return fmt.Errorf("LDFLAGS exec: %w", err)
 		}
 		ldflags = buf.String()
 	} else {
@@ -64,144 +281,592 @@ func doRelease(explicitVersion, envName string) {
 		// Parse the command string as a template
 		tmpl, err := template.New("cmd").Parse(cfg.Build.Cmd)
 		if err != nil {
-			logFatal("Custom CMD template error: %v", err)
+			return fmt.Errorf("custom CMD template error: %w", err)
 		}
 		var buf bytes.Buffer
 		if err := tmpl.Execute(&buf, buildMeta); err != nil {
-			logFatal("Custom CMD exec error: %v", err)
+			return fmt.Errorf("custom CMD exec error: %w", err)
 		}
 		finalCmd := buf.String()
 
 		logDebug("   Exec: %s", finalCmd)
 
-		cmd = exec.Command("sh", "-c", finalCmd)
+		cmd = commandContext(ctx, "sh", "-c", finalCmd)
 		cmd.Env = os.Environ()
-		cmd.Env = append(cmd.Env, fmt.Sprintf("LDFLAGS=%s", ldflags))
+		cmd.Env = append(cmd.Env, fmt.Sprintf("LDFLAGS=%s", ldflags), "GOOS="+goos, "GOARCH="+arch, "OUTPUT="+output)
 	} else {
 		srcDir := "."
 		if cfg.Build.Dir != "" {
 			srcDir = cfg.Build.Dir
 		}
-		output := fmt.Sprintf("build/%s", cfg.BinaryName)
-		cmd = exec.Command("go", "build", "-ldflags", ldflags, "-o", output, srcDir)
-		cmd.Env = append(os.Environ(), "CGO_ENABLED=0", "GOOS=linux", "GOARCH="+arch)
+		cmd = commandContext(ctx, "go", "build", "-ldflags", ldflags, "-o", output, srcDir)
+		cmd.Env = append(os.Environ(), "CGO_ENABLED=0", "GOOS="+goos, "GOARCH="+arch)
 	}
 
 	if err := runCommand("Build", cmd); err != nil {
-		logFatal("Build failed: %v", err)
+		return fmt.Errorf("build failed: %w", err)
+	}
+	return nil
+}
+
+// checkPrebuiltBinary makes sure --skip-build has something to ship.
+func checkPrebuiltBinary(cfg Config) error {
+	arches, err := buildArches(cfg)
+	if err != nil {
+		return err
+	}
+	for _, arch := range arches {
+		bin := binaryOutput(cfg, arch)
+		if _, err := os.Stat(bin); err != nil {
+			return fmt.Errorf("--skip-build: %s not found, build it first", bin)
+		}
+	}
+	return nil
+}
+
+var supportedArches = []string{"amd64", "arm64"}
+
+func buildArch(cfg Config) string {
+	if cfg.Build.Arch == "" {
+		return "amd64"
+	}
+	return cfg.Build.Arch
+}
+
+// buildArches returns the validated arches to build: build.arches, or the single build.arch.
+func buildArches(cfg Config) ([]string, error) {
+	arches := cfg.Build.Arches
+	if len(arches) == 0 {
+		arches = []string{buildArch(cfg)}
+	}
+	for _, a := range arches {
+		if !slices.Contains(supportedArches, a) {
+			return nil, fmt.Errorf("unsupported arch '%s' (use %s)", a, strings.Join(supportedArches, " or "))
+		}
+	}
+	return arches, nil
+}
+
+// supportedOS are the GOOS values of 'go tool dist list'.
+var supportedOS = []string{"aix", "android", "darwin", "dragonfly", "freebsd", "illumos", "ios", "js", "linux", "netbsd", "openbsd", "plan9", "solaris", "wasip1", "windows"}
+
+// buildOS returns the validated build.os, linux by default.
+func buildOS(cfg Config) (string, error) {
+	if cfg.Build.OS == "" {
+		return "linux", nil
+	}
+	if !slices.Contains(supportedOS, cfg.Build.OS) {
+		return "", fmt.Errorf("unsupported build.os '%s' (use one of %s)", cfg.Build.OS, strings.Join(supportedOS, ", "))
+	}
+	return cfg.Build.OS, nil
+}
+
+// platformFlag pins remote podman builds to the env's arch (" --platform=linux/arm64").
+func platformFlag(env Environment) string {
+	if env.Arch == "" {
+		return ""
+	}
+	return " --platform=linux/" + env.Arch
+}
+
+// binaryOutput is build/<binary>, or build/<binary>-<arch> for multi-arch builds.
+func binaryOutput(cfg Config, arch string) string {
+	if len(cfg.Build.Arches) == 0 {
+		return filepath.Join("build", cfg.BinaryName)
+	}
+	return filepath.Join("build", cfg.BinaryName+"-"+arch)
+}
+
+// envArch picks the arch an environment runs: its own 'arch', else the first built one.
+func envArch(cfg Config, env Environment) (string, error) {
+	arches, err := buildArches(cfg)
+	if err != nil {
+		return "", err
+	}
+	if env.Arch == "" {
+		return arches[0], nil
+	}
+	if !slices.Contains(arches, env.Arch) {
+		return "", fmt.Errorf("env arch '%s' is not built (build arches: %s)", env.Arch, strings.Join(arches, ", "))
+	}
+	return env.Arch, nil
+}
+
+// releaseTargets expands "all" or a comma separated list into environment names,
+// failing early on unknown names so no deploy starts half-configured.
+func releaseTargets(cfg Config, target string) ([]string, error) {
+	if target == "all" {
+		names := sortedEnvNames(cfg)
+		if len(names) == 0 {
+			return nil, fmt.Errorf("no environments defined in deploy.yaml")
+		}
+		return names, nil
+	}
+	var names []string
+	for _, n := range strings.Split(target, ",") {
+		n = strings.TrimSpace(n)
+		if n == "" {
+			continue
+		}
+		if _, ok := cfg.Environments[n]; !ok {
+			return nil, fmt.Errorf("env %s not found", n)
+		}
+		names = append(names, n)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no environment given")
+	}
+	return names, nil
+}
+
+type releaseResult struct {
+	Env      string
+	Err      error
+	Duration time.Duration
+}
+
+// doReleaseMany releases to several environments with a bounded worker pool.
+// A failing environment does not stop the others; the exit code reflects any failure.
+func doReleaseMany(ctx context.Context, version string, envNames []string, opts ReleaseOptions) error {
+	parallel := opts.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+	logInfo("🚀 Releasing %s to %d environments (%d in parallel)...", version, len(envNames), parallel)
+
+	results := make([]releaseResult, len(envNames))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, name := range envNames {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			start := time.Now()
+			err := releaseEnv(ctx, version, name, opts)
+			results[i] = releaseResult{Env: name, Err: err, Duration: time.Since(start)}
+		}(i, name)
+	}
+	wg.Wait()
+
+	failed := 0
+	fmt.Printf("\n%-16s %-8s %-10s %s\n", "ENVIRONMENT", "RESULT", "DURATION", "ERROR")
+	for _, r := range results {
+		status, msg := "OK", ""
+		if r.Err != nil {
+			status, msg = "FAILED", r.Err.Error()
+			failed++
+		}
+		fmt.Printf("%-16s %-8s %-10s %s\n", r.Env, status, r.Duration.Round(time.Second), msg)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d environments failed", failed, len(envNames))
+	}
+	logSuccess("✅ Released %s to all %d environments.", version, len(envNames))
+	return nil
+}
+
+// releaseEnv deploys an already resolved version to one environment.
+// It returns errors instead of exiting so several environments can be released concurrently.
+func releaseEnv(ctx context.Context, version, envName string, opts ReleaseOptions) error {
+	cfg, err := readConfig()
+	if err != nil {
+		return err
+	}
+	env, err := resolveEnv(cfg, envName)
+	if err != nil {
+		return err
+	}
+	env = withEnvFile(env, opts.EnvFile)
+
+	if _, err := exec.LookPath("rsync"); err != nil {
+		return fmt.Errorf("local rsync missing")
+	}
+
+	// Pre-flight checks
+	logInfo("🔍 Verifying remote environment on %s...", env.Host)
+	if err := runSSHContext(ctx, env, requireRemoteTools("rsync", "podman")); err != nil {
+		return fmt.Errorf("remote check failed: 'rsync' and 'podman' are required on %s (run 'deploy doctor %s')", env.Host, envName)
+	}
+
+	if err := ensureNetwork(ctx, env); err != nil {
+		return err
+	}
+	if err := checkDiskSpace(ctx, env); err != nil {
+		return err
+	}
+
+	// Only one release per environment at a time
+	unlock, err := acquireDeployLock(ctx, env, opts.ForceUnlock)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	logInfo("🚀 Deploying version %s to %s (%s)...", version, cfg.AppName, envName)
+	if opts.SkipMigrate && env.Migrate.Cmd != "" {
+		logWarn("⏭️  Skipping migrations (--skip-migrate)")
+		env.Migrate.Cmd = ""
+	}
+
+	arch, err := envArch(cfg, env)
+	if err != nil {
+		return err
+	}
+	env.Arch = arch
+	dockerfile := dockerfileFor(env)
+	outDir := filepath.Join("build", envName)
+
+	// Multi-arch: stage this env's binary under its plain name so it lands as target_dir/<binary>
+	localBinary := binaryOutput(cfg, arch)
+	if len(cfg.Build.Arches) > 0 && !env.Quadlet.PullImage {
+		staged := filepath.Join(outDir, cfg.BinaryName)
+		if !dryRun {
+			os.MkdirAll(outDir, 0755)
+			if err := copyFile(localBinary, staged); err != nil {
+				return fmt.Errorf("staging %s: %w", localBinary, err)
+			}
+			os.Chmod(staged, 0755)
+		}
+		localBinary = staged
+	}
+
+	// 1b. Registry Mode: build & push the image locally, the server only pulls it
+	pullMode := env.Quadlet.PullImage
+	if pullMode {
+		if cfg.Build.Registry == "" {
+			return fmt.Errorf("quadlet.pull_image requires build.registry to be set")
+		}
+		env.Quadlet.Image = registryImage(cfg.Build.Registry, cfg.AppName, version)
+		logInfo("📦 Building and pushing image %s...", env.Quadlet.Image)
+		if err := runCommand("Image Build", commandContext(ctx, "podman", "build", "--platform", "linux/"+arch, "-f", dockerfile, "-t", env.Quadlet.Image, ".")); err != nil {
+			return fmt.Errorf("image build failed: %w", err)
+		}
+		if err := runCommand("Image Push", commandContext(ctx, "podman", "push", env.Quadlet.Image)); err != nil {
+			return fmt.Errorf("image push failed: %w", err)
+		}
 	}
 
 	// 2. Generate Configuration
 	logInfo("📄 Generating configuration...")
-	// 2. Generate Configuration
-	logInfo("📄 Generating configuration...")
-	env.Quadlet.Labels = generateTraefikLabels(env.Quadlet.ServiceName, env.Quadlet.Router, "myresolver")
-	containerPath := generateQuadlet(env, "build")
+	for _, svc := range append([]Quadlet{env.Quadlet}, env.Sidecars...) {
+		for _, p := range routerProblems(svc.Router) {
+			logWarn("⚠️  %s: %s", svc.ServiceName, p)
+		}
+	}
+	env.Quadlet.Labels = generateTraefikLabels(env.Quadlet.ServiceName, routerWithHealthPath(env.Quadlet), "myresolver", env.AuthProvider)
+	// The quadlet pins the versioned tag; ':latest' keeps tracking the newest build.
+	// (Registry images are already versioned.)
+	imageTag := env.Quadlet.Image
+	if !pullMode {
+		imageTag = versionedImage(env.Quadlet.Image, version)
+	}
+	quadletEnv := env
+	quadletEnv.Quadlet.Image = imageTag
+	quadlets, err := generateSidecarQuadlets(env, outDir)
+	if err != nil {
+		return err
+	}
+	mainPath, mainContent := generateQuadlet(quadletEnv, outDir)
+	quadlets = append(quadlets, quadletFile{Service: env.Quadlet.ServiceName, Path: mainPath, Content: mainContent})
+	if dryRun {
+		previewQuadlets(env, quadlets)
+	}
+	var containerPaths []string
+	for _, q := range quadlets {
+		containerPaths = append(containerPaths, q.Path)
+	}
+	if env.Pod.Enabled {
+		podPath, podContent := generatePodQuadlet(env, outDir)
+		if dryRun {
+			fmt.Printf("\n--- %s (rendered) ---\n%s", filepath.Base(podPath), podContent)
+		}
+		containerPaths = append(containerPaths, podPath)
+	}
 
 	// --- OPTIONAL: Stop Service Early ---
 	if env.Quadlet.StopOnDeploy {
 		logInfo("🛑 Stopping service before sync/build (stop_on_deploy=true)...")
 		// We ignore errors here in case the service isn't running yet
-		runSSH(env, fmt.Sprintf("systemctl --user stop %s.service || true", env.Quadlet.ServiceName))
+		runSSHContext(ctx, env, fmt.Sprintf("systemctl --user stop %s.service || true", env.Quadlet.ServiceName))
 	}
 	// ------------------------------------
 
 	// 3. Sync
+	binPath := fmt.Sprintf("%s/%s", env.Dir, cfg.BinaryName)
+	meta := getBuildMetadata(version)
+	if err := syncRelease(ctx, cfg, env, meta, localBinary, containerPaths); err != nil {
+		return err
+	}
+
+	// 4. Activate
+	logInfo("🔄 Activating...")
+	script := activationScript(env, version, meta.Commit, imageTag, dockerfile)
+
+	notifyPayload := NotifyPayload{App: cfg.AppName, Env: envName, Version: version, Commit: meta.Commit}
+
+	// failRelease either rolls back or, with --no-rollback, leaves the broken state for inspection
+	failRelease := func(reason string) error {
+		rbCtx := ctx
+		if ctx.Err() != nil {
+			// The deadline has passed; rollback and history get a fresh one
+			reason = fmt.Sprintf("%s, --timeout %s exceeded", reason, opts.Timeout)
+			var cancel context.CancelFunc
+			rbCtx, cancel = context.WithTimeout(context.Background(), rollbackTimeout)
+			defer cancel()
+		}
+		if opts.NoRollback {
+			printJournalTail(rbCtx, env)
+			notifyPayload.Status = "failed"
+			recordHistory(rbCtx, env, version, notifyPayload.Commit, notifyPayload.Status)
+			sendNotification(cfg.Notify, notifyPayload)
+			return fmt.Errorf("deployment failed (%s); new version left in place (--no-rollback)", reason)
+		}
+		if rbErr := rollback(rbCtx, env, binPath, dockerfile); rbErr != nil {
+			return rbErr
+		}
+		notifyPayload.Status = "rolled_back"
+		recordHistory(rbCtx, env, version, notifyPayload.Commit, notifyPayload.Status)
+		sendNotification(cfg.Notify, notifyPayload)
+		return fmt.Errorf("deployment failed (%s) but successfully rolled back", reason)
+	}
+
+	if opts.Rolling {
+		if image := runningImage(ctx, env); image == "" && !dryRun {
+			logWarn("🔀 %s is not running, nothing to keep serving; doing a plain restart.", env.Quadlet.ServiceName)
+		} else if stop, err := startRollingInstance(ctx, env, image); err != nil {
+			return fmt.Errorf("rolling release aborted before activation: %w", err)
+		} else {
+			// Also covers the rollback, which restarts the service once more
+			defer stop()
+		}
+	}
+
+	if err := runSSHContext(ctx, env, script); err != nil {
+		logError("Activation failed: %v", err)
+		return failRelease("activation")
+	}
+
+	// 5. App Health Check
+	if checkScript, target := healthCheckScript(env.Quadlet); checkScript != "" {
+		logInfo("🩺 Performing Application Health Check (%s)...", target)
+		if err := runSSHContext(ctx, env, checkScript); err != nil {
+			logError("Health Check failed!")
+			return failRelease("unhealthy")
+		}
+	}
+
+	logSuccess("✅ Deployed successfully.")
+	notifyPayload.Status = "success"
+	recordHistory(ctx, env, version, notifyPayload.Commit, notifyPayload.Status)
+	sendNotification(cfg.Notify, notifyPayload)
+	return nil
+}
+
+// syncRelease rotates the remote backups and uploads artifacts, .env and the quadlet.
+func syncRelease(ctx context.Context, cfg Config, env Environment, meta BuildMetadata, localBinary string, containerPaths []string) error {
 	logInfo("📤 Syncing...")
-	runSSH(env, fmt.Sprintf("mkdir -p %s/data %s/migrations ~/.config/containers/systemd", env.Dir, env.Dir))
+	if err := runSSHContext(ctx, env, fmt.Sprintf("mkdir -p %s/data %s/migrations ~/.config/containers/systemd", env.Dir, env.Dir)); err != nil {
+		return fmt.Errorf("creating remote directories failed: %w", err)
+	}
 
 	binPath := fmt.Sprintf("%s/%s", env.Dir, cfg.BinaryName)
-	// Create backup
-	runSSH(env, fmt.Sprintf("[ -f %s ] && cp %s %s.bak || true", binPath, binPath, binPath))
-
-	artifacts := []string{}
-	artifacts = append(artifacts, "build/"+cfg.BinaryName)
-	if len(cfg.Artifacts.Include) > 0 {
-		artifacts = append(artifacts, cfg.Artifacts.Include...)
-	} else {
-		artifacts = append(artifacts, "Dockerfile.vps", "migrations/", "files/")
+	// Rotate backups (binary + every unit) for rollback: .bak.1 is the newest
+	for _, p := range append([]string{binPath}, remoteUnitPaths(env)...) {
+		if err := runSSHContext(ctx, env, backupRotateScript(p, env.KeepBackups)); err != nil {
+			return fmt.Errorf("rotating backups of %s failed: %w", p, err)
+		}
 	}
 
-	runRsync(env, artifacts, fmt.Sprintf("%s@%s:%s/", env.User, env.Host, env.Dir), "--delete")
+	artifacts := releaseArtifacts(cfg, env, localBinary)
+
+	// Binary and includes share target_dir, so they go in one rsync
+	if err := runRsyncContext(ctx, env, artifacts, remotePath(env, env.Dir+"/"), artifactRsyncArgs(cfg)...); err != nil {
+		return fmt.Errorf("rsync failed: %w", err)
+	}
 
-	if env.SyncEnvFile != "" {
+	envFile, cleanup, ok, err := localEnvFile(env)
+	if err != nil {
+		return fmt.Errorf(".env sync: %w", err)
+	}
+	if ok {
+		defer cleanup()
+		// Plain files pass through; files with {{ }} are rendered with the build metadata
+		if !dryRun || env.SyncEnvFile != "" {
+			rendered, cleanupRendered, err := renderEnvFile(envFile, EnvTemplateData{BuildMetadata: meta, Vars: env.EnvTemplateVars})
+			if err != nil {
+				return fmt.Errorf(".env sync: %w", err)
+			}
+			defer cleanupRendered()
+			envFile = rendered
+		}
 		// Confirm before overwriting env file
-		if confirm(fmt.Sprintf("Sync/Overwrite remote .env with local '%s'?", env.SyncEnvFile)) {
-			runRsync(env, []string{env.SyncEnvFile}, fmt.Sprintf("%s@%s:%s/.env", env.User, env.Host, env.Dir))
+		if confirm(fmt.Sprintf("Sync/Overwrite remote .env with local '%s'?", displayEnvFile(env))) {
+			if err := pushEnvFile(ctx, env, envFile); err != nil {
+				return err
+			}
 		} else {
 			logInfo("Skipping .env sync.")
 		}
 	}
-	runRsync(env, []string{containerPath}, fmt.Sprintf("%s@%s:~/.config/containers/systemd/", env.User, env.Host))
+	// Last: a failed sync must not leave a new unit next to the old binary
+	if err := runRsyncContext(ctx, env, containerPaths, remotePath(env, "~/.config/containers/systemd/")); err != nil {
+		return fmt.Errorf("rsync failed: %w", err)
+	}
+	return nil
+}
 
-	// 4. Activate
-	logInfo("🔄 Activating...")
+// artifactRsyncArgs are the flags of the artifact sync into target_dir. A "dir/" include
+// merges into target_dir itself, so --delete would reach everything there: the runtime
+// state the tool keeps next to the artifacts is protected from it.
+func artifactRsyncArgs(cfg Config) []string {
+	args := append(rsyncExcludeArgs(cfg.Artifacts.Exclude), "--delete")
+	for _, p := range []string{"/.deploy*", "/.env", "/.env.*", "/data/", "/backups/", "/maintenance/", "/.db-restore.dump", "/" + cfg.BinaryName + ".bak.*"} {
+		args = append(args, "--filter=P "+p)
+	}
+	return args
+}
+
+// releaseArtifacts lists the local paths syncRelease uploads to target_dir.
+func releaseArtifacts(cfg Config, env Environment, localBinary string) []string {
+	artifacts := []string{}
+	if !env.Quadlet.PullImage {
+		// In registry mode the binary ships inside the image
+		artifacts = append(artifacts, localBinary)
+	}
+	if len(cfg.Artifacts.Include) > 0 {
+		return append(artifacts, cfg.Artifacts.Include...)
+	}
+	return append(artifacts, "Dockerfile.vps", "migrations/", "files/")
+}
+
+// activationScript builds (or pulls) the image, reloads systemd, restarts the service
+// and records the deployed version and commit.
+func activationScript(env Environment, version, commit, imageTag, dockerfile string) string {
 	permCmd := "true"
-	if env.Quadlet.ContainerUID > 0 && len(env.Quadlet.ChownVolumes) > 0 {
-		var paths []string
-		for _, p := range env.Quadlet.ChownVolumes {
-			if strings.HasPrefix(p, "./") {
-				p = fmt.Sprintf("%s/%s", strings.TrimRight(env.Dir, "/"), strings.TrimPrefix(p, "./"))
-			}
-			paths = append(paths, p)
-		}
-		if len(paths) > 0 {
-			permCmd = fmt.Sprintf("podman unshare chown -R %d:%d %s", env.Quadlet.ContainerUID, env.Quadlet.ContainerGID, strings.Join(paths, " "))
-		}
+	if paths := chownPaths(env); env.Quadlet.ContainerUID > 0 && len(paths) > 0 {
+		permCmd = fmt.Sprintf("podman unshare chown -R %d:%d %s", env.Quadlet.ContainerUID, env.Quadlet.ContainerGID, strings.Join(paths, " "))
 	}
 
-	dockerfile := env.Quadlet.Dockerfile
-	if dockerfile == "" {
-		dockerfile = "Dockerfile.vps"
+	imageCmd := fmt.Sprintf("podman build%s -f %s -t %s -t %s .", platformFlag(env), dockerfile, imageTag, env.Quadlet.Image)
+	if env.Quadlet.PullImage {
+		imageCmd = fmt.Sprintf("podman pull %s", env.Quadlet.Image)
 	}
 
-	// Note: 'restart' works even if the service was stopped earlier.
-	script := strings.Join([]string{
+	steps := []string{
 		fmt.Sprintf("cd %s", env.Dir),
-		fmt.Sprintf("podman build -f %s -t %s .", dockerfile, env.Quadlet.Image),
+		imageCmd,
 		permCmd,
 		"systemctl --user daemon-reload",
 		"mkdir -p ~/.config/systemd/user/default.target.wants",
-		// Enable Main Service
-		fmt.Sprintf("ln -sf /run/user/$(id -u)/systemd/generator/%s.service ~/.config/systemd/user/default.target.wants/%s.service", env.Quadlet.ServiceName, env.Quadlet.ServiceName),
-		"systemctl --user daemon-reload",
+	}
+	// Enable sidecars and the main service
+	services := append(sidecarServices(env), env.Quadlet.ServiceName)
+	for _, svc := range services {
+		steps = append(steps, fmt.Sprintf("ln -sf /run/user/$(id -u)/systemd/generator/%s.service ~/.config/systemd/user/default.target.wants/%s.service", svc, svc))
+	}
+	steps = append(steps, "systemctl --user daemon-reload")
+	if env.Migrate.Cmd != "" {
+		// Runs in the new image before the restart; a failure aborts and rolls back
+		steps = append(steps, migrateCommand(env, imageTag))
+	}
+	// Sidecars first so the app finds them on start
+	for _, svc := range sidecarServices(env) {
+		steps = append(steps, fmt.Sprintf("systemctl --user restart %s.service", svc))
+	}
+
+	// Note: 'restart' works even if the service was stopped earlier.
+	return strings.Join(append(steps,
 		fmt.Sprintf("systemctl --user restart %s.service", env.Quadlet.ServiceName),
 		fmt.Sprintf("sleep 2 && systemctl --user is-active %s.service", env.Quadlet.ServiceName),
-	}, " && ")
+		// Record what is running, read back by 'deploy status'
+		fmt.Sprintf("echo %s > %s/%s", shellQuote(version), env.Dir, versionMarkerFile),
+		fmt.Sprintf("echo %s > %s/%s", shellQuote(commit), env.Dir, commitMarkerFile),
+	), " && ")
+}
 
-	if err := runSSH(env, script); err != nil {
-		logError("Activation failed: %v", err)
-		rollback(env, binPath, dockerfile)
-		logFatal("Deployment failed but successfully rolled back.")
+// healthCheckScript returns the remote retry loop for the app health check and what it probes.
+// health_url wins over health_tcp; an empty script means no check is configured.
+func healthCheckScript(q Quadlet) (string, string) {
+	var probe, target string
+	switch {
+	case q.HealthURL != "":
+		target = q.HealthURL
+		probe = fmt.Sprintf(`curl -s -f %s > /dev/null`, shellQuote(q.HealthURL))
+	case q.HealthTCP != "":
+		host, port, err := splitHealthTCP(q.HealthTCP)
+		if err != nil {
+			// resolveEnv rejects this; never put an unchecked port into the script
+			return "", ""
+		}
+		target = fmt.Sprintf("tcp://%s:%d", host, port)
+		// Prefer nc, fall back to bash's /dev/tcp (host and port passed as $0/$1, not spliced in)
+		probe = fmt.Sprintf(`{ if command -v nc > /dev/null; then nc -z -w 2 %s %d; else timeout 2 bash -c '</dev/tcp/$0/$1' %s %d; fi; } 2>/dev/null`,
+			shellQuote(host), port, shellQuote(host), port)
+	default:
+		return "", ""
 	}
 
-	// 5. App Health Check
-	if env.Quadlet.HealthURL != "" {
-		logInfo("🩺 Performing Application Health Check (%s)...", env.Quadlet.HealthURL)
-		checkScript := fmt.Sprintf(`
-			for i in {1..15}; do
-				if curl -s -f "%s" > /dev/null; then
+	retries, interval, startPeriod := healthCheckTiming(q)
+	return fmt.Sprintf(`
+			sleep %d
+			for i in $(seq 1 %d); do
+				if %s; then
 					echo "OK"
 					exit 0
 				fi
-				sleep 2
+				sleep %d
 			done
 			echo "Health check timed out"
 			exit 1
-		`, env.Quadlet.HealthURL)
+		`, startPeriod, retries, probe, interval), target
+}
 
-		if err := runSSH(env, checkScript); err != nil {
-			logError("Health Check failed!")
-			rollback(env, binPath, dockerfile)
-			logFatal("Deployment failed (Unhealthy) but successfully rolled back.")
-		}
+// splitHealthTCP parses health_tcp ("port" or "host:port", IPv6 hosts in brackets); the
+// host defaults to 127.0.0.1.
+func splitHealthTCP(v string) (string, int, error) {
+	host, portStr := "127.0.0.1", v
+	if i := strings.LastIndex(v, ":"); i >= 0 {
+		host, portStr = strings.Trim(v[:i], "[]"), v[i+1:]
 	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 || host == "" {
+		return "", 0, fmt.Errorf("health_tcp: '%s' is not a port or host:port", v)
+	}
+	return host, port, nil
+}
 
-	logSuccess("✅ Deployed successfully.")
+// healthCheckTiming returns the attempts, pause and initial delay in seconds of the
+// deploy-time health check; defaults are 15 attempts 2s apart, no delay.
+func healthCheckTiming(q Quadlet) (retries, interval, startPeriod int) {
+	retries, interval = q.HealthRetries, q.HealthInterval
+	if retries <= 0 {
+		retries = 15
+	}
+	if interval <= 0 {
+		interval = 2
+	}
+	return retries, interval, max(q.HealthStartPeriod, 0)
+}
+
+// routerWithHealthPath defaults router.health_path to the path (and query) of quadlet.health_url.
+func routerWithHealthPath(q Quadlet) RouterConfig {
+	r := q.Router
+	if r.HealthPath == "" && q.HealthURL != "" {
+		if u, err := url.Parse(q.HealthURL); err == nil && u.Path != "" {
+			r.HealthPath = u.RequestURI()
+		}
+	}
+	return r
 }
 
-func generateTraefikLabels(serviceName string, r RouterConfig, defaultResolver string) []string {
+func generateTraefikLabels(serviceName string, r RouterConfig, defaultResolver, authProvider string) []string {
 	var labels []string
+	if r.Enabled != nil && !*r.Enabled {
+		return labels
+	}
 	if r.Domain == "" && r.Host == "" && r.Rule == "" {
 		return labels
 	}
@@ -211,13 +876,10 @@ func generateTraefikLabels(serviceName string, r RouterConfig, defaultResolver s
 	// High Priority for Main App (beats maintenance page)
 	labels = append(labels, fmt.Sprintf("traefik.http.routers.%s.priority=100", serviceName))
 
-	rule := r.Rule
-	if rule == "" {
-		if r.Domain != "" {
-			rule = fmt.Sprintf("Host(`%s`)", r.Domain)
-		} else {
-			rule = fmt.Sprintf("Host(`%s`)", r.Host)
-		}
+	canonical, other := wwwRedirectHosts(r)
+	rule := routerRule(r)
+	if canonical != "" && r.Rule == "" {
+		rule = fmt.Sprintf("Host(`%s`)", canonical)
 	}
 	labels = append(labels, fmt.Sprintf("traefik.http.routers.%s.rule=%s", serviceName, rule))
 
@@ -236,7 +898,29 @@ func generateTraefikLabels(serviceName string, r RouterConfig, defaultResolver s
 	}
 	labels = append(labels, fmt.Sprintf("traefik.http.routers.%s.tls.certresolver=%s", serviceName, resolver))
 
+	if other != "" {
+		// Second router catches the other host and only redirects
+		rt, mw := serviceName+"-www", serviceName+"-www-redirect"
+		labels = append(labels,
+			fmt.Sprintf("traefik.http.routers.%s.rule=Host(`%s`)", rt, other),
+			fmt.Sprintf("traefik.http.routers.%s.priority=100", rt),
+			fmt.Sprintf("traefik.http.routers.%s.entrypoints=%s", rt, strings.Join(eps, ",")),
+			fmt.Sprintf("traefik.http.routers.%s.tls.certresolver=%s", rt, resolver),
+			fmt.Sprintf("traefik.http.routers.%s.middlewares=%s", rt, mw),
+			fmt.Sprintf("traefik.http.routers.%s.service=%s", rt, serviceName),
+			fmt.Sprintf("traefik.http.middlewares.%s.redirectregex.regex=^https?://%s/(.*)", mw, regexp.QuoteMeta(other)),
+			fmt.Sprintf("traefik.http.middlewares.%s.redirectregex.replacement=https://%s/${1}", mw, canonical),
+			fmt.Sprintf("traefik.http.middlewares.%s.redirectregex.permanent=true", mw),
+		)
+	}
+
 	var mws []string
+	if r.HTTPSRedirect {
+		// Per-router redirect, useful when the global entrypoint redirect is disabled
+		mw := serviceName + "-redirect"
+		labels = append(labels, fmt.Sprintf("traefik.http.middlewares.%s.redirectscheme.scheme=https", mw))
+		mws = append(mws, mw)
+	}
 	if r.StripPrefix && r.PathPrefix != "" {
 		mw := serviceName + "-strip"
 		labels = append(labels, fmt.Sprintf("traefik.http.middlewares.%s.stripprefix.prefixes=%s", mw, r.PathPrefix))
@@ -245,9 +929,8 @@ func generateTraefikLabels(serviceName string, r RouterConfig, defaultResolver s
 
 	// New Simplified Auth Flag
 	if r.Auth {
-		// Convention: We assume the server has a middleware named "global-auth"
-		// This is created by 'deploy server provision'
-		mws = append(mws, "global-auth")
+		// The shared middlewares are created by 'deploy server provision'
+		mws = append(mws, authMiddleware(authProvider))
 	}
 
 	// Legacy/Advanced Auth Headers
@@ -268,8 +951,15 @@ func generateTraefikLabels(serviceName string, r RouterConfig, defaultResolver s
 	}
 	if r.RateLimit != nil && r.RateLimit.Average > 0 {
 		mw := serviceName + "-rate"
+		burst := r.RateLimit.Burst
+		if burst == 0 {
+			burst = r.RateLimit.Average * 2
+		}
 		labels = append(labels, fmt.Sprintf("traefik.http.middlewares.%s.ratelimit.average=%d", mw, r.RateLimit.Average))
-		labels = append(labels, fmt.Sprintf("traefik.http.middlewares.%s.ratelimit.burst=%d", mw, r.RateLimit.Burst))
+		labels = append(labels, fmt.Sprintf("traefik.http.middlewares.%s.ratelimit.burst=%d", mw, burst))
+		if r.RateLimit.Period != "" {
+			labels = append(labels, fmt.Sprintf("traefik.http.middlewares.%s.ratelimit.period=%s", mw, r.RateLimit.Period))
+		}
 		mws = append(mws, mw)
 	}
 	if r.Compress {
@@ -288,15 +978,165 @@ func generateTraefikLabels(serviceName string, r RouterConfig, defaultResolver s
 		labels = append(labels, fmt.Sprintf("traefik.http.routers.%s.middlewares=%s", serviceName, strings.Join(mws, ",")))
 	}
 
+	for i, host := range r.ExtraHosts {
+		rt := fmt.Sprintf("%s-%d", serviceName, i+1)
+		labels = append(labels,
+			fmt.Sprintf("traefik.http.routers.%s.rule=Host(`%s`)", rt, host),
+			fmt.Sprintf("traefik.http.routers.%s.priority=100", rt),
+			fmt.Sprintf("traefik.http.routers.%s.entrypoints=%s", rt, strings.Join(eps, ",")),
+			fmt.Sprintf("traefik.http.routers.%s.tls.certresolver=%s", rt, resolver),
+			fmt.Sprintf("traefik.http.routers.%s.service=%s", rt, serviceName),
+		)
+		if len(mws) > 0 {
+			labels = append(labels, fmt.Sprintf("traefik.http.routers.%s.middlewares=%s", rt, strings.Join(mws, ",")))
+		}
+	}
+
 	port := r.InternalPort
 	if port == 0 {
 		port = 8080
 	}
 	labels = append(labels, fmt.Sprintf("traefik.http.services.%s.loadbalancer.server.port=%d", serviceName, port))
+
+	if r.HealthPath != "" {
+		// Traefik stops routing to the container before podman's healthcheck reacts
+		interval := r.HealthInterval
+		if interval == "" {
+			interval = "10s"
+		}
+		labels = append(labels, fmt.Sprintf("traefik.http.services.%s.loadbalancer.healthcheck.path=%s", serviceName, r.HealthPath))
+		labels = append(labels, fmt.Sprintf("traefik.http.services.%s.loadbalancer.healthcheck.interval=%s", serviceName, interval))
+	}
+
+	if r.Sticky {
+		sticky := fmt.Sprintf("traefik.http.services.%s.loadbalancer.sticky.cookie", serviceName)
+		labels = append(labels, sticky+"=true")
+		if r.StickyCookie.Name != "" {
+			labels = append(labels, fmt.Sprintf("%s.name=%s", sticky, r.StickyCookie.Name))
+		}
+		if r.StickyCookie.Secure {
+			labels = append(labels, sticky+".secure=true")
+		}
+		if r.StickyCookie.HTTPOnly {
+			labels = append(labels, sticky+".httponly=true")
+		}
+	}
 	return labels
 }
 
-func doMaintenanceEnable(envName string) {
+// authMiddleware is the middleware created by 'deploy server provision' for the provider.
+func authMiddleware(provider string) string {
+	if provider == "authelia" {
+		return "authelia@file"
+	}
+	return "global-auth@file"
+}
+
+// wwwRedirectHosts returns the canonical host and the host redirected to it for
+// router.redirect_www. Both are empty if the option is unset or invalid (see routerProblems).
+func wwwRedirectHosts(r RouterConfig) (canonical, other string) {
+	domain := r.Domain
+	if domain == "" {
+		domain = r.Host
+	}
+	if r.RedirectWWW == "" || domain == "" {
+		return "", ""
+	}
+	apex := strings.TrimPrefix(domain, "www.")
+	switch r.RedirectWWW {
+	case "to_apex":
+		return apex, "www." + apex
+	case "to_www":
+		return "www." + apex, apex
+	}
+	return "", ""
+}
+
+// routerProblems lists the router options generateTraefikLabels ignores as invalid.
+func routerProblems(r RouterConfig) []string {
+	var problems []string
+	if r.RateLimit != nil && r.RateLimit.Average <= 0 {
+		problems = append(problems, "rate_limit ignored, 'average' must be > 0")
+	}
+	if r.RedirectWWW != "" {
+		if r.Domain == "" && r.Host == "" {
+			problems = append(problems, "redirect_www ignored, it needs router.domain")
+		} else if r.RedirectWWW != "to_apex" && r.RedirectWWW != "to_www" {
+			problems = append(problems, fmt.Sprintf("redirect_www '%s' ignored, use 'to_apex' or 'to_www'", r.RedirectWWW))
+		}
+	}
+	return problems
+}
+
+// routerRule derives the Traefik rule (Priority: explicit rule > domain > host).
+// Returns "" when none of them is set.
+func routerRule(r RouterConfig) string {
+	switch {
+	case r.Rule != "":
+		return r.Rule
+	case r.Domain != "":
+		return fmt.Sprintf("Host(`%s`)", r.Domain)
+	case r.Host != "":
+		return fmt.Sprintf("Host(`%s`)", r.Host)
+	}
+	return ""
+}
+
+// maintenanceTimer is the transient systemd timer that auto-disables maintenance (--until).
+func maintenanceTimer(env Environment) string {
+	return env.Quadlet.ServiceName + "-maint-off"
+}
+
+// maintenanceUntilFile holds the scheduled auto-disable time for 'maintenance status'.
+func maintenanceUntilFile(env Environment) string {
+	return fmt.Sprintf("%s/maintenance/.until", env.Dir)
+}
+
+// maintenanceDisableScript stops and removes the maintenance unit. It runs via ssh and,
+// for --until, inside the transient timer on the server (which has no deploy binary).
+func maintenanceDisableScript(env Environment) string {
+	serviceName := env.Quadlet.ServiceName + "-maint"
+	marker := maintenanceAppStoppedFile(env)
+	return strings.Join([]string{
+		// 0. Bring back an app stopped by 'enable --stop-app' while the page still covers it
+		fmt.Sprintf("{ [ ! -f %s ] || { systemctl --user start %s.service && rm -f %s; }; }", marker, env.Quadlet.ServiceName, marker),
+		// 1. Stop the service (ignore error if not running)
+		fmt.Sprintf("systemctl --user stop %s.service || true", serviceName),
+		// 2. Remove the manual persistent link
+		fmt.Sprintf("rm -f ~/.config/systemd/user/default.target.wants/%s.service", serviceName),
+		// 3. Remove the Quadlet definition so it's not regenerated
+		fmt.Sprintf("rm -f ~/.config/containers/systemd/%s.container %s", serviceName, maintenanceUntilFile(env)),
+		// 4. Reload systemd to reflect removal
+		"systemctl --user daemon-reload",
+	}, " && ")
+}
+
+// maintenanceAppStoppedFile marks that 'maintenance enable --stop-app' stopped the app,
+// so disable (or the --until timer) starts it again.
+func maintenanceAppStoppedFile(env Environment) string {
+	return fmt.Sprintf("%s/maintenance/.app-stopped", env.Dir)
+}
+
+// MaintenanceOptions tunes 'deploy maintenance enable'.
+type MaintenanceOptions struct {
+	Until   time.Duration // Auto-disable after this long (0 = stay on)
+	StopApp bool          // Stop the app once the page serves (e.g. for migrations)
+	Drain   time.Duration // Wait before stopping the app so in-flight requests finish
+}
+
+// maintenanceScheduleScript (re)schedules the auto-disable after d.
+func maintenanceScheduleScript(env Environment, d time.Duration, until time.Time) string {
+	timer := maintenanceTimer(env)
+	return strings.Join([]string{
+		fmt.Sprintf("systemctl --user stop %s.timer 2>/dev/null; systemctl --user reset-failed %s.service 2>/dev/null; true", timer, timer),
+		fmt.Sprintf("systemd-run --user --unit=%s --on-active=%ds /bin/sh -c %s", timer, int(d.Seconds()), shellQuote(maintenanceDisableScript(env))),
+		fmt.Sprintf("echo %s > %s", until.UTC().Format(time.RFC3339), maintenanceUntilFile(env)),
+	}, " && ")
+}
+
+// doMaintenanceEnable starts the maintenance page; opts can stop the app behind it and
+// schedule the auto-disable.
+func doMaintenanceEnable(envName string, opts MaintenanceOptions) {
 	_, env := loadEnv(envName)
 
 	// Removed the strict check. If the user invokes this command, they want it.
@@ -314,8 +1154,8 @@ func doMaintenanceEnable(envName string) {
 	// 2. Sync
 	logInfo("📤 Syncing maintenance artifacts...")
 	runSSH(env, fmt.Sprintf("mkdir -p %s/maintenance ~/.config/containers/systemd", env.Dir))
-	runRsync(env, []string{htmlPath}, fmt.Sprintf("%s@%s:%s/maintenance/index.html", env.User, env.Host, env.Dir))
-	runRsync(env, []string{maintPath}, fmt.Sprintf("%s@%s:~/.config/containers/systemd/", env.User, env.Host))
+	runRsync(env, []string{htmlPath}, remotePath(env, env.Dir+"/maintenance/index.html"))
+	runRsync(env, []string{maintPath}, remotePath(env, "~/.config/containers/systemd/"))
 
 	// 3. Activate
 	serviceName := env.Quadlet.ServiceName + "-maint"
@@ -338,25 +1178,37 @@ func doMaintenanceEnable(envName string) {
 
 	logSuccess("✅ Maintenance page is UP (Priority 1).")
 	logInfo("   It will be served automatically whenever '%s' (Priority 100) is stopped.", env.Quadlet.ServiceName)
+
+	if opts.StopApp {
+		logInfo("⏳ Draining %s for %s before stopping it...", env.Quadlet.ServiceName, opts.Drain)
+		if !dryRun {
+			time.Sleep(opts.Drain)
+		}
+		// Marker first: if the stop half-fails, disable still tries to start the app
+		script := fmt.Sprintf("touch %s && systemctl --user stop %s.service", maintenanceAppStoppedFile(env), env.Quadlet.ServiceName)
+		if err := runSSH(env, script); err != nil {
+			logFatal("Maintenance is on, but stopping %s failed: %v", env.Quadlet.ServiceName, err)
+		}
+		logSuccess("🛑 %s stopped; the maintenance page now serves all traffic. 'disable' starts it again.", env.Quadlet.ServiceName)
+	}
+
+	if opts.Until > 0 {
+		at := time.Now().Add(opts.Until)
+		if err := runSSH(env, maintenanceScheduleScript(env, opts.Until, at)); err != nil {
+			logFatal("Maintenance is on, but scheduling the auto-disable failed (disable it manually): %v", err)
+		}
+		logInfo("⏰ Auto-disable scheduled for %s (in %s).", at.Format("2006-01-02 15:04"), opts.Until)
+	}
 }
 
 func doMaintenanceDisable(envName string) {
 	_, env := loadEnv(envName)
 	serviceName := env.Quadlet.ServiceName + "-maint"
 
-	logInfo("🚧 Disabling Maintenance Page on %s...", env.Host)
-
-	script := strings.Join([]string{
-		// 1. Stop the service (ignore error if not running)
-		fmt.Sprintf("systemctl --user stop %s.service || true", serviceName),
-		// 2. Remove the manual persistent link
-		fmt.Sprintf("rm -f ~/.config/systemd/user/default.target.wants/%s.service", serviceName),
-		// 3. Remove the Quadlet definition so it's not regenerated
-		fmt.Sprintf("rm -f ~/.config/containers/systemd/%s.container", serviceName),
-		// 4. Reload systemd to reflect removal
-		"systemctl --user daemon-reload",
-	}, " && ")
+	logInfo("🚧 Disabling Maintenance Page on %s (%s)...", env.Host, serviceName)
 
+	// Cancel a pending --until timer first, it would fire on the next enable otherwise
+	script := fmt.Sprintf("{ systemctl --user stop %s.timer 2>/dev/null || true; } && %s", maintenanceTimer(env), maintenanceDisableScript(env))
 	if err := runSSH(env, script); err != nil {
 		logFatal("Failed to disable maintenance page: %v", err)
 	}
@@ -365,22 +1217,104 @@ func doMaintenanceDisable(envName string) {
 	logSuccess("✅ Maintenance page disabled and removed.")
 }
 
+// doMaintenanceStatus reports whether the maintenance unit is active and which router
+// Traefik currently picks (app: priority 100, maintenance: priority 1).
+func doMaintenanceStatus(envName string) {
+	_, env := loadEnv(envName)
+	script := fmt.Sprintf(`
+		echo "app=$(systemctl --user is-active %s.service 2>/dev/null)"
+		echo "maint=$(systemctl --user is-active %s-maint.service 2>/dev/null)"
+		if systemctl --user is-active -q %s.timer 2>/dev/null; then echo "until=$(cat %s 2>/dev/null)"; fi
+	`, env.Quadlet.ServiceName, env.Quadlet.ServiceName, maintenanceTimer(env), maintenanceUntilFile(env))
+
+	out, err := runSSHOutput(env, script)
+	if err != nil && out == "" {
+		logFatal("Could not determine maintenance state on %s: %v", env.Host, err)
+	}
+	states := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+			states[k] = v
+		}
+	}
+	appState, maintState := states["app"], states["maint"]
+	if appState == "" || maintState == "" {
+		logFatal("Could not determine maintenance state on %s (unexpected output: %q)", env.Host, out)
+	}
+
+	maintOn := maintState == "active"
+	onOff := "OFF"
+	if maintOn {
+		onOff = "ON"
+	}
+	fmt.Printf("Maintenance: %s (%s-maint.service: %s)\n", onOff, env.Quadlet.ServiceName, maintState)
+	fmt.Printf("App:         %s.service: %s\n", env.Quadlet.ServiceName, appState)
+	fmt.Printf("Serving:     %s\n", maintenanceServing(appState == "active", maintOn))
+	if t, err := time.Parse(time.RFC3339, states["until"]); err == nil && maintOn {
+		fmt.Printf("Auto-off:    %s (in %s)\n", t.Local().Format("2006-01-02 15:04"), time.Until(t).Round(time.Minute))
+	}
+}
+
+// maintenanceServing names the router that wins for the app's rule.
+func maintenanceServing(appActive, maintActive bool) string {
+	switch {
+	case appActive:
+		return "app (priority 100)"
+	case maintActive:
+		return "maintenance page (priority 1)"
+	default:
+		return "nothing (both units inactive)"
+	}
+}
+
+// versionCore requires all three numbers of MAJOR.MINOR.PATCH without leading zeros;
+// semver.IsValid alone also accepts shorthands like v1.2.
+var versionCore = regexp.MustCompile(`^v?(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)([-+].*)?$`)
+
+// canonicalVersion adds the 'v' that golang.org/x/mod/semver expects.
+func canonicalVersion(v string) string {
+	return "v" + strings.TrimPrefix(v, "v")
+}
+
+// validateVersion rejects anything but a full semantic version like v1.2.3, v1.2.3-rc.1
+// or v1.2.3+build.5 (the leading 'v' is optional).
+func validateVersion(v string) error {
+	if !versionCore.MatchString(v) || !semver.IsValid(canonicalVersion(v)) {
+		return fmt.Errorf("'%s' is not a semantic version (MAJOR.MINOR.PATCH, e.g. v1.0.1)", v)
+	}
+	return nil
+}
+
+// latestVersionTag returns the highest semantic version among tags, or "" if none is one.
+func latestVersionTag(tags []string) string {
+	latest := ""
+	for _, t := range tags {
+		if validateVersion(t) != nil {
+			continue
+		}
+		if latest == "" || semver.Compare(canonicalVersion(t), canonicalVersion(latest)) > 0 {
+			latest = t
+		}
+	}
+	return latest
+}
+
 // resolveAndValidateVersion handles the logic for strict versioning and "lazy" tagging.
-func resolveAndValidateVersion(explicitVersion string) string {
+func resolveAndValidateVersion(explicitVersion string) (string, error) {
 	if dryRun {
 		if explicitVersion == "" {
-			return "v0.0.0-dryrun"
+			return "v0.0.0-dryrun", nil
 		}
-		return explicitVersion
+		return explicitVersion, nil
 	}
 
 	// 1. Global Pre-check: Clean Git State
 	out, err := exec.Command("git", "status", "--porcelain").Output()
 	if err != nil {
-		logFatal("Failed to run git status")
+		return "", fmt.Errorf("failed to run git status: %w", err)
 	}
 	if len(strings.TrimSpace(string(out))) > 0 {
-		logFatal("🚫 Git working directory is dirty. Commit or stash changes before releasing.")
+		return "", fmt.Errorf("🚫 Git working directory is dirty. Commit or stash changes before releasing")
 	}
 
 	hasRemote := true
@@ -393,20 +1327,22 @@ func resolveAndValidateVersion(explicitVersion string) string {
 	if explicitVersion != "" {
 		logInfo("🛡️  Validating explicit version %s...", explicitVersion)
 		if err := exec.Command("git", "rev-parse", "--verify", explicitVersion).Run(); err != nil {
-			logFatal("🚫 Tag '%s' not found locally.", explicitVersion)
+			return "", fmt.Errorf("🚫 Tag '%s' not found locally", explicitVersion)
 		}
 
 		headHash := strings.TrimSpace(getCmdOutput("git", "rev-parse", "HEAD"))
 		tagHash := strings.TrimSpace(getCmdOutput("git", "rev-parse", explicitVersion+"^{commit}"))
 
 		if headHash != tagHash {
-			logFatal("🚫 HEAD (%s) is not at tag %s (%s). Checkout the tag first.", headHash[:7], explicitVersion, tagHash[:7])
+			return "", fmt.Errorf("🚫 HEAD (%s) is not at tag %s (%s). Checkout the tag first", headHash[:7], explicitVersion, tagHash[:7])
 		}
 
 		if hasRemote {
-			ensureTagPushed(explicitVersion)
+			if err := ensureTagPushed(explicitVersion); err != nil {
+				return "", err
+			}
 		}
-		return explicitVersion
+		return explicitVersion, nil
 	}
 
 	// Case B: Lazy Mode (Auto-detect or Prompt)
@@ -416,9 +1352,11 @@ func resolveAndValidateVersion(explicitVersion string) string {
 		tag := strings.TrimSpace(string(currentTag))
 		logInfo("✅ Found existing tag: %s", tag)
 		if hasRemote {
-			ensureTagPushed(tag)
+			if err := ensureTagPushed(tag); err != nil {
+				return "", err
+			}
 		}
-		return tag
+		return tag, nil
 	}
 
 	// No tag on HEAD. Prompt user.
@@ -428,65 +1366,311 @@ func resolveAndValidateVersion(explicitVersion string) string {
 	runCommandRaw("git", "tag", "--sort=-v:refname", "--list")
 	fmt.Println("-------------------")
 
-	newVersion := prompt("Enter new semantic version (e.g. v1.0.1)")
-	if newVersion == "" {
-		logFatal("Version is required.")
+	latest := latestVersionTag(strings.Fields(getCmdOutput("git", "tag", "--list")))
+	var newVersion string
+	for {
+		newVersion = prompt("Enter new semantic version (e.g. v1.0.1)")
+		if newVersion == "" {
+			return "", fmt.Errorf("version is required")
+		}
+		if err := validateVersion(newVersion); err != nil {
+			logWarn("%v", err)
+			continue
+		}
+		if latest != "" && semver.Compare(canonicalVersion(newVersion), canonicalVersion(latest)) <= 0 {
+			logWarn("⚠️  %s is not greater than the latest tag %s (possible downgrade).", newVersion, latest)
+			if !confirm("Use '" + newVersion + "' anyway?") {
+				continue
+			}
+		}
+		break
 	}
 
 	if !strings.HasPrefix(newVersion, "v") {
 		logWarn("Convention suggestion: versions usually start with 'v' (e.g. v1.0.0)")
 		if !confirm("Use '" + newVersion + "' anyway?") {
-			os.Exit(1)
+			return "", fmt.Errorf("aborted")
 		}
 	}
 
 	// Create Tag
 	logInfo("🏷️  Creating tag %s...", newVersion)
 	if err := runCommandRaw("git", "tag", "-a", newVersion, "-m", "Release "+newVersion); err != nil {
-		logFatal("Failed to create tag: %v", err)
+		return "", fmt.Errorf("failed to create tag: %w", err)
 	}
 
 	// Push Tag
 	if hasRemote {
 		logInfo("⬆️  Pushing tag to origin...")
 		if err := runCommandRaw("git", "push", "origin", newVersion); err != nil {
-			logFatal("Failed to push tag: %v", err)
+			return "", fmt.Errorf("failed to push tag: %w", err)
 		}
 	}
 
-	return newVersion
+	return newVersion, nil
 }
 
-func ensureTagPushed(version string) {
+func ensureTagPushed(version string) error {
 	logInfo("☁️  Verifying tag presence on remote...")
 	err := exec.Command("git", "ls-remote", "--exit-code", "--tags", "origin", version).Run()
 	if err != nil {
 		logWarn("🚫 Tag '%s' exists locally but NOT on origin.", version)
 		if confirm(fmt.Sprintf("Push '%s' to origin now?", version)) {
 			if err := runCommandRaw("git", "push", "origin", version); err != nil {
-				logFatal("Failed to push tag: %v", err)
+				return fmt.Errorf("failed to push tag: %w", err)
 			}
 			logSuccess("Tag pushed.")
 		} else {
-			logFatal("Aborting. Deployment requires synced tags.")
+			return fmt.Errorf("aborting: deployment requires synced tags")
 		}
 	}
+	return nil
 }
 
-func rollback(env Environment, binPath, dockerfile string) {
+func printJournalTail(ctx context.Context, env Environment) {
 	logWarn("🔍 Diagnosing with remote logs (last 50 lines)...")
-	runSSHStream(env, fmt.Sprintf("journalctl --user -u %s.service -n 50 --no-pager", env.Quadlet.ServiceName))
+	runSSHStreamContext(ctx, env, fmt.Sprintf("journalctl --user -u %s.service -n 50 --no-pager", env.Quadlet.ServiceName))
+}
+
+func rollback(ctx context.Context, env Environment, binPath, dockerfile string) error {
+	printJournalTail(ctx, env)
 
 	logWarn("🚨 INITIATING AUTOMATIC ROLLBACK...")
-	rbScript := strings.Join([]string{
-		fmt.Sprintf("cd %s", env.Dir),
-		fmt.Sprintf("[ -f %s.bak ] && mv %s.bak %s", binPath, binPath, binPath),
-		fmt.Sprintf("podman build -f %s -t %s .", dockerfile, env.Quadlet.Image),
+	if rbErr := runSSHContext(ctx, env, rollbackScript(env, binPath, dockerfile)); rbErr != nil {
+		return fmt.Errorf("CRITICAL: rollback failed on %s: %w", env.Host, rbErr)
+	}
+	return nil
+}
+
+// rollbackScript restores the previous binary (rebuilding its image) and every unit that
+// has a backup, then restarts the service.
+func rollbackScript(env Environment, binPath, dockerfile string) string {
+	// The restored build's commit is unknown; without a marker --if-changed redeploys
+	steps := []string{fmt.Sprintf("cd %s", env.Dir), "rm -f " + commitMarkerFile}
+	if !env.Quadlet.PullImage {
+		// Registry mode: the previous quadlet still references the previous image tag
+		steps = append(steps,
+			backupRestoreScript(binPath, env.KeepBackups),
+			fmt.Sprintf("podman build%s -f %s -t %s .", platformFlag(env), dockerfile, env.Quadlet.Image),
+		)
+	}
+	return strings.Join(append(steps,
+		restoreUnitsScript(env),
+		"systemctl --user daemon-reload",
 		fmt.Sprintf("systemctl --user restart %s.service", env.Quadlet.ServiceName),
-	}, " && ")
-	if rbErr := runSSH(env, rbScript); rbErr != nil {
-		logFatal("CRITICAL: Rollback failed! Error: %v", rbErr)
+	), " && ")
+}
+
+// remoteUnitPaths lists env's quadlet units on the server: the app, its sidecars and the pod.
+func remoteUnitPaths(env Environment) []string {
+	paths := []string{fmt.Sprintf("~/.config/containers/systemd/%s.container", env.Quadlet.ServiceName)}
+	for _, name := range sidecarServices(env) {
+		paths = append(paths, fmt.Sprintf("~/.config/containers/systemd/%s.container", name))
+	}
+	if env.Pod.Enabled {
+		paths = append(paths, fmt.Sprintf("~/.config/containers/systemd/%s.pod", podName(env)))
+	}
+	return paths
+}
+
+// restoreUnitsScript puts back the .bak.1 of every unit; units without one (new in the
+// failed release) are left as they are.
+func restoreUnitsScript(env Environment) string {
+	var steps []string
+	for _, p := range remoteUnitPaths(env) {
+		steps = append(steps, fmt.Sprintf("{ [ ! -f %s.bak.1 ] || %s; }", p, backupRestoreScript(p, env.KeepBackups)))
 	}
+	return strings.Join(steps, " && ")
+}
+
+// networkExistsScript succeeds if the quadlet network unit or the podman network exists.
+// Quadlet names its networks systemd-<name> unless NetworkName is set.
+func networkExistsScript(network string) string {
+	name := strings.TrimSuffix(network, ".network")
+	return fmt.Sprintf("test -f ~/.config/containers/systemd/%s.network || podman network exists systemd-%s || podman network exists %s", name, name, name)
+}
+
+// ensureNetwork fails early with a helpful message when quadlet.network is missing on the
+// host (a fresh server), instead of the raw systemd error at activation. For quadlet
+// networks (*.network) it offers to create a minimal bridge unit.
+func ensureNetwork(ctx context.Context, env Environment) error {
+	network := env.Quadlet.Network
+	if network == "" {
+		return nil
+	}
+	if _, err := runSSHOutputContext(ctx, env, networkExistsScript(network)); err == nil {
+		return nil
+	}
+
+	if !strings.HasSuffix(network, ".network") {
+		return fmt.Errorf("🌐 Network '%s' does not exist on %s. Create it or run 'deploy server provision' first", network, env.Host)
+	}
+	name := strings.TrimSuffix(network, ".network")
+	logWarn("🌐 Network unit %s not found on %s (was 'deploy server provision' run?).", network, env.Host)
+	if !confirm(fmt.Sprintf("Create a minimal %s (bridge) now?", network)) {
+		return fmt.Errorf("network '%s' missing on %s; run 'deploy server provision' first", network, env.Host)
+	}
+	script := fmt.Sprintf("mkdir -p ~/.config/containers/systemd && printf %%s %s > ~/.config/containers/systemd/%s && systemctl --user daemon-reload && systemctl --user start %s-network.service",
+		shellQuote(networkTmpl), network, name)
+	if err := runSSHContext(ctx, env, script); err != nil {
+		return fmt.Errorf("creating network %s failed: %w", network, err)
+	}
+	return nil
+}
+
+// checkDiskSpace aborts before the sync when target_dir's partition has less than
+// min_free_disk_mb free, instead of failing mid-rsync with "no space left on device".
+func checkDiskSpace(ctx context.Context, env Environment) error {
+	if env.MinFreeMB < 0 {
+		return nil
+	}
+	out, err := runSSHOutputContext(ctx, env, diskFreeScript(env.Dir))
+	if err != nil {
+		return fmt.Errorf("disk space check failed on %s: %w", env.Host, err)
+	}
+	availKB, err := strconv.Atoi(out)
+	if err != nil {
+		logWarn("Could not parse free disk space on %s (%q), skipping check.", env.Host, out)
+		return nil
+	}
+	if availMB := availKB / 1024; availMB < env.MinFreeMB {
+		return fmt.Errorf("💾 Only %d MB free for %s on %s (need %d MB, see min_free_disk_mb)", availMB, env.Dir, env.Host, env.MinFreeMB)
+	}
+	return nil
+}
+
+// diskFreeScript prints the free KB of dir's partition. On a first deploy dir does not
+// exist yet, so it asks df about the nearest existing ancestor instead of creating dir
+// (the check is a query and also runs in dry-run).
+func diskFreeScript(dir string) string {
+	return fmt.Sprintf(`d=%s; while [ ! -d "$d" ]; do d=$(dirname "$d"); done; df -Pk "$d" | awk 'NR==2 {print $4}'`, shellQuote(dir))
+}
+
+// lockReleaseTimeout bounds removing the deploy lock, which runs on its own context so it
+// still works after the release's --timeout has fired.
+const lockReleaseTimeout = 30 * time.Second
+
+// acquireDeployLock atomically creates <target_dir>/.deploy.lock (noclobber) and returns
+// a release func. Acquiring is bounded by ctx; the lock is also released if the process
+// dies via logFatal.
+func acquireDeployLock(ctx context.Context, env Environment, force bool) (func(), error) {
+	lockPath := fmt.Sprintf("%s/%s", strings.TrimRight(env.Dir, "/"), lockFile)
+
+	if force {
+		logWarn("🔓 Removing existing deploy lock (--force-unlock)...")
+		if err := runSSHContext(ctx, env, fmt.Sprintf("rm -f %s", lockPath)); err != nil {
+			return nil, fmt.Errorf("failed to remove lock: %w", err)
+		}
+	}
+
+	holder := localUsername()
+	if h, err := os.Hostname(); err == nil {
+		holder += "@" + h
+	}
+	info := fmt.Sprintf("%s since %s", holder, time.Now().UTC().Format(time.RFC3339))
+
+	cmd := fmt.Sprintf("mkdir -p %s && (set -C; echo %s > %s) 2>/dev/null", env.Dir, shellQuote(info), lockPath)
+	if err := runSSHContext(ctx, env, cmd); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("acquiring the deploy lock on %s: %w", env.Host, ctx.Err())
+		}
+		owner, _ := runSSHOutputContext(ctx, env, fmt.Sprintf("cat %s", lockPath))
+		if owner == "" {
+			owner = "unknown"
+		}
+		return nil, fmt.Errorf("🔒 %s is locked by %s. If that deploy is dead, rerun with --force-unlock", env.Host, owner)
+	}
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), lockReleaseTimeout)
+			defer cancel()
+			if err := runSSHContext(ctx, env, fmt.Sprintf("rm -f %s", lockPath)); err != nil {
+				logWarn("Failed to release deploy lock %s: %v", lockPath, err)
+			}
+		})
+	}
+	onExit(release)
+	return release, nil
+}
+
+// backupRotateScript shifts path.bak.1..N-1 up by one, copies path to path.bak.1 and
+// deletes anything beyond N. A legacy single path.bak is adopted as path.bak.1 first.
+func backupRotateScript(path string, keep int) string {
+	if keep < 1 {
+		keep = 1
+	}
+	steps := []string{
+		fmt.Sprintf("{ [ ! -f %s.bak ] || [ -f %s.bak.1 ] || mv %s.bak %s.bak.1; }", path, path, path, path),
+		fmt.Sprintf("for f in %s.bak.*; do [ \"${f##*.}\" -ge %d ] 2>/dev/null && rm -f \"$f\"; done; true", path, keep),
+	}
+	for i := keep - 1; i >= 1; i-- {
+		steps = append(steps, fmt.Sprintf("{ [ ! -f %s.bak.%d ] || mv %s.bak.%d %s.bak.%d; }", path, i, path, i, path, i+1))
+	}
+	steps = append(steps, fmt.Sprintf("{ [ ! -f %s ] || cp %s %s.bak.1; }", path, path, path))
+	return strings.Join(steps, " && ")
+}
+
+// backupRestoreScript moves path.bak.1 back into place and shifts the remaining backups down.
+// Fails if there is no path.bak.1.
+func backupRestoreScript(path string, keep int) string {
+	steps := []string{fmt.Sprintf("[ -f %s.bak.1 ] && mv %s.bak.1 %s", path, path, path)}
+	for i := 2; i <= keep; i++ {
+		steps = append(steps, fmt.Sprintf("{ [ ! -f %s.bak.%d ] || mv %s.bak.%d %s.bak.%d; }", path, i, path, i, path, i-1))
+	}
+	return strings.Join(steps, " && ")
+}
+
+// doRollback manually restores the previous binary (<binary>.bak.1) and restarts the service.
+func doRollback(envName string) {
+	cfg, env := loadEnv(envName)
+	binPath := fmt.Sprintf("%s/%s", env.Dir, cfg.BinaryName)
+
+	logInfo("⏪ Rolling back %s (%s) on %s...", cfg.AppName, envName, env.Host)
+
+	// In dry-run, runSSH always succeeds, so the check is a no-op.
+	if err := runSSH(env, fmt.Sprintf("test -f %s.bak.1", binPath)); err != nil {
+		logFatal("🚫 No backup found at %s.bak.1 on %s. Nothing to roll back to.", binPath, env.Host)
+	}
+
+	if !confirm(fmt.Sprintf("Restore %s.bak.1 and restart '%s'?", binPath, env.Quadlet.ServiceName)) {
+		return
+	}
+
+	current, _ := runSSHOutput(env, fmt.Sprintf("cat %s/%s 2>/dev/null", env.Dir, versionMarkerFile))
+	if err := rollback(context.Background(), env, binPath, dockerfileFor(env)); err != nil {
+		logFatal("%v", err)
+	}
+	// Version is the one rolled back from; the commit is not known locally
+	recordHistory(context.Background(), env, current, "", "manual_rollback")
+	logSuccess("✅ Rolled back to previous binary.")
+}
+
+// imageRepo strips the tag from an image reference (localhost/app:latest -> localhost/app).
+// A registry port (host:5000/app) is not mistaken for a tag.
+func imageRepo(image string) string {
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i]
+	}
+	return image
+}
+
+// versionedImage replaces the tag of image with version (localhost/app:latest -> localhost/app:v1.2.3).
+func versionedImage(image, version string) string {
+	return imageRepo(image) + ":" + version
+}
+
+// registryImage returns the pushed image reference, e.g. ghcr.io/acme/app:v1.2.3.
+func registryImage(registry, appName, version string) string {
+	return fmt.Sprintf("%s/%s:%s", strings.TrimRight(registry, "/"), appName, version)
+}
+
+func dockerfileFor(env Environment) string {
+	if env.Quadlet.Dockerfile == "" {
+		return "Dockerfile.vps"
+	}
+	return env.Quadlet.Dockerfile
 }
 
 func getBuildMetadata(explicitVersion string) BuildMetadata {
@@ -527,30 +1711,166 @@ func getBuildMetadata(explicitVersion string) BuildMetadata {
 	}
 }
 
-func generateQuadlet(env Environment, outDir string) string {
-	var absVolumes []string
+// absVolumes resolves ./relative volume sources against target_dir.
+func absVolumes(env Environment) []string {
+	var vols []string
 	for _, vol := range env.Quadlet.Volumes {
 		parts := strings.Split(vol, ":")
 		if len(parts) > 0 && strings.HasPrefix(parts[0], "./") {
 			rel := strings.TrimPrefix(parts[0], "./")
 			abs := strings.TrimRight(env.Dir, "/") + "/" + rel
 			parts[0] = abs
-			absVolumes = append(absVolumes, strings.Join(parts, ":"))
+			vols = append(vols, strings.Join(parts, ":"))
 		} else {
-			absVolumes = append(absVolumes, vol)
+			vols = append(vols, vol)
 		}
 	}
-	data := TemplateData{Quadlet: env.Quadlet, TargetDir: env.Dir}
-	data.Quadlet.Volumes = absVolumes
+	return vols
+}
+
+// generateQuadlet renders the .container unit and returns its path and content.
+// In dry-run nothing is written.
+func generateQuadlet(env Environment, outDir string) (string, string) {
+	if err := validateResources(env.Quadlet); err != nil {
+		logFatal("%v", err)
+	}
+	if env.Quadlet.ReadOnly && len(env.Quadlet.Volumes) == 0 && len(env.Quadlet.Tmpfs) == 0 {
+		logWarn("'%s' has read_only: true but no volumes or tmpfs; writes (even to /tmp) will fail. Add e.g. tmpfs: [\"/tmp\"].", env.Quadlet.ServiceName)
+	}
+	data := TemplateData{Quadlet: env.Quadlet, TargetDir: env.Dir, Requires: sidecarServices(env)}
+	data.Quadlet.Volumes = absVolumes(env)
+	if env.Pod.Enabled {
+		// Containers in a pod cannot publish ports themselves; the pod does
+		data.Pod = podName(env)
+		data.Quadlet.Ports = nil
+	}
 
-	var buf bytes.Buffer
-	t, _ := template.New("q").Parse(quadletTemplate)
-	t.Execute(&buf, data)
 	path := filepath.Join(outDir, env.Quadlet.ServiceName+".container")
+	content, err := renderTemplate(quadletTemplate, data)
+	if err != nil {
+		logFatal("Template error (%s): %v", path, err)
+	}
+	if !dryRun {
+		os.MkdirAll(outDir, 0755)
+		os.WriteFile(path, []byte(content), 0644)
+	}
+	return path, content
+}
+
+// podName is the pod (and .pod quadlet) name for pod.enabled.
+func podName(env Environment) string {
+	if env.Pod.Name != "" {
+		return env.Pod.Name
+	}
+	return env.Quadlet.ServiceName
+}
+
+// generatePodQuadlet writes <pod>.pod with the env's network and every container's ports.
+func generatePodQuadlet(env Environment, outDir string) (string, string) {
+	data := PodTemplateData{Name: podName(env), Network: env.Quadlet.Network}
+	data.Ports = append(data.Ports, env.Pod.Ports...)
+	for _, q := range append([]Quadlet{env.Quadlet}, env.Sidecars...) {
+		data.Ports = append(data.Ports, q.Ports...)
+	}
+
+	path := filepath.Join(outDir, data.Name+".pod")
+	content, err := renderTemplate(podTemplate, data)
+	if err != nil {
+		logFatal("Template error (%s): %v", path, err)
+	}
 	if !dryRun {
-		os.WriteFile(path, buf.Bytes(), 0644)
+		os.MkdirAll(outDir, 0755)
+		os.WriteFile(path, []byte(content), 0644)
+	}
+	return path, content
+}
+
+type quadletFile struct {
+	Service string
+	Path    string
+	Content string
+}
+
+// previewQuadlets prints each rendered quadlet (labels included) and a diff against the
+// unit currently on the server. The remote read is read-only, so it also runs in dry-run.
+func previewQuadlets(env Environment, quadlets []quadletFile) {
+	for _, q := range quadlets {
+		fmt.Printf("\n--- %s.container (rendered) ---\n%s", q.Service, q.Content)
+
+		remote, err := runSSHOutput(env, fmt.Sprintf("cat ~/.config/containers/systemd/%s.container", q.Service))
+		if err != nil {
+			fmt.Printf("--- no remote %s.container to compare (new service or SSH unavailable) ---\n", q.Service)
+			continue
+		}
+		if remote == strings.TrimSpace(q.Content) {
+			fmt.Printf("--- %s.container: no changes on %s ---\n", q.Service, env.Host)
+			continue
+		}
+		fmt.Printf("--- diff %s:%s.container -> rendered ---\n", env.Host, q.Service)
+		for _, line := range diffLines(remote, q.Content) {
+			fmt.Println(line)
+		}
+	}
+}
+
+// sidecarServices returns the service names of env's sidecars in config order.
+func sidecarServices(env Environment) []string {
+	var names []string
+	for _, sc := range env.Sidecars {
+		names = append(names, sc.ServiceName)
+	}
+	return names
+}
+
+// generateSidecarQuadlets writes one .container per sidecar. Sidecars get ContainerName
+// set to their service name so the app can reach them as e.g. redis:6379.
+func generateSidecarQuadlets(env Environment, outDir string) ([]quadletFile, error) {
+	var files []quadletFile
+	for i, sc := range env.Sidecars {
+		if sc.ServiceName == "" || sc.Image == "" {
+			return nil, fmt.Errorf("sidecar #%d needs service_name and image", i+1)
+		}
+		if sc.ServiceName == env.Quadlet.ServiceName {
+			return nil, fmt.Errorf("sidecar '%s' has the same service_name as the app", sc.ServiceName)
+		}
+		if sc.ContainerName == "" {
+			sc.ContainerName = sc.ServiceName
+		}
+		if sc.Network == "" {
+			sc.Network = env.Quadlet.Network
+		}
+		sc.Labels = append(sc.Labels, generateTraefikLabels(sc.ServiceName, routerWithHealthPath(sc), "myresolver", env.AuthProvider)...)
+
+		scEnv := env
+		scEnv.Pod.Name = podName(env) // Join the app's pod, not one named after the sidecar
+		scEnv.Quadlet = sc
+		scEnv.Sidecars = nil
+		path, content := generateQuadlet(scEnv, outDir)
+		files = append(files, quadletFile{Service: sc.ServiceName, Path: path, Content: content})
 	}
-	return path
+	return files, nil
+}
+
+// maintenanceHTML renders maintenance.html_file (templated only if it contains "{{"),
+// falling back to the built-in page with a warning if the file cannot be used.
+func maintenanceHTML(m MaintenanceConfig) []byte {
+	if m.HTMLFile != "" {
+		raw, err := os.ReadFile(m.HTMLFile)
+		switch {
+		case err != nil:
+			logWarn("maintenance.html_file: %v, using the built-in page.", err)
+		case !bytes.Contains(raw, []byte("{{")):
+			return raw
+		default:
+			out, err := renderTemplate(string(raw), m)
+			if err == nil {
+				return []byte(out)
+			}
+			logWarn("maintenance.html_file %s: %v, using the built-in page.", m.HTMLFile, err)
+		}
+	}
+	out, _ := renderTemplate(maintenanceHtmlTmpl, m)
+	return []byte(out)
 }
 
 func generateMaintenance(env Environment, outDir string) (string, string) {
@@ -563,34 +1883,27 @@ func generateMaintenance(env Environment, outDir string) (string, string) {
 	}
 
 	// 2. Generate HTML
-	var htmlBuf bytes.Buffer
-	t1, _ := template.New("h").Parse(maintenanceHtmlTmpl)
-	t1.Execute(&htmlBuf, env.Maintenance)
-
 	htmlPath := filepath.Join(outDir, "maintenance.html")
 	if !dryRun {
-		os.WriteFile(htmlPath, htmlBuf.Bytes(), 0644)
+		os.WriteFile(htmlPath, maintenanceHTML(env.Maintenance), 0644)
 	}
 
 	// 3. Generate Container
 	resolver := "myresolver" // Default convention
 
-	// Determine the Rule (Priority: explicit rule > domain > host)
-	rule := env.Quadlet.Router.Rule
+	rule := routerRule(env.Quadlet.Router)
 	if rule == "" {
-		if env.Quadlet.Router.Domain != "" {
-			rule = fmt.Sprintf("Host(`%s`)", env.Quadlet.Router.Domain)
-		} else if env.Quadlet.Router.Host != "" {
-			rule = fmt.Sprintf("Host(`%s`)", env.Quadlet.Router.Host)
-		} else {
-			// Fallback if both missing
-			rule = "Host(`unknown-host`)"
-		}
+		// Fallback if rule, domain and host are all missing
+		rule = "Host(`unknown-host`)"
+	}
+	for _, host := range env.Quadlet.Router.ExtraHosts {
+		// The aliases fall back to the maintenance page too
+		rule += fmt.Sprintf(" || Host(`%s`)", host)
 	}
 
 	data := MaintenanceTemplateData{
 		ServiceName: env.Quadlet.ServiceName,
-		Rule:        rule,
+		Rule:        unitEscape(rule),
 		Network:     env.Quadlet.Network,
 		TargetDir:   env.Dir,
 		Resolver:    resolver,
@@ -1,8 +1,16 @@
 package main
 
 import (
+	"context"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestGenerateTraefikLabels(t *testing.T) {
@@ -22,7 +30,7 @@ func TestGenerateTraefikLabels(t *testing.T) {
 			wantLabels: []string{
 				"traefik.enable=true",
 				"traefik.http.routers.app.rule=Host(`app.com`)",
-				"traefik.http.routers.app.middlewares=global-auth",
+				"traefik.http.routers.app.middlewares=global-auth@file",
 			},
 		},
 		{
@@ -40,11 +48,89 @@ func TestGenerateTraefikLabels(t *testing.T) {
 				"traefik.http.services.legacy.loadbalancer.server.port=3000",
 			},
 		},
+		{
+			name:        "Rate Limit Defaults Burst",
+			serviceName: "api",
+			router: RouterConfig{
+				Domain:    "api.com",
+				RateLimit: &RateLimitConfig{Average: 50, Period: "1m"},
+			},
+			wantLabels: []string{
+				"traefik.http.middlewares.api-rate.ratelimit.average=50",
+				"traefik.http.middlewares.api-rate.ratelimit.burst=100",
+				"traefik.http.middlewares.api-rate.ratelimit.period=1m",
+				"traefik.http.routers.api.middlewares=api-rate",
+			},
+		},
+		{
+			name:        "Rate Limit Explicit Burst",
+			serviceName: "api",
+			router: RouterConfig{
+				Domain:    "api.com",
+				RateLimit: &RateLimitConfig{Average: 50, Burst: 10},
+			},
+			wantLabels: []string{
+				"traefik.http.middlewares.api-rate.ratelimit.burst=10",
+			},
+		},
+		{
+			name:        "LB Health Check",
+			serviceName: "api",
+			router: RouterConfig{
+				Domain:         "api.com",
+				HealthPath:     "/healthz",
+				HealthInterval: "5s",
+			},
+			wantLabels: []string{
+				"traefik.http.services.api.loadbalancer.healthcheck.path=/healthz",
+				"traefik.http.services.api.loadbalancer.healthcheck.interval=5s",
+			},
+		},
+		{
+			name:        "Redirect WWW To Apex",
+			serviceName: "site",
+			router:      RouterConfig{Domain: "www.example.com", RedirectWWW: "to_apex"},
+			wantLabels: []string{
+				"traefik.http.routers.site.rule=Host(`example.com`)",
+				"traefik.http.routers.site-www.rule=Host(`www.example.com`)",
+				"traefik.http.routers.site-www.middlewares=site-www-redirect",
+				"traefik.http.routers.site-www.service=site",
+				"traefik.http.middlewares.site-www-redirect.redirectregex.regex=^https?://www\\.example\\.com/(.*)",
+				"traefik.http.middlewares.site-www-redirect.redirectregex.replacement=https://example.com/${1}",
+				"traefik.http.middlewares.site-www-redirect.redirectregex.permanent=true",
+			},
+		},
+		{
+			name:        "Redirect Apex To WWW",
+			serviceName: "site",
+			router:      RouterConfig{Domain: "example.com", RedirectWWW: "to_www"},
+			wantLabels: []string{
+				"traefik.http.routers.site.rule=Host(`www.example.com`)",
+				"traefik.http.routers.site-www.rule=Host(`example.com`)",
+				"traefik.http.middlewares.site-www-redirect.redirectregex.regex=^https?://example\\.com/(.*)",
+				"traefik.http.middlewares.site-www-redirect.redirectregex.replacement=https://www.example.com/${1}",
+			},
+		},
+		{
+			name:        "Sticky Sessions",
+			serviceName: "shop",
+			router: RouterConfig{
+				Domain:       "shop.com",
+				Sticky:       true,
+				StickyCookie: StickyCookieConfig{Name: "shop_lb", Secure: true, HTTPOnly: true},
+			},
+			wantLabels: []string{
+				"traefik.http.services.shop.loadbalancer.sticky.cookie=true",
+				"traefik.http.services.shop.loadbalancer.sticky.cookie.name=shop_lb",
+				"traefik.http.services.shop.loadbalancer.sticky.cookie.secure=true",
+				"traefik.http.services.shop.loadbalancer.sticky.cookie.httponly=true",
+			},
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := generateTraefikLabels(tt.serviceName, tt.router, "resolver")
+			got := generateTraefikLabels(tt.serviceName, tt.router, "resolver", "")
 			for _, want := range tt.wantLabels {
 				found := false
 				for _, g := range got {
@@ -60,3 +146,737 @@ func TestGenerateTraefikLabels(t *testing.T) {
 		})
 	}
 }
+
+func TestGenerateTraefikLabelsRulePrecedence(t *testing.T) {
+	disabled := false
+	enabled := true
+	tests := []struct {
+		name     string
+		router   RouterConfig
+		wantRule string
+	}{
+		{
+			name:     "Rule beats Domain and Host",
+			router:   RouterConfig{Rule: "PathPrefix(`/x`)", Domain: "new.com", Host: "old.com"},
+			wantRule: "traefik.http.routers.app.rule=PathPrefix(`/x`)",
+		},
+		{
+			name:     "Domain beats Host",
+			router:   RouterConfig{Domain: "new.com", Host: "old.com"},
+			wantRule: "traefik.http.routers.app.rule=Host(`new.com`)",
+		},
+		{
+			name:     "Host fallback",
+			router:   RouterConfig{Host: "old.com"},
+			wantRule: "traefik.http.routers.app.rule=Host(`old.com`)",
+		},
+		{
+			name:     "Explicitly enabled",
+			router:   RouterConfig{Enabled: &enabled, Domain: "new.com"},
+			wantRule: "traefik.http.routers.app.rule=Host(`new.com`)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := generateTraefikLabels("app", tt.router, "resolver", "")
+			var rules []string
+			for _, g := range got {
+				if strings.HasPrefix(g, "traefik.http.routers.app.rule=") {
+					rules = append(rules, g)
+				}
+			}
+			if len(rules) != 1 || rules[0] != tt.wantRule {
+				t.Errorf("Expected rule %s, got %v", tt.wantRule, rules)
+			}
+		})
+	}
+
+	t.Run("Explicitly disabled", func(t *testing.T) {
+		got := generateTraefikLabels("app", RouterConfig{Enabled: &disabled, Domain: "new.com"}, "resolver", "")
+		if len(got) != 0 {
+			t.Errorf("Expected no labels when router is disabled, got %v", got)
+		}
+	})
+}
+
+func TestGenerateTraefikLabelsHTTPSRedirect(t *testing.T) {
+	r := RouterConfig{
+		Domain:        "app.com",
+		HTTPSRedirect: true,
+		PathPrefix:    "/api",
+		StripPrefix:   true,
+		Auth:          true,
+		Compress:      true,
+	}
+	got := generateTraefikLabels("app", r, "resolver", "")
+
+	wantLabels := []string{
+		"traefik.http.middlewares.app-redirect.redirectscheme.scheme=https",
+		"traefik.http.routers.app.middlewares=app-redirect,app-strip,global-auth@file,app-compress",
+	}
+	for _, want := range wantLabels {
+		found := false
+		for _, g := range got {
+			if g == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("Missing expected label: %s. Got: %v", want, got)
+		}
+	}
+}
+
+func TestVersionedImage(t *testing.T) {
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{image: "localhost/app:latest", want: "localhost/app:v1.2.3"},
+		{image: "localhost/app", want: "localhost/app:v1.2.3"},
+		{image: "registry.local:5000/app:latest", want: "registry.local:5000/app:v1.2.3"},
+		{image: "registry.local:5000/app", want: "registry.local:5000/app:v1.2.3"},
+	}
+	for _, tt := range tests {
+		if got := versionedImage(tt.image, "v1.2.3"); got != tt.want {
+			t.Errorf("versionedImage(%s): expected '%s', got '%s'", tt.image, tt.want, got)
+		}
+	}
+}
+
+func TestBackupRotateAndRestoreScripts(t *testing.T) {
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "server")
+	write := func(name, content string) {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	read := func(name string) string {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return "<missing>"
+		}
+		return string(b)
+	}
+	run := func(script string) {
+		if out, err := exec.Command("sh", "-c", script).CombinedOutput(); err != nil {
+			t.Fatalf("Script failed: %v\n%s\n%s", err, script, out)
+		}
+	}
+
+	write(bin, "v4")
+	write(bin+".bak.1", "v3")
+	write(bin+".bak.2", "v2")
+	write(bin+".bak.3", "v1")
+	write(bin+".bak.7", "stale")
+
+	run(backupRotateScript(bin, 3))
+
+	for name, want := range map[string]string{
+		bin + ".bak.1": "v4",
+		bin + ".bak.2": "v3",
+		bin + ".bak.3": "v2",
+		bin + ".bak.4": "<missing>",
+		bin + ".bak.7": "<missing>",
+	} {
+		if got := read(name); got != want {
+			t.Errorf("After rotate: expected %s = '%s', got '%s'", filepath.Base(name), want, got)
+		}
+	}
+
+	write(bin, "broken")
+	run(backupRestoreScript(bin, 3))
+
+	for name, want := range map[string]string{
+		bin:            "v4",
+		bin + ".bak.1": "v3",
+		bin + ".bak.2": "v2",
+		bin + ".bak.3": "<missing>",
+	} {
+		if got := read(name); got != want {
+			t.Errorf("After restore: expected %s = '%s', got '%s'", filepath.Base(name), want, got)
+		}
+	}
+}
+
+func TestRollbackRestoresSidecarUnits(t *testing.T) {
+	env := Environment{
+		Dir:         "/app",
+		KeepBackups: 3,
+		Quadlet:     Quadlet{ServiceName: "app", Image: "localhost/app:latest"},
+		Sidecars:    []Quadlet{{ServiceName: "redis", Image: "redis:7"}},
+		Pod:         PodConfig{Enabled: true},
+	}
+	want := []string{
+		"~/.config/containers/systemd/app.container",
+		"~/.config/containers/systemd/redis.container",
+		"~/.config/containers/systemd/app.pod",
+	}
+	if got := remoteUnitPaths(env); !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	script := rollbackScript(env, "/app/server", "Dockerfile.vps")
+	for _, p := range want {
+		if !strings.Contains(script, backupRestoreScript(p, 3)) {
+			t.Errorf("Expected rollback to restore %s, got:\n%s", p, script)
+		}
+	}
+
+	// Rotate as syncRelease does, break the units, then restore them
+	home := t.TempDir()
+	units := filepath.Join(home, ".config", "containers", "systemd")
+	os.MkdirAll(units, 0755)
+	run := func(script string) {
+		c := exec.Command("sh", "-c", script)
+		c.Env = append(os.Environ(), "HOME="+home)
+		if out, err := c.CombinedOutput(); err != nil {
+			t.Fatalf("Script failed: %v\n%s\n%s", err, script, out)
+		}
+	}
+	for _, name := range []string{"app.container", "redis.container", "app.pod"} {
+		os.WriteFile(filepath.Join(units, name), []byte("old"), 0644)
+	}
+	for _, p := range remoteUnitPaths(env) {
+		run(backupRotateScript(p, env.KeepBackups))
+	}
+	for _, name := range []string{"app.container", "redis.container", "app.pod"} {
+		os.WriteFile(filepath.Join(units, name), []byte("new"), 0644)
+	}
+	run(restoreUnitsScript(env))
+	for _, name := range []string{"app.container", "redis.container", "app.pod"} {
+		if got, _ := os.ReadFile(filepath.Join(units, name)); string(got) != "old" {
+			t.Errorf("Expected %s to be restored, got %q", name, got)
+		}
+	}
+}
+
+func TestReleaseTargets(t *testing.T) {
+	cfg := Config{Environments: map[string]Environment{"prod": {}, "staging": {}}}
+
+	names, err := releaseTargets(cfg, "all")
+	if err != nil || strings.Join(names, ",") != "prod,staging" {
+		t.Errorf("Expected prod,staging, got %v (err %v)", names, err)
+	}
+	names, err = releaseTargets(cfg, "staging, prod")
+	if err != nil || strings.Join(names, ",") != "staging,prod" {
+		t.Errorf("Expected staging,prod, got %v (err %v)", names, err)
+	}
+	if _, err := releaseTargets(cfg, "prod,qa"); err == nil {
+		t.Error("Expected error for unknown env qa")
+	}
+	if _, err := releaseTargets(Config{}, "all"); err == nil {
+		t.Error("Expected error when no environments are defined")
+	}
+}
+
+func TestGenerateSidecarQuadlets(t *testing.T) {
+	dir := t.TempDir()
+	env := Environment{
+		Dir:      "/app",
+		Quadlet:  Quadlet{ServiceName: "app", Image: "localhost/app:latest", Network: "traefik-net.network"},
+		Sidecars: []Quadlet{{ServiceName: "redis", Image: "docker.io/library/redis:7", Volumes: []string{"./data/redis:/data"}}},
+	}
+
+	files, err := generateSidecarQuadlets(env, dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("Expected 1 sidecar quadlet, got %d", len(files))
+	}
+	sidecar, _ := os.ReadFile(files[0].Path)
+	if string(sidecar) != files[0].Content {
+		t.Errorf("Expected returned content to match the written file")
+	}
+	for _, want := range []string{"ContainerName=redis", "Network=traefik-net.network", "Volume=/app/data/redis:/data"} {
+		if !strings.Contains(string(sidecar), want) {
+			t.Errorf("Expected sidecar quadlet to contain %q, got:\n%s", want, sidecar)
+		}
+	}
+
+	_, main := generateQuadlet(env, dir)
+	if !strings.Contains(string(main), "Requires=traefik.service redis.service") {
+		t.Errorf("Expected app to require redis.service, got:\n%s", main)
+	}
+
+	env.Sidecars = []Quadlet{{ServiceName: "redis"}}
+	if _, err := generateSidecarQuadlets(env, dir); err == nil {
+		t.Error("Expected error for sidecar without image")
+	}
+}
+
+func TestGeneratePodQuadlet(t *testing.T) {
+	dir := t.TempDir()
+	env := Environment{
+		Dir:      "/app",
+		Pod:      PodConfig{Enabled: true, Ports: []string{"127.0.0.1:9100:9100"}},
+		Quadlet:  Quadlet{ServiceName: "app", Image: "localhost/app:latest", Network: "traefik-net.network", Ports: []string{"8080:8080"}},
+		Sidecars: []Quadlet{{ServiceName: "exporter", Image: "docker.io/prom/node-exporter", Ports: []string{"9200:9200"}}},
+	}
+
+	path, pod := generatePodQuadlet(env, dir)
+	if filepath.Base(path) != "app.pod" {
+		t.Errorf("Expected app.pod, got %s", path)
+	}
+	want := "[Pod]\nPodName=app\nNetwork=traefik-net.network\nPublishPort=127.0.0.1:9100:9100\nPublishPort=8080:8080\nPublishPort=9200:9200\n"
+	if !strings.Contains(pod, want) {
+		t.Errorf("Expected pod section %q, got:\n%s", want, pod)
+	}
+
+	_, main := generateQuadlet(env, dir)
+	if !strings.Contains(main, "Pod=app.pod") {
+		t.Errorf("Expected container to join app.pod, got:\n%s", main)
+	}
+	if strings.Contains(main, "Network=") || strings.Contains(main, "PublishPort=") {
+		t.Errorf("Expected network and ports on the pod only, got:\n%s", main)
+	}
+
+	files, err := generateSidecarQuadlets(env, dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(files[0].Content, "Pod=app.pod") || strings.Contains(files[0].Content, "PublishPort=") {
+		t.Errorf("Expected sidecar in the pod without ports, got:\n%s", files[0].Content)
+	}
+
+	env.Pod.Name = "shop"
+	if path, _ := generatePodQuadlet(env, dir); filepath.Base(path) != "shop.pod" {
+		t.Errorf("Expected shop.pod for pod.name, got %s", path)
+	}
+}
+
+func TestGenerateTraefikLabelsRateLimitWithoutAverage(t *testing.T) {
+	got := generateTraefikLabels("api", RouterConfig{Domain: "api.com", RateLimit: &RateLimitConfig{Burst: 10}}, "resolver", "")
+	for _, g := range got {
+		if strings.Contains(g, "ratelimit") {
+			t.Errorf("Expected no ratelimit labels without average, got %s", g)
+		}
+	}
+}
+
+func TestHealthCheckScriptPrecedence(t *testing.T) {
+	script, target := healthCheckScript(Quadlet{HealthURL: "http://localhost:8080/health", HealthTCP: "5432"})
+	if target != "http://localhost:8080/health" || !strings.Contains(script, "curl -s -f 'http://localhost:8080/health'") {
+		t.Errorf("Expected health_url to win, got target %s:\n%s", target, script)
+	}
+
+	script, target = healthCheckScript(Quadlet{HealthTCP: "6379"})
+	if target != "tcp://127.0.0.1:6379" || !strings.Contains(script, "nc -z -w 2 '127.0.0.1' 6379") {
+		t.Errorf("Expected TCP probe on 127.0.0.1:6379, got target %s:\n%s", target, script)
+	}
+
+	if script, _ := healthCheckScript(Quadlet{}); script != "" {
+		t.Errorf("Expected no script without health config, got:\n%s", script)
+	}
+}
+
+func TestSplitHealthTCP(t *testing.T) {
+	if host, port, err := splitHealthTCP("[::1]:5432"); err != nil || host != "::1" || port != 5432 {
+		t.Errorf("Expected ::1 5432, got %s %d %v", host, port, err)
+	}
+	for _, bad := range []string{"db:$(reboot)", "db:0", "db:70000", ":5432", "redis"} {
+		if _, _, err := splitHealthTCP(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+
+	script, _ := healthCheckScript(Quadlet{HealthTCP: "db;reboot:5432"})
+	if !strings.Contains(script, "nc -z -w 2 'db;reboot' 5432") {
+		t.Errorf("Expected the host shell-quoted, got:\n%s", script)
+	}
+}
+
+func TestHealthCheckScriptTCPRuns(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not available")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+
+	script, _ := healthCheckScript(Quadlet{HealthTCP: ln.Addr().String()})
+	out, err := exec.Command("bash", "-c", script).CombinedOutput()
+	if err != nil || !strings.Contains(string(out), "OK") {
+		t.Errorf("Expected TCP health check to pass, got %v: %s", err, out)
+	}
+}
+
+func TestHealthCheckScriptRetries(t *testing.T) {
+	script, _ := healthCheckScript(Quadlet{HealthURL: "http://localhost/", HealthRetries: 40, HealthInterval: 5, HealthStartPeriod: 30})
+	for _, want := range []string{"sleep 30\n", "for i in $(seq 1 40); do", "\t\t\t\tsleep 5\n"} {
+		if !strings.Contains(script, want) {
+			t.Errorf("Expected %q in script:\n%s", want, script)
+		}
+	}
+
+	script, _ = healthCheckScript(Quadlet{HealthURL: "http://localhost/"})
+	for _, want := range []string{"sleep 0\n", "for i in $(seq 1 15); do", "\t\t\t\tsleep 2\n"} {
+		if !strings.Contains(script, want) {
+			t.Errorf("Expected default %q in script:\n%s", want, script)
+		}
+	}
+}
+
+func TestMaintenanceServing(t *testing.T) {
+	if got := maintenanceServing(true, true); got != "app (priority 100)" {
+		t.Errorf("Expected app to win while active, got %s", got)
+	}
+	if got := maintenanceServing(false, true); got != "maintenance page (priority 1)" {
+		t.Errorf("Expected maintenance page, got %s", got)
+	}
+	if got := maintenanceServing(false, false); !strings.HasPrefix(got, "nothing") {
+		t.Errorf("Expected nothing served, got %s", got)
+	}
+}
+
+func TestMaintenanceScheduleScript(t *testing.T) {
+	env := Environment{Dir: "/srv/app", Quadlet: Quadlet{ServiceName: "app"}}
+	at := time.Date(2025, 3, 1, 18, 30, 0, 0, time.UTC)
+	script := maintenanceScheduleScript(env, 30*time.Minute, at)
+
+	for _, want := range []string{
+		"systemctl --user stop app-maint-off.timer",
+		"systemd-run --user --unit=app-maint-off --on-active=1800s /bin/sh -c ",
+		"systemctl --user stop app-maint.service",
+		"echo 2025-03-01T18:30:00Z > /srv/app/maintenance/.until",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("Expected %q in:\n%s", want, script)
+		}
+	}
+	if !strings.Contains(maintenanceDisableScript(env), "rm -f ~/.config/containers/systemd/app-maint.container /srv/app/maintenance/.until") {
+		t.Errorf("Expected disable to remove the unit and the schedule, got %s", maintenanceDisableScript(env))
+	}
+}
+
+func TestMaintenanceDisableRestartsStoppedApp(t *testing.T) {
+	env := Environment{Dir: "/srv/app", Quadlet: Quadlet{ServiceName: "app"}}
+	script := maintenanceDisableScript(env)
+	want := "{ [ ! -f /srv/app/maintenance/.app-stopped ] || { systemctl --user start app.service && rm -f /srv/app/maintenance/.app-stopped; }; }"
+	if !strings.HasPrefix(script, want) {
+		t.Errorf("Expected disable to start the app before stopping the page, got %s", script)
+	}
+}
+
+func TestMaintenanceHTML(t *testing.T) {
+	dir := t.TempDir()
+	m := MaintenanceConfig{Title: "Upgrade", Text: "Back at 10:00"}
+
+	if out := string(maintenanceHTML(m)); !strings.Contains(out, "Upgrade") {
+		t.Errorf("Expected built-in page with the title, got:\n%s", out)
+	}
+
+	m.HTMLFile = filepath.Join(dir, "plain.html")
+	os.WriteFile(m.HTMLFile, []byte("<h1>Brand</h1>"), 0644)
+	if out := string(maintenanceHTML(m)); out != "<h1>Brand</h1>" {
+		t.Errorf("Expected the plain file as-is, got %q", out)
+	}
+
+	m.HTMLFile = filepath.Join(dir, "tmpl.html")
+	os.WriteFile(m.HTMLFile, []byte("<h1>{{ .Title }}</h1><p>{{ .Text }}</p>"), 0644)
+	if out := string(maintenanceHTML(m)); out != "<h1>Upgrade</h1><p>Back at 10:00</p>" {
+		t.Errorf("Expected the templated file, got %q", out)
+	}
+
+	m.HTMLFile = filepath.Join(dir, "missing.html")
+	if out := string(maintenanceHTML(m)); !strings.Contains(out, "Upgrade") || !strings.Contains(out, "<!doctype html>") {
+		t.Errorf("Expected fallback to the built-in page, got:\n%s", out)
+	}
+}
+
+func TestCheckPrebuiltBinary(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := Config{BinaryName: "server"}
+	if err := checkPrebuiltBinary(cfg); err == nil {
+		t.Error("Expected error when build/server is missing")
+	}
+	os.MkdirAll("build", 0755)
+	os.WriteFile(filepath.Join("build", "server"), []byte("bin"), 0755)
+	if err := checkPrebuiltBinary(cfg); err != nil {
+		t.Errorf("Expected prebuilt binary to be accepted, got %v", err)
+	}
+}
+
+func TestBuildOS(t *testing.T) {
+	if goos, err := buildOS(Config{}); err != nil || goos != "linux" {
+		t.Errorf("Expected default linux, got %s (err %v)", goos, err)
+	}
+	if goos, err := buildOS(Config{Build: BuildConfig{OS: "freebsd"}}); err != nil || goos != "freebsd" {
+		t.Errorf("Expected freebsd, got %s (err %v)", goos, err)
+	}
+	if _, err := buildOS(Config{Build: BuildConfig{OS: "beos"}}); err == nil {
+		t.Error("Expected error for unsupported os beos")
+	}
+}
+
+func TestBuildArches(t *testing.T) {
+	if arches, err := buildArches(Config{}); err != nil || strings.Join(arches, ",") != "amd64" {
+		t.Errorf("Expected default amd64, got %v (err %v)", arches, err)
+	}
+	if _, err := buildArches(Config{Build: BuildConfig{Arch: "386"}}); err == nil {
+		t.Error("Expected error for unsupported arch 386")
+	}
+
+	cfg := Config{BinaryName: "server", Build: BuildConfig{Arches: []string{"amd64", "arm64"}}}
+	if got := binaryOutput(cfg, "arm64"); got != filepath.Join("build", "server-arm64") {
+		t.Errorf("Expected build/server-arm64, got %s", got)
+	}
+	if got := binaryOutput(Config{BinaryName: "server"}, "amd64"); got != filepath.Join("build", "server") {
+		t.Errorf("Expected build/server for single-arch builds, got %s", got)
+	}
+
+	if arch, err := envArch(cfg, Environment{Arch: "arm64"}); err != nil || arch != "arm64" {
+		t.Errorf("Expected arm64, got %s (err %v)", arch, err)
+	}
+	if arch, _ := envArch(cfg, Environment{}); arch != "amd64" {
+		t.Errorf("Expected first built arch, got %s", arch)
+	}
+	if _, err := envArch(Config{}, Environment{Arch: "arm64"}); err == nil {
+		t.Error("Expected error when env arch is not built")
+	}
+}
+
+func TestActivationScriptPlatform(t *testing.T) {
+	env := Environment{Dir: "/app", Arch: "arm64", Quadlet: Quadlet{ServiceName: "app", Image: "localhost/app:latest"}}
+	script := activationScript(env, "v1.0.0", "abc123", "localhost/app:v1.0.0", "Dockerfile")
+	if !strings.Contains(script, "podman build --platform=linux/arm64 -f Dockerfile") {
+		t.Errorf("Expected --platform=linux/arm64 in:\n%s", script)
+	}
+}
+
+func TestActivationScriptPullImage(t *testing.T) {
+	image := registryImage("ghcr.io/acme/", "app", "v1.0.0")
+	if image != "ghcr.io/acme/app:v1.0.0" {
+		t.Errorf("Expected ghcr.io/acme/app:v1.0.0, got %s", image)
+	}
+	env := Environment{Dir: "/app", Arch: "arm64", Quadlet: Quadlet{ServiceName: "app", Image: image, PullImage: true}}
+	script := activationScript(env, "v1.0.0", "abc123", image, "Dockerfile")
+	if !strings.Contains(script, "podman pull ghcr.io/acme/app:v1.0.0") {
+		t.Errorf("Expected podman pull of the pushed tag in:\n%s", script)
+	}
+	if strings.Contains(script, "podman build") {
+		t.Errorf("Expected no server-side build in registry mode:\n%s", script)
+	}
+}
+
+func TestReleaseArtifactsPullImage(t *testing.T) {
+	cfg := Config{BinaryName: "server"}
+	env := Environment{Quadlet: Quadlet{PullImage: true}}
+	got := releaseArtifacts(cfg, env, "build/server")
+	if slices.Contains(got, "build/server") {
+		t.Errorf("Expected no binary upload in registry mode, got %v", got)
+	}
+	env.Quadlet.PullImage = false
+	got = releaseArtifacts(cfg, env, "build/server")
+	if len(got) == 0 || got[0] != "build/server" {
+		t.Errorf("Expected the binary first, got %v", got)
+	}
+}
+
+func TestArtifactRsyncArgsProtectState(t *testing.T) {
+	cfg := Config{BinaryName: "server", Artifacts: ArtifactsConfig{Exclude: []string{"*.log"}}}
+	args := artifactRsyncArgs(cfg)
+	if args[0] != "--exclude=*.log" || args[1] != "--delete" {
+		t.Errorf("Expected excludes then --delete, got %v", args)
+	}
+	for _, want := range []string{"--filter=P /.deploy*", "--filter=P /.env", "--filter=P /data/", "--filter=P /maintenance/", "--filter=P /server.bak.*"} {
+		if !slices.Contains(args, want) {
+			t.Errorf("Expected %q in %v", want, args)
+		}
+	}
+}
+
+func TestValidateVersion(t *testing.T) {
+	for _, v := range []string{"v1.0.1", "1.0.1", "v0.10.0", "v1.2.3-rc.1", "v1.2.3+build.5"} {
+		if err := validateVersion(v); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", v, err)
+		}
+	}
+	for _, v := range []string{"v1.0.o", "v1..2", "v1.2", "v01.2.3", "v1.2.3-", "v1.2.3-rc..1", "latest", ""} {
+		if err := validateVersion(v); err == nil {
+			t.Errorf("Expected %q to be rejected", v)
+		}
+	}
+}
+
+func TestLatestVersionTag(t *testing.T) {
+	tags := []string{"v1.9.0", "nightly", "v1.10.0-rc.1", "v1.10.0", "1.2.0"}
+	if got := latestVersionTag(tags); got != "v1.10.0" {
+		t.Errorf("Expected v1.10.0, got %s", got)
+	}
+	if got := latestVersionTag([]string{"nightly"}); got != "" {
+		t.Errorf("Expected no version, got %s", got)
+	}
+}
+
+func TestHeadOnRef(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("GIT_AUTHOR_NAME", "test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+	git := func(args ...string) {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q", "-b", "main")
+	git("commit", "-q", "--allow-empty", "-m", "one")
+	git("commit", "-q", "--allow-empty", "-m", "two")
+	git("checkout", "-q", "-b", "staging")
+	git("commit", "-q", "--allow-empty", "-m", "staging only")
+
+	if ok, err := headOnRef("main"); ok || err != nil {
+		t.Errorf("Expected staging HEAD not to be on main, got ok=%v err=%v", ok, err)
+	}
+	git("checkout", "-q", "main~1")
+	if ok, err := headOnRef("main"); !ok || err != nil {
+		t.Errorf("Expected an older main commit to be on main, got ok=%v err=%v", ok, err)
+	}
+	if _, err := headOnRef("nope"); err == nil {
+		t.Errorf("Expected an error for an unknown ref")
+	}
+}
+
+func TestDirtyVersion(t *testing.T) {
+	for in, want := range map[string]string{"v1.2.3": "v1.2.3-dirty", "v1.2.3-dirty": "v1.2.3-dirty"} {
+		if got := dirtyVersion(in); got != want {
+			t.Errorf("Expected %s, got %s", want, got)
+		}
+	}
+	t.Chdir(t.TempDir()) // Not a git repository
+	if got := dirtyVersion(""); got != "v0.0.0-dirty" {
+		t.Errorf("Expected v0.0.0-dirty outside git, got %s", got)
+	}
+}
+
+func TestWithEnvFile(t *testing.T) {
+	env := Environment{Secrets: SecretsConfig{File: "secrets.prod.env.age"}}
+	if got := withEnvFile(env, ""); got.Secrets.File == "" {
+		t.Errorf("Expected no override without --env-file, got %+v", got)
+	}
+	got := withEnvFile(env, ".env.prod-eu")
+	if got.SyncEnvFile != ".env.prod-eu" || got.Secrets.File != "" {
+		t.Errorf("Expected .env.prod-eu to replace the secrets, got %+v", got)
+	}
+	if _, _, ok, err := localEnvFile(got); !ok || err != nil {
+		t.Errorf("Expected the override to be synced, got ok=%v err=%v", ok, err)
+	}
+}
+
+func TestActivationScriptRecordsCommit(t *testing.T) {
+	env := Environment{Dir: "/app", Quadlet: Quadlet{ServiceName: "app", Image: "localhost/app:latest"}}
+	script := activationScript(env, "v1.0.0", "abc123", "localhost/app:v1.0.0", "Dockerfile")
+	for _, want := range []string{"echo 'v1.0.0' > /app/.deploy-version", "echo 'abc123' > /app/.deploy-commit"} {
+		if !strings.Contains(script, want) {
+			t.Errorf("Expected %q in:\n%s", want, script)
+		}
+	}
+	if got := shortCommit("0123456789abcdef0123"); got != "0123456789ab" {
+		t.Errorf("Expected 12-char commit, got %s", got)
+	}
+}
+
+func TestNetworkExistsScript(t *testing.T) {
+	script := networkExistsScript("traefik-net.network")
+	for _, want := range []string{"test -f ~/.config/containers/systemd/traefik-net.network", "podman network exists systemd-traefik-net", "podman network exists traefik-net"} {
+		if !strings.Contains(script, want) {
+			t.Errorf("Expected %q in: %s", want, script)
+		}
+	}
+	if ensureNetwork(context.Background(), Environment{}) != nil {
+		t.Error("Expected no check without quadlet.network")
+	}
+}
+
+func TestDiskFreeScriptCreatesNothing(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "it's", "app")
+	out, err := exec.Command("sh", "-c", diskFreeScript(missing)).Output()
+	if err != nil {
+		t.Fatalf("Expected df of the nearest existing parent, got %v", err)
+	}
+	if _, err := strconv.Atoi(strings.TrimSpace(string(out))); err != nil {
+		t.Errorf("Expected free KB, got %q", out)
+	}
+	if _, err := os.Stat(filepath.Dir(missing)); !os.IsNotExist(err) {
+		t.Errorf("Expected the check not to create %s", filepath.Dir(missing))
+	}
+}
+
+func TestGenerateTraefikLabelsStickyOffByDefault(t *testing.T) {
+	for _, l := range generateTraefikLabels("app", RouterConfig{Domain: "app.com"}, "resolver", "") {
+		if strings.Contains(l, "sticky") {
+			t.Errorf("Did not expect sticky labels by default, got %s", l)
+		}
+	}
+}
+
+func TestRouterWithHealthPath(t *testing.T) {
+	q := Quadlet{HealthURL: "http://localhost:8080/health?deep=1"}
+	if got := routerWithHealthPath(q).HealthPath; got != "/health?deep=1" {
+		t.Errorf("Expected health_path derived from health_url, got %s", got)
+	}
+
+	q.Router.HealthPath = "/ready"
+	if got := routerWithHealthPath(q).HealthPath; got != "/ready" {
+		t.Errorf("Expected explicit health_path to win, got %s", got)
+	}
+
+	labels := generateTraefikLabels("app", routerWithHealthPath(Quadlet{HealthURL: "http://localhost:8080/health", Router: RouterConfig{Domain: "app.com"}}), "resolver", "")
+	if !slices.Contains(labels, "traefik.http.services.app.loadbalancer.healthcheck.interval=10s") {
+		t.Errorf("Expected default 10s interval, got %v", labels)
+	}
+	for _, l := range generateTraefikLabels("app", RouterConfig{Domain: "app.com"}, "resolver", "") {
+		if strings.Contains(l, "healthcheck") {
+			t.Errorf("Did not expect healthcheck labels without a path, got %s", l)
+		}
+	}
+}
+
+func TestGenerateTraefikLabelsExtraHosts(t *testing.T) {
+	r := RouterConfig{
+		Domain:     "app.com",
+		Auth:       true,
+		ExtraHosts: []string{"app.org", "app.net"},
+	}
+	got := strings.Join(generateTraefikLabels("app", r, "resolver", ""), "\n")
+
+	wantLabels := []string{
+		"traefik.http.routers.app.rule=Host(`app.com`)",
+		"traefik.http.routers.app-1.rule=Host(`app.org`)",
+		"traefik.http.routers.app-1.service=app",
+		"traefik.http.routers.app-1.middlewares=global-auth@file",
+		"traefik.http.routers.app-1.tls.certresolver=resolver",
+		"traefik.http.routers.app-2.rule=Host(`app.net`)",
+		"traefik.http.routers.app-2.priority=100",
+		"traefik.http.routers.app-2.service=app",
+	}
+	for _, want := range wantLabels {
+		if !strings.Contains(got, want+"\n") && !strings.HasSuffix(got, want) {
+			t.Errorf("Missing expected label: %s. Got:\n%s", want, got)
+		}
+	}
+}
+
+func TestGenerateTraefikLabelsAuthProvider(t *testing.T) {
+	r := RouterConfig{Domain: "app.com", Auth: true}
+	tests := []struct {
+		provider string
+		want     string
+	}{
+		{provider: "", want: "traefik.http.routers.app.middlewares=global-auth@file"},
+		{provider: "basic", want: "traefik.http.routers.app.middlewares=global-auth@file"},
+		{provider: "authelia", want: "traefik.http.routers.app.middlewares=authelia@file"},
+	}
+	for _, tt := range tests {
+		got := strings.Join(generateTraefikLabels("app", r, "resolver", tt.provider), "\n")
+		if !strings.Contains(got, tt.want) {
+			t.Errorf("Provider '%s': expected %s, got:\n%s", tt.provider, tt.want, got)
+		}
+	}
+}
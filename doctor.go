@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// doctorCheck is one item of the 'deploy doctor' checklist.
+// Hard checks fail the command; soft ones only warn.
+type doctorCheck struct {
+	Name   string
+	Script string
+	Hard   bool
+}
+
+// dirWritableScript checks that dir is writable, or can be created in its parent,
+// without creating anything (doctor also runs in dry-run).
+func dirWritableScript(dir string) string {
+	parent := path.Dir(strings.TrimRight(dir, "/"))
+	return fmt.Sprintf("test -w %s || { test ! -e %s && test -w %s; }", shellQuote(dir), shellQuote(dir), shellQuote(parent))
+}
+
+// doctorChecks lists the remote requirements for deploying env.
+func doctorChecks(env Environment) []doctorCheck {
+	checks := []doctorCheck{
+		{Name: "rsync and podman installed", Script: requireRemoteTools("rsync", "podman"), Hard: true},
+		{Name: "Lingering enabled (services survive logout)", Script: `[ "$(loginctl show-user "$(id -un)" --property=Linger --value 2>/dev/null)" = "yes" ] || { echo "run: sudo loginctl enable-linger $(id -un)" >&2; exit 1; }`, Hard: true},
+		{Name: "target_dir writable", Script: dirWritableScript(env.Dir), Hard: true},
+	}
+
+	switch env.Database.Driver {
+	case "sqlite":
+		checks = append(checks, doctorCheck{Name: "sqlite3 installed", Script: requireRemoteTools("sqlite3"), Hard: true})
+	case "postgres":
+		checks = append(checks, doctorCheck{Name: "pg_dump and pg_restore installed", Script: requireRemoteTools("pg_dump", "pg_restore"), Hard: true})
+	default:
+		checks = append(checks, doctorCheck{Name: "sqlite3 installed (for 'deploy db')", Script: requireRemoteTools("sqlite3")})
+	}
+
+	// Quadlet networks are created as systemd-<name> unless NetworkName is set
+	network := strings.TrimSuffix(env.Quadlet.Network, ".network")
+	if network == "" {
+		network = "traefik-net"
+	}
+	checks = append(checks, doctorCheck{
+		Name:   fmt.Sprintf("Traefik network '%s' exists", network),
+		Script: networkExistsScript(network),
+		Hard:   env.Quadlet.Network != "",
+	})
+	return checks
+}
+
+func doDoctor(envName string) {
+	_, env := loadEnv(envName)
+	logInfo("🩺 Checking %s (%s@%s)...", envName, env.User, env.Host)
+
+	if _, err := runSSHOutput(env, "true"); err != nil {
+		fmt.Printf("  %s❌%s SSH connectivity: %v\n", Red, Reset, err)
+		logFatal("Cannot reach %s; remaining checks skipped.", env.Host)
+	}
+	fmt.Printf("  %s✅%s SSH connectivity\n", Green, Reset)
+
+	failed := 0
+	for _, c := range doctorChecks(env) {
+		out, err := runSSHOutput(env, "{ "+c.Script+"; } 2>&1")
+		if err == nil {
+			fmt.Printf("  %s✅%s %s\n", Green, Reset, c.Name)
+			continue
+		}
+		detail := strings.TrimSpace(out)
+		if detail != "" {
+			detail = " (" + detail + ")"
+		}
+		if c.Hard {
+			failed++
+			fmt.Printf("  %s❌%s %s%s\n", Red, Reset, c.Name, detail)
+		} else {
+			fmt.Printf("  %s⚠️ %s %s%s\n", Yellow, Reset, c.Name, detail)
+		}
+	}
+
+	if failed > 0 {
+		logFatal("%d hard requirement(s) failed.", failed)
+	}
+	logSuccess("✅ %s is ready for deploys.", envName)
+}
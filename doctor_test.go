@@ -0,0 +1,24 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirWritableScriptCreatesNothing(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "it's")
+	os.Mkdir(base, 0755)
+	missing := filepath.Join(base, "app")
+
+	if err := exec.Command("sh", "-c", dirWritableScript(missing)).Run(); err != nil {
+		t.Errorf("Expected a creatable target_dir to pass, got %v", err)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("Expected the check not to create %s", missing)
+	}
+	if err := exec.Command("sh", "-c", dirWritableScript(filepath.Join(base, "a", "b"))).Run(); err == nil {
+		t.Errorf("Expected a target_dir without a writable parent to fail")
+	}
+}
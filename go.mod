@@ -4,5 +4,9 @@ go 1.26.1
 
 require (
 	golang.org/x/crypto v0.48.0
+	golang.org/x/mod v0.41.0
+	golang.org/x/term v0.40.0
 	gopkg.in/yaml.v3 v3.0.1
 )
+
+require golang.org/x/sys v0.41.0 // indirect
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/user"
+	"strings"
+	"time"
+)
+
+// historyFile lives in target_dir and is an append-only audit log of releases.
+const historyFile = ".deploy-history.log"
+
+// historyMaxLines caps the log so it cannot grow without bound.
+const historyMaxLines = 100
+
+// HistoryEntry is one line of the history log (tab-separated on disk).
+type HistoryEntry struct {
+	Time    string
+	User    string
+	Version string
+	Commit  string
+	Outcome string // "success", "rolled_back", "failed" or "manual_rollback"
+}
+
+func (h HistoryEntry) String() string {
+	field := func(s string) string {
+		s = strings.NewReplacer("\t", " ", "\n", " ").Replace(s)
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	return strings.Join([]string{field(h.Time), field(h.User), field(h.Version), field(h.Commit), field(h.Outcome)}, "\t")
+}
+
+// parseHistoryLine is the inverse of HistoryEntry.String; malformed lines are skipped.
+func parseHistoryLine(line string) (HistoryEntry, bool) {
+	parts := strings.Split(line, "\t")
+	if len(parts) != 5 {
+		return HistoryEntry{}, false
+	}
+	return HistoryEntry{Time: parts[0], User: parts[1], Version: parts[2], Commit: parts[3], Outcome: parts[4]}, true
+}
+
+// localUsername is the local account that runs the deploy.
+func localUsername() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return "unknown"
+}
+
+// historyAppendScript appends entry and trims the log to the last historyMaxLines lines.
+func historyAppendScript(env Environment, entry HistoryEntry) string {
+	path := fmt.Sprintf("%s/%s", strings.TrimRight(env.Dir, "/"), historyFile)
+	return fmt.Sprintf("echo %s >> %s && tail -n %d %s > %s.tmp && mv %s.tmp %s",
+		shellQuote(entry.String()), path, historyMaxLines, path, path, path, path)
+}
+
+// recordHistory appends an outcome to the remote history log.
+// Failures only warn: the audit trail must never fail a deploy.
+func recordHistory(ctx context.Context, env Environment, version, commit, outcome string) {
+	entry := HistoryEntry{
+		Time:    time.Now().UTC().Format(time.RFC3339),
+		User:    localUsername(),
+		Version: version,
+		Commit:  commit,
+		Outcome: outcome,
+	}
+	if err := runSSHContext(ctx, env, historyAppendScript(env, entry)); err != nil {
+		logWarn("Failed to record deploy history: %v", err)
+	}
+}
+
+func doHistory(envName string, limit int) {
+	_, env := loadEnv(envName)
+	if limit <= 0 {
+		limit = 20
+	}
+
+	out, err := runSSHOutput(env, fmt.Sprintf("tail -n %d %s/%s 2>/dev/null || true", limit, env.Dir, historyFile))
+	if err != nil {
+		logFatal("Failed to read history from %s: %v", env.Host, err)
+	}
+	if out == "" {
+		logInfo("No deploy history on %s yet.", env.Host)
+		return
+	}
+
+	logInfo("📜 Deploy history for %s (%s):", envName, env.Host)
+	fmt.Printf("  %-20s  %-12s  %-14s  %-8s  %s\n", "TIME (UTC)", "USER", "VERSION", "COMMIT", "OUTCOME")
+	for _, line := range strings.Split(out, "\n") {
+		h, ok := parseHistoryLine(line)
+		if !ok {
+			continue
+		}
+		commit := h.Commit
+		if len(commit) > 7 {
+			commit = commit[:7]
+		}
+		color := Yellow
+		switch h.Outcome {
+		case "success":
+			color = Green
+		case "failed":
+			color = Red
+		}
+		fmt.Printf("  %-20s  %-12s  %-14s  %-8s  %s%s%s\n", h.Time, h.User, h.Version, commit, color, h.Outcome, Reset)
+	}
+}
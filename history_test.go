@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHistoryEntryRoundTrip(t *testing.T) {
+	entry := HistoryEntry{Time: "2026-01-02T03:04:05Z", User: "alice", Version: "v1.2.3", Commit: "", Outcome: "success"}
+
+	line := entry.String()
+	if strings.Count(line, "\t") != 4 {
+		t.Fatalf("Expected 5 tab-separated fields, got %q", line)
+	}
+	got, ok := parseHistoryLine(line)
+	if !ok {
+		t.Fatalf("Failed to parse %q", line)
+	}
+	entry.Commit = "-"
+	if got != entry {
+		t.Errorf("Expected %+v, got %+v", entry, got)
+	}
+
+	if _, ok := parseHistoryLine("garbage"); ok {
+		t.Error("Expected malformed line to be rejected")
+	}
+}
+
+func TestHistoryAppendScript(t *testing.T) {
+	env := Environment{Dir: "/app/"}
+	script := historyAppendScript(env, HistoryEntry{User: "o'brien", Outcome: "success"})
+
+	if !strings.Contains(script, ">> /app/.deploy-history.log") {
+		t.Errorf("Expected append to target_dir history file: %s", script)
+	}
+	if !strings.Contains(script, "tail -n 100 /app/.deploy-history.log") {
+		t.Errorf("Expected log capped at 100 lines: %s", script)
+	}
+	if !strings.Contains(script, `o'\''brien`) {
+		t.Errorf("Expected user to be shell-quoted: %s", script)
+	}
+}
@@ -4,18 +4,53 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"time"
 )
 
 // --- Global Flags ---
 var (
-	dryRun  bool
-	verbose bool
+	dryRun    bool
+	verbose   bool
+	assumeYes bool
+
+	rsyncBwLimit  int // KB/s, 0 = unlimited
+	rsyncProgress bool
+	streamOutput  bool // Show command output live (build, ssh) without -v's extra logging
+	noMultiplex   bool // One ssh connection per command instead of a shared ControlMaster
+
+	releaseTimeout time.Duration // Hard limit for 'deploy release', 0 = none
+
+	noColor  bool
+	jsonLogs bool
+	logFile  string // Tee log output (uncolored) into this file
+
+	configPath       = "deploy.yaml"
+	serverConfigPath = "server.yaml"
 )
 
 func main() {
 	flag.BoolVar(&dryRun, "dry-run", false, "Print commands without executing")
 	flag.BoolVar(&verbose, "v", false, "Verbose output")
+	flag.BoolVar(&streamOutput, "stream", false, "Stream build/podman output live instead of buffering it")
+	flag.BoolVar(&assumeYes, "yes", false, "Automatically confirm all prompts")
+	flag.BoolVar(&assumeYes, "y", false, "Shorthand for --yes")
+	flag.StringVar(&configPath, "config", configPath, "Path to deploy.yaml (relative paths inside stay relative to the working directory)")
+	flag.StringVar(&configPath, "c", configPath, "Shorthand for --config")
+	flag.IntVar(&rsyncBwLimit, "bwlimit", 0, "Limit rsync bandwidth (KB/s)")
+	flag.BoolVar(&rsyncProgress, "progress", false, "Show rsync transfer progress")
+	flag.BoolVar(&noMultiplex, "no-multiplex", false, "Disable SSH connection sharing (ControlMaster), e.g. for MFA jump hosts")
+	flag.StringVar(&serverConfigPath, "server-config", serverConfigPath, "Path to server.yaml")
+	flag.DurationVar(&releaseTimeout, "timeout", 0, "Abort a release after this long (e.g. 15m) and roll back")
+	flag.BoolVar(&noColor, "no-color", false, "Disable ANSI colors (automatic when stdout is not a terminal)")
+	flag.BoolVar(&jsonLogs, "log-json", false, "Print log lines as JSON objects (time, level, message)")
+	flag.StringVar(&logFile, "log-file", "", "Also append log output (uncolored) to this file")
 	flag.Parse()
+	setupLogging(noColor, jsonLogs)
+	if logFile != "" {
+		if err := openLogFile(logFile); err != nil {
+			logFatal("Cannot open log file %s: %v", logFile, err)
+		}
+	}
 
 	args := flag.Args()
 	if len(args) < 1 {
@@ -24,69 +59,169 @@ func main() {
 	}
 
 	switch args[0] {
+	case "version", "--version":
+		doVersion()
 	case "init":
-		doInit()
+		initCmd := flag.NewFlagSet("init", flag.ExitOnError)
+		var opts InitOptions
+		initCmd.BoolVar(&opts.Force, "force", false, "Overwrite an existing deploy.yaml")
+		initCmd.StringVar(&opts.App, "app", "", "App name (default: current directory name)")
+		initCmd.StringVar(&opts.User, "user", "", "SSH user (default: current user)")
+		initCmd.StringVar(&opts.Host, "host", "", "Server host (default: vps.example.com)")
+		initCmd.StringVar(&opts.Binary, "binary", "", "Binary name (default: <app>-server)")
+		initCmd.Parse(args[1:])
+		doInit(opts)
 	case "release":
 		// Syntax 1: deploy release <env> (Interactive/Auto)
 		// Syntax 2: deploy release <version> <env> (Explicit)
+		releaseCmd := flag.NewFlagSet("release", flag.ExitOnError)
+		var opts ReleaseOptions
+		releaseCmd.BoolVar(&opts.ForceUnlock, "force-unlock", false, "Remove a stale remote deploy lock before releasing")
+		releaseCmd.BoolVar(&opts.NoRollback, "no-rollback", false, "On failure, leave the new version in place for debugging")
+		releaseCmd.BoolVar(&opts.SkipBuild, "skip-build", false, "Reuse the existing build/<binary> instead of compiling")
+		releaseCmd.BoolVar(&opts.SkipMigrate, "skip-migrate", false, "Do not run migrate.cmd before restarting")
+		releaseCmd.BoolVar(&opts.IfChanged, "if-changed", false, "Skip environments already running the current git commit")
+		releaseCmd.IntVar(&opts.Parallel, "parallel", 4, "Max environments released concurrently (for 'all' or env1,env2)")
+		releaseCmd.BoolVar(&opts.Rolling, "rolling", false, "Keep a transient instance serving while the service restarts")
+		releaseCmd.StringVar(&opts.EnvFile, "env-file", "", "Sync this local file as .env instead of sync_env_file/secrets")
+		releaseCmd.BoolVar(&opts.ForceDirty, "force-dirty", false, "Release uncommitted changes as <version>-dirty (no tag is created)")
+		tagOnly := releaseCmd.Bool("tag-only", false, "Only resolve/create and push the version tag, then print it")
+		releaseCmd.Parse(args[1:])
+		rest := releaseCmd.Args()
+		opts.Timeout = releaseTimeout
+
 		var envName, version string
-		if len(args) == 2 {
-			envName = args[1]
+		switch {
+		case *tagOnly && opts.ForceDirty:
+			logFatal("--tag-only cannot tag a dirty tree; drop --force-dirty")
+		case *tagOnly && len(rest) <= 1:
+			if len(rest) == 1 {
+				version = rest[0]
+			}
+			if err := doTagOnly(version); err != nil {
+				logFatal("%v", err)
+			}
+		case *tagOnly:
+			logFatal("Usage: deploy release --tag-only [version]")
+		case len(rest) == 1:
+			envName = rest[0]
 			version = "" // Trigger auto-detection
-		} else if len(args) == 3 {
-			version = args[1]
-			envName = args[2]
-		} else {
-			logFatal("Usage: deploy release [version] <env>")
+		case len(rest) == 2:
+			version = rest[0]
+			envName = rest[1]
+		default:
+			logFatal("Usage: deploy release [--force-unlock] [--no-rollback] [--skip-build] [--skip-migrate] [--parallel N] [--rolling] [--env-file <path>] [--force-dirty] [--tag-only] [version] <env|all|env1,env2>")
+		}
+		if envName != "" {
+			if err := doRelease(version, envName, opts); err != nil {
+				logFatal("%v", err)
+			}
+		}
+	case "migrate":
+		if len(args) < 2 {
+			logFatal("Usage: deploy migrate <env>")
 		}
-		doRelease(version, envName)
+		doMigrate(args[1])
+	case "rollback":
+		if len(args) < 2 {
+			logFatal("Usage: deploy rollback <env>")
+		}
+		doRollback(args[1])
 	case "maintenance":
-		// Syntax: deploy maintenance <enable|disable> <env>
+		// Syntax: deploy maintenance <enable|disable|status> <env>
 		if len(args) < 3 {
-			logFatal("Usage: deploy maintenance <enable|disable> <env>")
+			logFatal("Usage: deploy maintenance <enable [--until 30m] [--stop-app [--drain 10s]]|disable|status> <env>")
 		}
 		action := args[1]
-		envName := args[2]
+		maintCmd := flag.NewFlagSet("maintenance", flag.ExitOnError)
+		var opts MaintenanceOptions
+		maintCmd.DurationVar(&opts.Until, "until", 0, "Disable maintenance automatically after this duration (enable only)")
+		maintCmd.BoolVar(&opts.StopApp, "stop-app", false, "Stop the app once the page serves; disable starts it again (enable only)")
+		maintCmd.DurationVar(&opts.Drain, "drain", 10*time.Second, "With --stop-app: wait this long before stopping the app")
+		maintCmd.Parse(args[2:])
+		if maintCmd.NArg() < 1 {
+			logFatal("Usage: deploy maintenance <enable [--until 30m] [--stop-app [--drain 10s]]|disable|status> <env>")
+		}
+		envName := maintCmd.Arg(0)
+		if opts.Until < 0 || (opts.Until > 0 && action != "enable") {
+			logFatal("--until takes a positive duration and only applies to 'maintenance enable'")
+		}
+		if opts.StopApp && action != "enable" {
+			logFatal("--stop-app only applies to 'maintenance enable'")
+		}
+		if opts.Drain < 0 {
+			logFatal("--drain must not be negative")
+		}
 
 		if action == "enable" {
-			doMaintenanceEnable(envName)
+			doMaintenanceEnable(envName, opts)
 		} else if action == "disable" {
 			doMaintenanceDisable(envName)
+		} else if action == "status" {
+			doMaintenanceStatus(envName)
 		} else {
-			logFatal("Invalid maintenance action '%s'. Use 'enable' or 'disable'.", action)
+			logFatal("Invalid maintenance action '%s'. Use 'enable', 'disable' or 'status'.", action)
 		}
 	case "server":
 		if len(args) < 2 {
-			logFatal("Usage: deploy server <init|provision>")
+			logFatal("Usage: deploy server <init|provision|status>")
 		}
 		switch args[1] {
 		case "init":
 			doServerInit()
 		case "provision":
 			doServerProvision()
+		case "status":
+			doServerStatus()
 		default:
 			logFatal("Invalid server command: %s", args[1])
 		}
 	case "logs":
 		logsCmd := flag.NewFlagSet("logs", flag.ExitOnError)
-		usePodman := logsCmd.Bool("podman", false, "Stream 'podman logs'")
+		var opts LogOptions
+		logsCmd.BoolVar(&opts.Podman, "podman", false, "Stream 'podman logs'")
+		logsCmd.IntVar(&opts.Tail, "tail", 0, "Show the last N lines")
+		logsCmd.StringVar(&opts.Since, "since", "", "Show logs since a time (e.g. '1h', '2024-01-01 10:00')")
+		logsCmd.StringVar(&opts.Grep, "grep", "", "Only show lines matching the pattern")
+		logsCmd.BoolVar(&opts.NoFollow, "no-follow", false, "Print and exit instead of following")
+		logsCmd.BoolVar(&opts.All, "all", false, "Interleave the app and traefik.service journals")
 		logsCmd.Parse(args[1:])
 		if logsCmd.NArg() < 1 {
-			logFatal("Usage: deploy logs [--podman] <env>")
+			logFatal("Usage: deploy logs [--podman] [--all] [--tail N] [--since T] [--grep P] [--no-follow] <env>")
 		}
-		doLogs(logsCmd.Arg(0), *usePodman)
+		if opts.All && opts.Podman {
+			logFatal("--all reads the journal; it cannot be combined with --podman")
+		}
+		doLogs(logsCmd.Arg(0), opts)
+	case "shell":
+		if len(args) < 2 {
+			logFatal("Usage: deploy shell <env>")
+		}
+		doShell(args[1])
 	case "status":
-		env := ""
-		if len(args) > 1 {
-			env = args[1]
+		statusCmd := flag.NewFlagSet("status", flag.ExitOnError)
+		jsonOut := statusCmd.Bool("json", false, "Emit machine-readable JSON (exit 1 if any service is down)")
+		watch := statusCmd.Bool("watch", false, "Refresh continuously until Ctrl+C")
+		interval := statusCmd.Duration("interval", 5*time.Second, "Refresh interval for --watch")
+		statusCmd.Parse(args[1:])
+		if *watch {
+			doStatusWatch(statusCmd.Arg(0), *jsonOut, *interval)
+		} else {
+			doStatus(statusCmd.Arg(0), *jsonOut)
 		}
-		doStatus(env)
-	case "system-stats":
+	case "system-stats", "stats":
 		// Alias for backward compatibility or explicit single env use
-		if len(args) < 2 {
-			logFatal("Usage: deploy system-stats <env>")
+		statsCmd := flag.NewFlagSet("system-stats", flag.ExitOnError)
+		all := statsCmd.Bool("all", false, "One-line summary of every environment")
+		statsCmd.Parse(args[1:])
+		switch {
+		case *all:
+			doSystemStatsAll()
+		case statsCmd.NArg() == 1:
+			doSystemStats(statsCmd.Arg(0))
+		default:
+			logFatal("Usage: deploy system-stats <env> | deploy stats --all")
 		}
-		doSystemStats(args[1])
 	case "system-updates":
 		// Syntax: deploy system-updates <status|enable|disable> <env>
 		if len(args) < 3 {
@@ -104,10 +239,17 @@ func main() {
 		}
 		doServiceAction(args[1], "start")
 	case "restart":
-		if len(args) < 2 {
-			logFatal("Usage: deploy restart <env>")
+		restartCmd := flag.NewFlagSet("restart", flag.ExitOnError)
+		rolling := restartCmd.Bool("rolling", false, "Keep a transient instance serving while the service restarts")
+		restartCmd.Parse(args[1:])
+		if restartCmd.NArg() < 1 {
+			logFatal("Usage: deploy restart [--rolling] <env>")
+		}
+		if *rolling {
+			doRollingRestart(restartCmd.Arg(0))
+		} else {
+			doServiceAction(restartCmd.Arg(0), "restart")
 		}
-		doServiceAction(args[1], "restart")
 	case "enable":
 		if len(args) < 2 {
 			logFatal("Usage: deploy enable <env>")
@@ -120,13 +262,36 @@ func main() {
 		doServiceAction(args[1], "disable")
 	case "db":
 		if len(args) < 3 {
-			logFatal("Usage: deploy db <pull|push> <env>")
+			logFatal("Usage: deploy db <pull|push|diff|backup|restore> <env>")
 		}
-		if args[1] == "pull" {
-			doDBPull(args[2])
-		} else if args[1] == "push" {
+		switch args[1] {
+		case "pull":
+			pullCmd := flag.NewFlagSet("db pull", flag.ExitOnError)
+			var opts PullOptions
+			pullCmd.StringVar(&opts.Output, "output", "", "Write to this path instead of database.source / dump_file")
+			pullCmd.BoolVar(&opts.NoBackup, "no-backup", false, "Overwrite without asking and without a local .bak copy")
+			pullCmd.BoolVar(&opts.Gzip, "gzip", false, "Compress the transfer (sqlite; needs gzip on the server)")
+			pullCmd.BoolVar(&opts.KeepCompressed, "keep-compressed", false, "With --gzip: save <path>.gz instead of decompressing")
+			pullCmd.Parse(args[2:])
+			if pullCmd.NArg() < 1 {
+				logFatal("Usage: deploy db pull [--output <path>] [--no-backup] [--gzip [--keep-compressed]] <env>")
+			}
+			if opts.KeepCompressed && !opts.Gzip {
+				logFatal("--keep-compressed requires --gzip")
+			}
+			doDBPull(pullCmd.Arg(0), opts)
+		case "push":
 			doDBPush(args[2])
-		} else {
+		case "diff":
+			doDBDiff(args[2])
+		case "backup":
+			doDBBackup(args[2])
+		case "restore":
+			if len(args) < 4 {
+				logFatal("Usage: deploy db restore <env> <backup>")
+			}
+			doDBRestore(args[2], args[3])
+		default:
 			logFatal("Invalid db action: %s", args[1])
 		}
 	case "gen-auth":
@@ -139,11 +304,85 @@ func main() {
 			logFatal("Usage: deploy rights <env> <target>")
 		}
 		doRights(args[1], args[2])
+	case "validate":
+		doValidate()
+	case "cleanup":
+		cleanupCmd := flag.NewFlagSet("cleanup", flag.ExitOnError)
+		force := cleanupCmd.Bool("force", false, "Also close live masters (drops sessions other runs may be using)")
+		cleanupCmd.Parse(args[1:])
+		doCleanup(*force)
+	case "secret":
+		// Syntax: deploy secret set <env> <name> (value from stdin)
+		if len(args) < 4 || args[1] != "set" {
+			logFatal("Usage: deploy secret set <env> <name>  (value via stdin or hidden prompt)")
+		}
+		doPodmanSecretSet(args[2], args[3])
+	case "secrets":
+		// Syntax: deploy secrets <edit|push> <env>
+		if len(args) < 3 {
+			logFatal("Usage: deploy secrets <edit|push> <env>")
+		}
+		switch args[1] {
+		case "edit":
+			doSecretsEdit(args[2])
+		case "push":
+			doSecretsPush(args[2])
+		default:
+			logFatal("Invalid secrets action '%s'. Use 'edit' or 'push'.", args[1])
+		}
+	case "doctor":
+		if len(args) < 2 {
+			logFatal("Usage: deploy doctor <env>")
+		}
+		doDoctor(args[1])
+	case "history":
+		historyCmd := flag.NewFlagSet("history", flag.ExitOnError)
+		limit := historyCmd.Int("n", 20, "Number of entries to show")
+		historyCmd.Parse(args[1:])
+		if historyCmd.NArg() < 1 {
+			logFatal("Usage: deploy history [-n 20] <env>")
+		}
+		doHistory(historyCmd.Arg(0), *limit)
+	case "cp":
+		// Syntax: deploy cp <src> <dst>, one side prefixed with <env>:
+		if len(args) < 3 {
+			logFatal("Usage: deploy cp <env:path|local> <env:path|local>")
+		}
+		doCopy(args[1], args[2])
+	case "ps":
+		if len(args) < 2 {
+			logFatal("Usage: deploy ps <env>")
+		}
+		doPs(args[1])
+	case "env":
+		if len(args) < 2 {
+			logFatal("Usage: deploy env <env>")
+		}
+		doEnv(args[1])
+	case "images":
+		if len(args) < 2 {
+			logFatal("Usage: deploy images <env>")
+		}
+		doImages(args[1])
 	case "prune":
+		pruneCmd := flag.NewFlagSet("prune", flag.ExitOnError)
+		var opts PruneOptions
+		pruneCmd.BoolVar(&opts.All, "all", false, "Also remove unused tagged images (keeps the last keep_images versions)")
+		pruneCmd.BoolVar(&opts.Volumes, "volumes", false, "Also remove unused volumes (asks for confirmation)")
+		pruneCmd.BoolVar(&opts.System, "system", false, "Also run 'podman system prune'")
+		pruneCmd.Parse(args[1:])
+		if pruneCmd.NArg() < 1 {
+			logFatal("Usage: deploy prune [--all] [--volumes] [--system] <env>")
+		}
+		doPrune(pruneCmd.Arg(0), opts)
+	case "completion":
 		if len(args) < 2 {
-			logFatal("Usage: deploy prune <env>")
+			logFatal("Usage: deploy completion <bash|zsh|fish>")
 		}
-		doPrune(args[1])
+		doCompletion(args[1])
+	case "__complete-envs":
+		// Hidden: used by the completion scripts
+		doCompleteEnvs()
 	default:
 		printUsage()
 		os.Exit(1)
@@ -152,22 +391,61 @@ func main() {
 
 func printUsage() {
 	fmt.Println("Usage: deploy <command> [args]")
+	fmt.Println("Global flags: --dry-run, -v, --stream (live build output), --yes/-y (auto-confirm prompts), -c/--config <deploy.yaml>, --server-config <server.yaml>,")
+	fmt.Println("              --bwlimit <KB/s>, --progress (rsync), --timeout <15m> (release: abort and roll back),")
+	fmt.Println("              --no-color (automatic without a TTY), --log-json (one JSON object per log line),")
+	fmt.Println("              --log-file <path> (append uncolored log output), --no-multiplex (no shared ssh connection)")
 	fmt.Println("Commands:")
-	fmt.Println("  init                     Generate deploy.yaml")
+	fmt.Println("  version                  Print the version of this deploy binary")
+	fmt.Println("  init                     Generate deploy.yaml (--force overwrites it)")
+	fmt.Println("                           --app, --user, --host, --binary: set fields instead of detecting them")
 	fmt.Println("  release [tag] <env>      Deploy to env. If tag omitted, auto-detects or prompts.")
-	fmt.Println("  status [env]             Show detailed system health. If env omitted, shows all.")
-	fmt.Println("  maintenance <ac> <env>   Manage maintenance page (ac: enable|disable)")
+	fmt.Println("                           <env> may be 'all' or a list (staging,prod); --parallel N")
+	fmt.Println("                           --force-unlock: clear a stale remote deploy lock")
+	fmt.Println("                           --no-rollback: keep a failed deploy for debugging")
+	fmt.Println("                           --skip-build: reuse build/<binary>; --skip-migrate: do not run migrate.cmd")
+	fmt.Println("                           --if-changed: skip envs already running the current commit")
+	fmt.Println("                           --env-file <path>: sync this .env instead of sync_env_file/secrets")
+	fmt.Println("                           --force-dirty: release uncommitted changes as <version>-dirty")
+	fmt.Println("  release --tag-only [tag] Create/validate and push the tag without deploying; prints the version")
+	fmt.Println("  migrate <env>            Run migrate.cmd in a one-off container of the current image")
+	fmt.Println("  rollback <env>           Restore the previous binary and restart")
+	fmt.Println("  status [--json] [env]    Show detailed system health. If env omitted, shows all.")
+	fmt.Println("                           --watch [--interval 5s]: refresh until Ctrl+C")
+	fmt.Println("  stats --all              One line per env: load, mem, disk, service, pending updates")
+	fmt.Println("  maintenance <ac> <env>   Manage maintenance page (ac: enable|disable|status)")
+	fmt.Println("                           enable --until 30m: turn it off again automatically")
+	fmt.Println("                           enable --stop-app [--drain 10s]: stop the app behind it (disable restarts it)")
 	fmt.Println("  system-updates <ac> <env> Manage unattended upgrades (status|enable|disable)")
 	fmt.Println("  start <env>              Start service")
 	fmt.Println("  stop <env>               Stop service")
-	fmt.Println("  restart <env>            Restart service")
+	fmt.Println("  restart <env>            Restart service (--rolling: keep a transient instance serving)")
 	fmt.Println("  enable <env>             Enable service at boot")
 	fmt.Println("  disable <env>            Disable service at boot")
+	fmt.Println("  validate                 Check deploy.yaml for common mistakes")
+	fmt.Println("  cleanup [--force]        Remove stale ssh control sockets of deploy.yaml's envs (--force: close live ones too)")
+	fmt.Println("  secrets <ac> <env>       age-encrypted .env (ac: edit|push); release syncs it like sync_env_file")
+	fmt.Println("  secret set <env> <name>  Create/replace a podman secret (value from stdin) for quadlet.secrets")
+	fmt.Println("  doctor <env>             Preflight checklist (ssh, tools, linger, perms, network)")
+	fmt.Println("  history [-n 20] <env>    Who deployed what, when (last 100 kept on the server)")
+	fmt.Println("  completion <shell>       Print a bash|zsh|fish completion script (e.g. source <(deploy completion bash))")
+	fmt.Println("  cp <src> <dst>           Copy to/from the server; prefix the remote side with env: (./ = target_dir)")
+	fmt.Println("  ps <env>                 Show the service container (status, size, created)")
+	fmt.Println("  images <env>             List the app's images on the server (size, created)")
+	fmt.Println("  env <env>                Print the resolved env config as YAML (secrets masked)")
 	fmt.Println("  prune <env>              Clean up unused images/builder cache")
-	fmt.Println("  server <init|provision>  Manage Server Infrastructure (Traefik/Auth)")
-	fmt.Println("  logs <env>               Stream logs")
+	fmt.Println("                           --all: unused tagged images too; --volumes; --system (podman system prune)")
+	fmt.Println("  server <init|provision|status>")
+	fmt.Println("                           Manage Server Infrastructure (Traefik/Auth)")
+	fmt.Println("  logs <env>               Stream logs (--podman, --tail N, --since T, --grep P, --no-follow, --all: + traefik)")
+	fmt.Println("  shell <env>              Open an interactive shell in target_dir")
 	fmt.Println("  db pull <env>            Sync DB (Remote -> Local)")
+	fmt.Println("                           --output <path>: write elsewhere; --no-backup: no prompt, no .bak")
+	fmt.Println("                           --gzip: compress the transfer; --keep-compressed: save the .gz")
 	fmt.Println("  db push <env>            Overwrite Remote DB (Service MUST be stopped first)")
+	fmt.Println("  db diff <env>            Compare per-table row counts of local and remote DB (sqlite)")
+	fmt.Println("  db backup <env>          Snapshot DB into <target_dir>/backups on the server")
+	fmt.Println("  db restore <env> <file>  Restore a server-side snapshot (Service MUST be stopped first)")
 	fmt.Println("  gen-auth <u?> <p?>       Generate Basic Auth string")
 	fmt.Println("  rights <env> <target>    Manual permission fix (target: 'user' or 'container')")
 }
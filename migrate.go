@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// migrateCommand runs env.Migrate.Cmd in a throwaway container of image with the
+// app's volumes, network and environment, so migrations see the same data as the service.
+func migrateCommand(env Environment, image string) string {
+	args := []string{"podman", "run", "--rm"}
+	if env.Quadlet.Network != "" {
+		args = append(args, "--network", env.Quadlet.Network)
+	}
+	for _, v := range absVolumes(env) {
+		args = append(args, "-v", shellQuote(v))
+	}
+	for _, e := range env.Quadlet.EnvVars {
+		args = append(args, "-e", shellQuote(e))
+	}
+	envFile := fmt.Sprintf("%s/.env", strings.TrimRight(env.Dir, "/"))
+	// The .env is optional, as for the quadlet
+	args = append(args, fmt.Sprintf("$( [ -f %s ] && echo --env-file %s )", envFile, envFile))
+	args = append(args, image, env.Migrate.Cmd)
+	return strings.Join(args, " ")
+}
+
+// doMigrate runs the migrations against the currently deployed image.
+func doMigrate(envName string) {
+	cfg, env := loadEnv(envName)
+	if env.Migrate.Cmd == "" {
+		logFatal("No migrate.cmd configured for %s", envName)
+	}
+	image := env.Quadlet.Image
+	if env.Quadlet.PullImage {
+		// Registry images are only tagged by version; use the one recorded at deploy time
+		image = registryImage(cfg.Build.Registry, cfg.AppName, fmt.Sprintf("$(cat %s/%s)", env.Dir, versionMarkerFile))
+	}
+	logInfo("🗃️  Running migrations on %s (%s)...", envName, env.Host)
+	if err := runSSHStream(env, fmt.Sprintf("cd %s && %s", env.Dir, migrateCommand(env, image))); err != nil {
+		logFatal("Migration failed: %v", err)
+	}
+	logSuccess("✅ Migrations complete.")
+}
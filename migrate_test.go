@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMigrateCommand(t *testing.T) {
+	env := Environment{
+		Dir: "/app",
+		Quadlet: Quadlet{
+			Network: "traefik-net",
+			Volumes: []string{"./data:/data:Z"},
+			EnvVars: []string{"APP_ENV=production"},
+		},
+		Migrate: MigrateConfig{Cmd: "/app-server migrate up"},
+	}
+	got := migrateCommand(env, "localhost/app:v1.2.3")
+
+	for _, want := range []string{
+		"podman run --rm --network traefik-net",
+		"-v '/app/data:/data:Z'",
+		"-e 'APP_ENV=production'",
+		"--env-file /app/.env",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected %q in %s", want, got)
+		}
+	}
+	if !strings.HasSuffix(got, "localhost/app:v1.2.3 /app-server migrate up") {
+		t.Errorf("Expected image and cmd at the end, got %s", got)
+	}
+}
+
+func TestActivationScriptRunsMigrationBeforeRestart(t *testing.T) {
+	env := Environment{Dir: "/app", Quadlet: Quadlet{ServiceName: "app", Image: "localhost/app:latest"}, Migrate: MigrateConfig{Cmd: "/app-server migrate up"}}
+	script := activationScript(env, "v1.0.0", "abc123", "localhost/app:v1.0.0", "Dockerfile")
+
+	migrateIdx := strings.Index(script, "migrate up")
+	restartIdx := strings.Index(script, "systemctl --user restart app.service")
+	if migrateIdx == -1 || restartIdx == -1 || migrateIdx > restartIdx {
+		t.Errorf("Expected migration before restart, got:\n%s", script)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"text/template"
+	"time"
+)
+
+// notifyTimeout bounds a webhook call, so a hanging endpoint cannot stall the release.
+var notifyTimeout = 10 * time.Second
+
+const defaultNotifyTmpl = "{{ .App }} {{ .Version }} -> {{ .Env }}: {{ .Status }}"
+
+type NotifyPayload struct {
+	App     string `json:"app"`
+	Env     string `json:"env"`
+	Version string `json:"version"`
+	Status  string `json:"status"` // "success", "rolled_back" or "failed" (--no-rollback)
+	Commit  string `json:"commit"`
+	Message string `json:"message"`
+}
+
+// sendNotification POSTs the payload to the configured webhook.
+// Failures only warn: a broken webhook must never abort a deploy.
+func sendNotification(cfg NotifyConfig, p NotifyPayload) {
+	if cfg.WebhookURL == "" {
+		return
+	}
+	if dryRun {
+		logDebug("[DRY] POST %s (%s)", cfg.WebhookURL, p.Status)
+		return
+	}
+	if err := postWebhook(cfg, p); err != nil {
+		logWarn("Notification failed: %v", err)
+	}
+}
+
+func postWebhook(cfg NotifyConfig, p NotifyPayload) error {
+	tmplStr := cfg.Template
+	if tmplStr == "" {
+		tmplStr = defaultNotifyTmpl
+	}
+	tmpl, err := template.New("notify").Parse(tmplStr)
+	if err != nil {
+		return fmt.Errorf("template error: %w", err)
+	}
+	var msg bytes.Buffer
+	if err := tmpl.Execute(&msg, p); err != nil {
+		return fmt.Errorf("template exec: %w", err)
+	}
+	p.Message = msg.String()
+
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: notifyTimeout}
+	resp, err := client.Post(cfg.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPostWebhook(t *testing.T) {
+	var got NotifyPayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Failed to decode payload: %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := NotifyConfig{WebhookURL: srv.URL, Template: "{{ .App }}@{{ .Version }} {{ .Status }}"}
+	p := NotifyPayload{App: "app", Env: "prod", Version: "v1.0.0", Status: "success", Commit: "abc123"}
+	if err := postWebhook(cfg, p); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if got.Message != "app@v1.0.0 success" {
+		t.Errorf("Expected message 'app@v1.0.0 success', got '%s'", got.Message)
+	}
+	if got.Commit != "abc123" || got.Env != "prod" {
+		t.Errorf("Unexpected payload: %+v", got)
+	}
+}
+
+func TestPostWebhookTimeout(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done // Hang until the test ends
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	old := notifyTimeout
+	notifyTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { notifyTimeout = old })
+
+	if err := postWebhook(NotifyConfig{WebhookURL: srv.URL}, NotifyPayload{App: "app"}); err == nil {
+		t.Errorf("Expected a hanging webhook to time out")
+	}
+}
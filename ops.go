@@ -1,48 +1,285 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
-	"os/exec"
+	"os/signal"
 	"os/user"
+	"path"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"text/template"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
-func doStatus(envName string) {
+// EnvStatus is the machine-readable status of one environment ('deploy status --json').
+type EnvStatus struct {
+	Env              string `json:"env"`
+	Host             string `json:"host"`
+	ServiceActive    bool   `json:"service_active"`
+	ContainerRunning bool   `json:"container_running"`
+	Maintenance      bool   `json:"maintenance"` // <service>-maint is serving the maintenance page
+	Version          string `json:"version"`
+	DiskPercent      int    `json:"disk_percent"`
+	Error            string `json:"error,omitempty"`
+}
+
+func doStatus(envName string, jsonOut bool) {
+	if !printStatus(envName, jsonOut) && jsonOut {
+		os.Exit(1)
+	}
+}
+
+// doStatusWatch re-renders the status every interval until Ctrl+C.
+// Iterations reuse the multiplexed SSH master (ControlPersist), so refreshes stay fast.
+func doStatusWatch(envName string, jsonOut bool, interval time.Duration) {
+	if interval <= 0 {
+		logFatal("--interval must be positive")
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		fmt.Print("\033[H\033[2J") // Clear screen, cursor home
+		fmt.Printf("%sEvery %s: deploy status %s  (%s, Ctrl+C to exit)%s\n", Gray, interval, envName, time.Now().Format("15:04:05"), Reset)
+		printStatus(envName, jsonOut)
+
+		select {
+		case <-sigs:
+			fmt.Print(Reset + "\n")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// printStatus prints the status of envName (or all envs) and reports whether every service is up.
+func printStatus(envName string, jsonOut bool) bool {
+	var keys []string
+	if envName != "" {
+		keys = []string{envName}
+	} else {
+		// All envs status
+		cfg := loadConfig()
+		if len(cfg.Environments) == 0 {
+			logWarn("No environments defined in deploy.yaml")
+			return true
+		}
+		keys = sortedEnvNames(cfg)
+	}
+
+	if jsonOut {
+		statuses := make([]EnvStatus, 0, len(keys))
+		healthy := true
+		for _, k := range keys {
+			s := collectEnvStatus(k)
+			if !s.ServiceActive {
+				healthy = false
+			}
+			statuses = append(statuses, s)
+		}
+		out, _ := json.MarshalIndent(statuses, "", "  ")
+		fmt.Println(string(out))
+		return healthy
+	}
+
 	if envName != "" {
 		// Single env status
 		doSystemStats(envName)
-		return
+		return true
 	}
 
-	// All envs status
-	cfg := loadConfig()
-	if len(cfg.Environments) == 0 {
-		logWarn("No environments defined in deploy.yaml")
-		return
+	for _, k := range keys {
+		fmt.Printf("\n------------------------------------------------------------\n")
+		fmt.Printf(" 🌍 ENVIRONMENT: %s\n", k)
+		fmt.Printf("------------------------------------------------------------\n")
+		doSystemStats(k)
 	}
+	return true
+}
 
-	// Sort keys for consistent output order
+// sortedEnvNames returns environment names in a stable order for output.
+func sortedEnvNames(cfg Config) []string {
 	keys := make([]string, 0, len(cfg.Environments))
 	for k := range cfg.Environments {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
+	return keys
+}
 
-	for _, k := range keys {
-		fmt.Printf("\n------------------------------------------------------------\n")
-		fmt.Printf(" 🌍 ENVIRONMENT: %s\n", k)
-		fmt.Printf("------------------------------------------------------------\n")
-		doSystemStats(k)
+// statusScript prints the key=value lines read by parseStatusOutput.
+func statusScript(env Environment) string {
+	containerName := "systemd-" + env.Quadlet.ServiceName
+	return fmt.Sprintf(`
+		echo "service=$(systemctl --user is-active %s.service 2>/dev/null)"
+		echo "maintenance=$(systemctl --user is-active %s-maint.service 2>/dev/null)"
+		if podman ps -q --filter name=%s 2>/dev/null | grep -q .; then echo "container=running"; else echo "container=stopped"; fi
+		echo "version=$(cat %s/%s 2>/dev/null)"
+		echo "disk=$(df -P %s 2>/dev/null | awk 'NR==2 {print $5}')"
+	`, env.Quadlet.ServiceName, env.Quadlet.ServiceName, containerName, env.Dir, versionMarkerFile, env.Dir)
+}
+
+// collectEnvStatus queries the remote host for a compact, parseable status.
+func collectEnvStatus(envName string) EnvStatus {
+	_, env := loadEnv(envName)
+
+	s := EnvStatus{Env: envName, Host: env.Host}
+	out, err := runSSHOutput(env, statusScript(env))
+	if err != nil && out == "" {
+		s.Error = err.Error()
+		s.Version = "unknown"
+		return s
+	}
+	parseStatusOutput(out, &s)
+	return s
+}
+
+// parseStatusOutput fills s from the key=value lines printed by the collectEnvStatus script.
+func parseStatusOutput(out string, s *EnvStatus) {
+	s.Version = "unknown"
+	for _, line := range strings.Split(out, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "service":
+			s.ServiceActive = value == "active"
+		case "container":
+			s.ContainerRunning = value == "running"
+		case "maintenance":
+			s.Maintenance = value == "active"
+		case "version":
+			if value != "" {
+				s.Version = value
+			}
+		case "disk":
+			s.DiskPercent, _ = strconv.Atoi(strings.TrimSuffix(value, "%"))
+		}
 	}
 }
 
+// HostStats is one row of 'deploy system-stats --all'.
+type HostStats struct {
+	EnvStatus
+	Load       string // 1 minute load average
+	MemPercent int
+	Updates    int // Pending package updates, -1 if unknown
+}
+
+// hostStatsScript extends the status script with load, memory and pending updates.
+const hostStatsScript = `
+		echo "load=$(awk '{print $1}' /proc/loadavg)"
+		echo "mem=$(free | awk '/^Mem:/ {printf "%d", $3*100/$2}')"
+		if command -v apt > /dev/null 2>&1; then
+			echo "updates=$(apt list --upgradable 2>/dev/null | grep -vc 'Listing...')"
+		elif command -v dnf > /dev/null 2>&1; then
+			echo "updates=$(dnf check-update -q 2>/dev/null | grep -c .)"
+		elif command -v apk > /dev/null 2>&1; then
+			echo "updates=$(apk version -l '<' 2>/dev/null | grep -vc 'Installed:')"
+		fi
+`
+
+// parseHostStats fills the extra HostStats fields; the status part is left to parseStatusOutput.
+func parseHostStats(out string, s *HostStats) {
+	parseStatusOutput(out, &s.EnvStatus)
+	s.Load, s.Updates = "-", -1
+	for _, line := range strings.Split(out, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok || value == "" {
+			continue
+		}
+		switch key {
+		case "load":
+			s.Load = value
+		case "mem":
+			s.MemPercent, _ = strconv.Atoi(value)
+		case "updates":
+			if n, err := strconv.Atoi(value); err == nil {
+				s.Updates = n
+			}
+		}
+	}
+}
+
+func collectHostStats(envName string) HostStats {
+	_, env := loadEnv(envName)
+	s := HostStats{EnvStatus: EnvStatus{Env: envName, Host: env.Host}}
+	out, err := runSSHOutput(env, statusScript(env)+hostStatsScript)
+	if err != nil && out == "" {
+		s.Error = err.Error()
+		return s
+	}
+	parseHostStats(out, &s)
+	return s
+}
+
+// statsParallel bounds the concurrent SSH sessions of 'system-stats --all'.
+const statsParallel = 8
+
+// doSystemStatsAll prints one line per environment, querying the hosts concurrently.
+// Unreachable hosts are shown as offline instead of aborting the run.
+func doSystemStatsAll() {
+	cfg := loadConfig()
+	names := sortedEnvNames(cfg)
+	if len(names) == 0 {
+		logWarn("No environments defined in deploy.yaml")
+		return
+	}
+	logInfo("📊 Fetching stats from %d environments...", len(names))
+
+	results := make([]HostStats, len(names))
+	sem := make(chan struct{}, statsParallel)
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = collectHostStats(name)
+		}(i, name)
+	}
+	wg.Wait()
+
+	fmt.Printf("\n%-12s %-24s %-6s %-5s %-5s %-8s %s\n", "ENV", "HOST", "LOAD", "MEM", "DISK", "SERVICE", "UPDATES")
+	for _, s := range results {
+		fmt.Println(formatHostStats(s))
+	}
+}
+
+// formatHostStats renders one summary row, colored by service state.
+func formatHostStats(s HostStats) string {
+	if s.Error != "" {
+		return fmt.Sprintf("%-12s %-24s %s", s.Env, s.Host, Red+"offline"+Reset)
+	}
+	service := Green + "up      " + Reset
+	if !s.ServiceActive {
+		service = Red + "down    " + Reset
+	}
+	updates := "?"
+	if s.Updates >= 0 {
+		updates = strconv.Itoa(s.Updates)
+		if s.Updates > 0 {
+			updates = Yellow + updates + Reset
+		}
+	}
+	return fmt.Sprintf("%-12s %-24s %-6s %-5s %-5s %s %s", s.Env, s.Host, s.Load,
+		fmt.Sprintf("%d%%", s.MemPercent), fmt.Sprintf("%d%%", s.DiskPercent), service, updates)
+}
+
 func doSystemStats(envName string) {
 	_, env := loadEnv(envName)
 	logInfo("📊 Fetching sophisticated stats from %s (%s)...", envName, env.Host)
@@ -153,6 +390,13 @@ func doSystemStats(envName string) {
 		else
 			printf "Status:  ${RED}${SYSTEMD_STATUS:-Not Found}${NC}\n"
 		fi
+		# Deployed version marker (missing for deploys made before it existed)
+		DEPLOYED_VERSION=$(cat %s/%s 2>/dev/null)
+		printf "Version: %%s\n" "${DEPLOYED_VERSION:-unknown}"
+		# The page is what visitors see, whatever the app's state
+		if systemctl --user is-active --quiet %s-maint.service 2>/dev/null; then
+			printf "${YELLOW}⚠️  MAINTENANCE MODE ACTIVE${NC} (disable: deploy maintenance disable <env>)\n"
+		fi
 
 		# --- 5. CONTAINER ---
 		echo ""
@@ -165,9 +409,9 @@ func doSystemStats(envName string) {
 			printf "${YELLOW}Container is NOT running.${NC}\n"
 		fi
 
-	`, env.Dir, env.Quadlet.ServiceName, env.Quadlet.ServiceName, env.Quadlet.ServiceName, containerName, containerName)
+	`, env.Dir, env.Quadlet.ServiceName, env.Quadlet.ServiceName, env.Quadlet.ServiceName, env.Dir, versionMarkerFile, env.Quadlet.ServiceName, containerName, containerName)
 
-	c := exec.Command("ssh", append(getSSHBaseArgs(env), script)...)
+	c := sshCommand(context.Background(), env, script)
 	c.Stdout = os.Stdout
 	c.Stderr = os.Stderr
 	if err := c.Run(); err != nil {
@@ -237,21 +481,258 @@ func doGenAuth(user, password string) {
 	fmt.Printf("%s:%s\n", user, string(hash))
 }
 
-func doPrune(envName string) {
+// PruneOptions holds the flags of 'deploy prune'. The default prunes dangling images,
+// old versioned tags and the build cache.
+type PruneOptions struct {
+	All     bool // Also remove unused tagged images (keeps the last keep_images versions)
+	Volumes bool // Also remove unused volumes (asks again, data loss risk)
+	System  bool // Also run 'podman system prune'
+}
+
+// pruneAllScript removes every tagged image no container uses, except :latest and the
+// newest keep versioned tags of repo. Like 'podman image prune -a', minus the rollback targets.
+func pruneAllScript(repo string, keep int) string {
+	keepList := "true"
+	if repo != "" {
+		keepList = fmt.Sprintf("podman images --sort created --format '{{.Repository}}:{{.Tag}}' --filter reference=%s | grep -v ':<none>$' | grep -v ':latest$' | head -n %d", repo, keep)
+	}
+	return fmt.Sprintf(`keep=$( { %s; echo %s:latest; } ); podman images --format '{{.Repository}}:{{.Tag}}' | grep -v ':<none>$' | grep -vxF -e "$keep" | xargs -r -n1 podman rmi 2>/dev/null || true`,
+		keepList, repo)
+}
+
+// pruneSummary extracts podman's "Total reclaimed space: X" line, falling back to the
+// number of removed items for commands that do not print it (one ID/name or 'Untagged:' line each).
+func pruneSummary(out string) string {
+	var removed int
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if v, ok := strings.CutPrefix(line, "Total reclaimed space:"); ok {
+			return strings.TrimSpace(v) + " reclaimed"
+		}
+		if line != "" && !strings.HasPrefix(line, "Deleted") {
+			removed++
+		}
+	}
+	return fmt.Sprintf("%d removed", removed)
+}
+
+// sizeUnits covers podman's human sizes: decimal (kB, MB) and binary (KiB, MiB).
+var sizeUnits = map[string]float64{
+	"B": 1, "KB": 1e3, "MB": 1e6, "GB": 1e9, "TB": 1e12,
+	"KIB": 1 << 10, "MIB": 1 << 20, "GIB": 1 << 30, "TIB": 1 << 40,
+}
+
+// parseSize converts a podman size like "1.2GB", "512kB" or "0B" to bytes.
+func parseSize(s string) (int64, bool) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i <= 0 {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	mult, ok := sizeUnits[strings.ToUpper(strings.TrimSpace(s[i:]))]
+	if err != nil || !ok {
+		return 0, false
+	}
+	return int64(n * mult), true
+}
+
+// formatSize renders bytes the way podman does (decimal units).
+func formatSize(b int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	f, i := float64(b), 0
+	for f >= 1000 && i < len(units)-1 {
+		f /= 1000
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%dB", b)
+	}
+	return fmt.Sprintf("%.3g%s", f, units[i])
+}
+
+// reclaimedBytes sums the "Total reclaimed space" lines of a prune's output.
+func reclaimedBytes(out string) int64 {
+	var total int64
+	for _, line := range strings.Split(out, "\n") {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(line), "Total reclaimed space:"); ok {
+			n, _ := parseSize(v)
+			total += n
+		}
+	}
+	return total
+}
+
+// storageAvailKB returns the free KB of the filesystem holding podman's image store.
+func storageAvailKB(env Environment) (int64, bool) {
+	out, err := runSSHOutput(env, `df -Pk "$(podman info --format '{{.Store.GraphRoot}}')" | awk 'NR==2 {print $4}'`)
+	if err != nil {
+		return 0, false
+	}
+	kb, err := strconv.ParseInt(out, 10, 64)
+	return kb, err == nil
+}
+
+// pruneStep runs a prune command, reports what it freed and returns the bytes podman
+// says it reclaimed (0 for commands that do not report it).
+func pruneStep(env Environment, label, cmd string) int64 {
+	logInfo("   - %s...", label)
+	out, err := runSSHCapture(env, cmd)
+	if err != nil {
+		logWarn("%s warning: %v", label, err)
+		return 0
+	}
+	if !dryRun {
+		logInfo("     %s", pruneSummary(out))
+	}
+	return reclaimedBytes(out)
+}
+
+// pruneResult formats the final prune line. The df delta is the real number; podman's
+// total only counts what it reports, and both can be zero.
+func pruneResult(reported, freedBytes int64, haveDF bool) string {
+	switch {
+	case haveDF && freedBytes > 0:
+		return fmt.Sprintf("✅ Prune complete: %s freed on disk (podman reported %s).", formatSize(freedBytes), formatSize(reported))
+	case reported > 0:
+		return fmt.Sprintf("✅ Prune complete: podman reclaimed %s.", formatSize(reported))
+	default:
+		return "✅ Prune complete, nothing to reclaim (0B)."
+	}
+}
+
+func doPrune(envName string, opts PruneOptions) {
 	_, env := loadEnv(envName)
 	logInfo("🧹 Pruning unused resources on %s (%s)...", envName, env.Host)
+	availBefore, haveDF := storageAvailKB(env)
+
+	var reported int64
+	reported += pruneStep(env, "Pruning dangling images", "podman image prune -f")
+
+	if env.Quadlet.Image != "" {
+		repo := imageRepo(env.Quadlet.Image)
+		logInfo("   - Removing versioned images of %s beyond the last %d...", repo, env.KeepImages)
+		// Newest first; ':latest' is never counted. Images still in use by a container are skipped by rmi.
+		script := fmt.Sprintf(
+			"podman images --sort created --format '{{.Repository}}:{{.Tag}}' --filter reference=%s | grep -v -e ':latest$' -e ':<none>$' | tail -n +%d | xargs -r podman rmi || true",
+			repo, env.KeepImages+1)
+		if err := runSSH(env, script); err != nil {
+			logWarn("Versioned image prune warning: %v", err)
+		}
+	}
+
+	reported += pruneStep(env, "Pruning build cache", "podman builder prune -f")
+
+	if opts.All {
+		repo := ""
+		if env.Quadlet.Image != "" {
+			repo = imageRepo(env.Quadlet.Image)
+		}
+		reported += pruneStep(env, "Removing all unused tagged images (--all)", pruneAllScript(repo, env.KeepImages))
+	}
+	if opts.Volumes {
+		if confirm(fmt.Sprintf("⚠️  Delete ALL unused podman volumes on %s? Data in them is lost.", env.Host)) {
+			reported += pruneStep(env, "Pruning unused volumes (--volumes)", "podman volume prune -f")
+		} else {
+			logInfo("   - Skipping volume prune.")
+		}
+	}
+	if opts.System {
+		reported += pruneStep(env, "Running podman system prune (--system)", "podman system prune -f")
+	}
+
+	if dryRun {
+		logSuccess("✅ Prune complete.")
+		return
+	}
+	var freed int64
+	if availAfter, ok := storageAvailKB(env); ok && haveDF {
+		freed = (availAfter - availBefore) * 1024
+	} else {
+		haveDF = false
+	}
+	logSuccess("%s", pruneResult(reported, freed, haveDF))
+}
+
+// doPs lists the env's service container, including its size and age.
+func doPs(envName string) {
+	_, env := loadEnv(envName)
+	logInfo("📋 Containers for %s on %s...", env.Quadlet.ServiceName, env.Host)
+	format := "table {{.Names}}\t{{.Image}}\t{{.Status}}\t{{.Size}}\t{{.CreatedHuman}}"
+	cmd := fmt.Sprintf("podman ps -a --size --filter name=systemd-%s --format %s", env.Quadlet.ServiceName, shellQuote(format))
+	if err := runSSHStream(env, cmd); err != nil {
+		logFatal("podman ps failed: %v", err)
+	}
+}
 
-	logInfo("   - Pruning dangling images...")
-	if err := runSSH(env, "podman image prune -f"); err != nil {
-		logWarn("Image prune warning: %v", err)
+// doImages lists local images of the env's image repository, to spot bloat before 'prune'.
+func doImages(envName string) {
+	_, env := loadEnv(envName)
+	if env.Quadlet.Image == "" {
+		logFatal("No quadlet.image configured for %s", envName)
+	}
+	repo := imageRepo(env.Quadlet.Image)
+	logInfo("🖼️  Images of %s on %s...", repo, env.Host)
+	format := "table {{.Repository}}\t{{.Tag}}\t{{.ID}}\t{{.Size}}\t{{.Created}}"
+	cmd := fmt.Sprintf("podman images --sort created --filter reference=%s --format %s", shellQuote(repo), shellQuote(format))
+	if err := runSSHStream(env, cmd); err != nil {
+		logFatal("podman images failed: %v", err)
+	}
+}
+
+// splitCopyArg splits "env:path" when env is a configured environment.
+func splitCopyArg(cfg Config, arg string) (envName, path string, remote bool) {
+	name, p, ok := strings.Cut(arg, ":")
+	if !ok {
+		return "", arg, false
+	}
+	if _, known := cfg.Environments[name]; !known {
+		return "", arg, false
+	}
+	return name, p, true
+}
+
+// resolveRemotePath anchors "./" paths (and an empty path) at target_dir.
+func resolveRemotePath(env Environment, p string) string {
+	dir := strings.TrimRight(env.Dir, "/")
+	switch {
+	case p == "" || p == ".":
+		return dir + "/"
+	case strings.HasPrefix(p, "./"):
+		return dir + "/" + strings.TrimPrefix(p, "./")
+	}
+	return p
+}
+
+// doCopy copies a file or directory to or from the server over the multiplexed rsync connection.
+func doCopy(src, dst string) {
+	cfg := loadConfig()
+	srcEnv, srcPath, srcRemote := splitCopyArg(cfg, src)
+	dstEnv, dstPath, dstRemote := splitCopyArg(cfg, dst)
+	if srcRemote == dstRemote {
+		logFatal("Exactly one of <src> and <dst> must be remote (env:path), e.g. 'deploy cp prod:./data/app.log ./'")
 	}
 
-	logInfo("   - Pruning build cache...")
-	if err := runSSH(env, "podman builder prune -f"); err != nil {
-		logWarn("Builder prune warning: %v", err)
+	envName := srcEnv
+	if dstRemote {
+		envName = dstEnv
+	}
+	_, env := loadEnv(envName)
+	remote := func(p string) string {
+		return remotePath(env, resolveRemotePath(env, p))
 	}
 
-	logSuccess("✅ Prune complete.")
+	if dstRemote {
+		logInfo("📤 Copying %s to %s:%s...", srcPath, envName, resolveRemotePath(env, dstPath))
+		dstPath = remote(dstPath)
+	} else {
+		logInfo("📥 Copying %s:%s to %s...", envName, resolveRemotePath(env, srcPath), dstPath)
+		srcPath = remote(srcPath)
+	}
+	if err := runRsyncSafe(env, []string{srcPath}, dstPath); err != nil {
+		logFatal("Copy failed: %v", err)
+	}
+	logSuccess("✅ Copied.")
 }
 
 func doRights(envName, target string) {
@@ -281,34 +762,169 @@ func doRights(envName, target string) {
 	logSuccess("Permissions updated.")
 }
 
-func changeOwnership(env Environment, uid, gid string) {
+// doCleanup removes the stale control sockets of the envs in deploy.yaml, e.g. after a
+// killed deploy left one behind ("mux_client_request_session: session request failed").
+// Live masters are kept unless force is set, since another run may be using them.
+func doCleanup(force bool) {
+	cfg := loadConfig()
+	seen := map[string]bool{}
+	removed := 0
+	for _, name := range sortedEnvNames(cfg) {
+		env, err := resolveEnv(cfg, name)
+		if err != nil {
+			continue
+		}
+		socket := controlPath(env)
+		if seen[socket] {
+			continue
+		}
+		seen[socket] = true
+		if _, err := os.Lstat(socket); err != nil {
+			continue
+		}
+		if dryRun {
+			logDebug("[DRY] ssh -O check -o ControlPath=%s (removed if stale)", socket)
+			continue
+		}
+		switch cleanupControlSocket(socket, force) {
+		case "stale":
+			logInfo("🧹 %s@%s: removed stale socket %s", env.User, env.Host, socket)
+			removed++
+		case "closed":
+			logInfo("🧹 %s@%s: closed live master (--force)", env.User, env.Host)
+			removed++
+		case "kept":
+			logInfo("🔗 %s@%s: master is alive, kept (--force closes it)", env.User, env.Host)
+		}
+	}
+	logSuccess("Cleaned up %d ssh control socket(s).", removed)
+}
+
+// chownPaths expands chown_volumes: "./x" is relative to target_dir, absolute paths
+// are kept; both are cleaned so "./../x" shows where it really points.
+func chownPaths(env Environment) []string {
 	var paths []string
 	for _, p := range env.Quadlet.ChownVolumes {
 		if strings.HasPrefix(p, "./") {
-			p = fmt.Sprintf("%s/%s", strings.TrimRight(env.Dir, "/"), strings.TrimPrefix(p, "./"))
+			p = strings.TrimRight(env.Dir, "/") + "/" + strings.TrimPrefix(p, "./")
 		}
-		paths = append(paths, p)
+		paths = append(paths, path.Clean(p))
 	}
+	return paths
+}
+
+// pathsOutsideDir returns the paths that are neither dir nor below it.
+func pathsOutsideDir(dir string, paths []string) []string {
+	dir = path.Clean(dir)
+	var outside []string
+	for _, p := range paths {
+		if p != dir && !strings.HasPrefix(p, strings.TrimRight(dir, "/")+"/") {
+			outside = append(outside, p)
+		}
+	}
+	return outside
+}
+
+func changeOwnership(env Environment, uid, gid string) {
+	paths := chownPaths(env)
 	if len(paths) == 0 {
 		return
 	}
 
+	for _, p := range paths {
+		logInfo("   %s", p)
+	}
+	// A recursive chown on a wrong path (e.g. "/") is not undoable
+	if outside := pathsOutsideDir(env.Dir, paths); len(outside) > 0 {
+		logWarn("⚠️  Outside target_dir (%s): %s", env.Dir, strings.Join(outside, " "))
+		if !confirm("Recursively chown these paths anyway?") {
+			logFatal("Aborted, nothing changed.")
+		}
+	}
+
 	cmd := fmt.Sprintf("podman unshare chown -R %s:%s %s", uid, gid, strings.Join(paths, " "))
-	runSSH(env, cmd)
+	if err := runSSH(env, cmd); err != nil {
+		logFatal("chown failed: %v", err)
+	}
+}
+
+// LogOptions are the 'deploy logs' filters, mapped onto journalctl or podman logs flags.
+type LogOptions struct {
+	Podman   bool
+	Tail     int    // Last N lines (0 = tool default)
+	Since    string // e.g. "1h", "2024-01-01 10:00"
+	Grep     string
+	NoFollow bool
+	All      bool // Interleave traefik.service with the app (journalctl only)
+}
+
+// logsCommand builds the remote log command for service.
+func logsCommand(service string, opts LogOptions) string {
+	var parts []string
+	if opts.Podman {
+		parts = []string{"podman", "logs"}
+		if opts.Tail > 0 {
+			parts = append(parts, "--tail", strconv.Itoa(opts.Tail))
+		}
+	} else {
+		parts = []string{"journalctl", "--user", "-u", service + ".service"}
+		if opts.All {
+			// One journalctl merges both units by timestamp, each line tagged with its unit
+			parts = append(parts, "-u", "traefik.service")
+		}
+		if opts.Tail > 0 {
+			parts = append(parts, "-n", strconv.Itoa(opts.Tail))
+		}
+	}
+	if opts.Since != "" {
+		parts = append(parts, "--since", shellQuote(opts.Since))
+	}
+	if !opts.NoFollow {
+		parts = append(parts, "-f")
+	} else if !opts.Podman {
+		parts = append(parts, "--no-pager")
+	}
+	if opts.Podman {
+		parts = append(parts, "systemd-"+service)
+	}
+
+	cmd := strings.Join(parts, " ")
+	if opts.Grep != "" {
+		// podman logs writes the container's stderr to stderr
+		cmd += " 2>&1 | grep --line-buffered -- " + shellQuote(opts.Grep)
+	}
+	return cmd
 }
 
-func doLogs(envName string, usePodman bool) {
+func doLogs(envName string, opts LogOptions) {
 	_, env := loadEnv(envName)
-	cmd := fmt.Sprintf("journalctl --user -u %s.service -f", env.Quadlet.ServiceName)
-	if usePodman {
-		cmd = fmt.Sprintf("podman logs -f systemd-%s", env.Quadlet.ServiceName)
+	cmd := logsCommand(env.Quadlet.ServiceName, opts)
+	if opts.All {
+		logInfo("Streaming %s and traefik logs (traefik must run as %s to appear)...", env.Quadlet.ServiceName, env.User)
+	} else {
+		logInfo("Streaming logs...")
 	}
-	logInfo("Streaming logs...")
+	logDebug("   Exec: %s", cmd)
 
-	sshArgs := getSSHBaseArgs(env)
-	sshArgs = append(sshArgs, "-t", cmd)
+	c := sshCommand(context.Background(), env, "-t", cmd)
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	c.Stdin = os.Stdin
+	c.Run()
+}
+
+// doShell opens an interactive login shell in target_dir, reusing the multiplexed connection.
+func doShell(envName string) {
+	_, env := loadEnv(envName)
+	logInfo("🐚 Opening shell on %s (%s)...", envName, env.Host)
+
+	cmd := fmt.Sprintf("cd %s 2>/dev/null; exec $SHELL -l", env.Dir)
+	if dryRun {
+		logDebug("[SSH-SHELL] %s", cmd)
+		return
+	}
 
-	c := exec.Command("ssh", sshArgs...)
+	c := sshCommand(context.Background(), env, "-t", cmd)
 	c.Stdout = os.Stdout
 	c.Stderr = os.Stderr
 	c.Stdin = os.Stdin
@@ -352,39 +968,70 @@ type InitContext struct {
 	AppName    string
 	BinaryName string
 	User       string
+	Host       string
+}
+
+// InitOptions overrides the detected init values (empty means detect).
+type InitOptions struct {
+	Force  bool
+	App    string
+	User   string
+	Host   string
+	Binary string
 }
 
-func doInit() {
-	if _, err := os.Stat("deploy.yaml"); err == nil {
-		logFatal("deploy.yaml already exists")
+// normalizeAppName lowercases the name and replaces spaces with dashes.
+func normalizeAppName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(name), " ", "-"))
+}
+
+func doInit(opts InitOptions) {
+	if _, err := os.Stat(configPath); err == nil {
+		if !opts.Force {
+			logFatal("%s already exists (use --force to overwrite)", configPath)
+		}
+		logWarn("Overwriting existing %s", configPath)
 	}
 
 	// 1. Detect Context
-	cwd, err := os.Getwd()
-	if err != nil {
-		logFatal("Could not get working directory: %v", err)
+	appName := opts.App
+	if appName == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			logFatal("Could not get working directory: %v", err)
+		}
+		appName = filepath.Base(cwd)
 	}
-	appName := filepath.Base(cwd)
 
 	// Normalize appName (lowercase, replace spaces)
-	appName = strings.ToLower(strings.ReplaceAll(appName, " ", "-"))
+	appName = normalizeAppName(appName)
 
 	// Detect User
-	userName := "deploy_user"
-	u, err := user.Current()
-	if err == nil && u.Username != "" {
-		// Clean username (e.g., on Windows "DOMAIN\User" -> "User")
-		parts := strings.Split(u.Username, "\\")
-		userName = parts[len(parts)-1]
+	userName := opts.User
+	if userName == "" {
+		userName = "deploy_user"
+		u, err := user.Current()
+		if err == nil && u.Username != "" {
+			// Clean username (e.g., on Windows "DOMAIN\User" -> "User")
+			parts := strings.Split(u.Username, "\\")
+			userName = parts[len(parts)-1]
+		}
 	}
 
 	data := InitContext{
 		AppName:    appName,
-		BinaryName: appName + "-server", // Convention
+		BinaryName: opts.Binary,
 		User:       userName,
+		Host:       opts.Host,
+	}
+	if data.BinaryName == "" {
+		data.BinaryName = appName + "-server" // Convention
+	}
+	if data.Host == "" {
+		data.Host = "vps.example.com"
 	}
 
-	logInfo("✨ Initializing deploy.yaml for app '%s' with user '%s'...", data.AppName, data.User)
+	logInfo("✨ Initializing %s for app '%s' with user '%s'...", configPath, data.AppName, data.User)
 
 	// 2. Render Template
 	tmpl, err := template.New("init").Parse(defaultConfigTmpl)
@@ -392,7 +1039,7 @@ func doInit() {
 		logFatal("Internal template error: %v", err)
 	}
 
-	f, err := os.Create("deploy.yaml")
+	f, err := os.Create(configPath)
 	if err != nil {
 		logFatal("Failed to create file: %v", err)
 	}
@@ -402,7 +1049,11 @@ func doInit() {
 		logFatal("Failed to write config: %v", err)
 	}
 
-	logSuccess("Created deploy.yaml. Please edit 'host' and 'ssh_key' details.")
+	if opts.Host != "" {
+		logSuccess("Created %s. Please review the 'ssh_key' details.", configPath)
+		return
+	}
+	logSuccess("Created %s. Please edit 'host' and 'ssh_key' details.", configPath)
 }
 
 const defaultConfigTmpl = `app_name: "{{ .AppName }}"
@@ -442,7 +1093,7 @@ maintenance:
 
 environments:
   prod:
-    host: "vps.example.com"
+    host: "{{ .Host }}"
     user: "{{ .User }}"
     ssh_port: 22
     # ssh_key: "~/.ssh/id_ed25519_vps"
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseStatusOutput(t *testing.T) {
+	out := `service=active
+maintenance=active
+container=running
+version=v1.4.2
+disk=42%`
+
+	var s EnvStatus
+	parseStatusOutput(out, &s)
+
+	if !s.ServiceActive {
+		t.Errorf("Expected ServiceActive to be true")
+	}
+	if !s.ContainerRunning {
+		t.Errorf("Expected ContainerRunning to be true")
+	}
+	if !s.Maintenance {
+		t.Errorf("Expected Maintenance to be true")
+	}
+	if s.Version != "v1.4.2" {
+		t.Errorf("Expected Version 'v1.4.2', got '%s'", s.Version)
+	}
+	if s.DiskPercent != 42 {
+		t.Errorf("Expected DiskPercent 42, got %d", s.DiskPercent)
+	}
+
+	var empty EnvStatus
+	parseStatusOutput("service=inactive\nmaintenance=inactive\ncontainer=stopped\nversion=\n", &empty)
+	if empty.ServiceActive || empty.ContainerRunning || empty.Maintenance {
+		t.Errorf("Expected inactive service and stopped container, got %+v", empty)
+	}
+	if empty.Version != "unknown" {
+		t.Errorf("Expected Version 'unknown', got '%s'", empty.Version)
+	}
+}
+
+func TestLogsCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		opts LogOptions
+		want string
+	}{
+		{"Default", LogOptions{}, "journalctl --user -u app.service -f"},
+		{"Journal Filters", LogOptions{Tail: 100, Since: "1h", Grep: "ERROR", NoFollow: true},
+			"journalctl --user -u app.service -n 100 --since '1h' --no-pager 2>&1 | grep --line-buffered -- 'ERROR'"},
+		{"All", LogOptions{All: true, Tail: 20}, "journalctl --user -u app.service -u traefik.service -n 20 -f"},
+		{"Podman", LogOptions{Podman: true}, "podman logs -f systemd-app"},
+		{"Podman Filters", LogOptions{Podman: true, Tail: 50, Since: "10m", NoFollow: true},
+			"podman logs --tail 50 --since '10m' systemd-app"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := logsCommand("app", tt.opts); got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCopyArgs(t *testing.T) {
+	cfg := Config{Environments: map[string]Environment{"prod": {}}}
+
+	if env, path, remote := splitCopyArg(cfg, "prod:./data/app.log"); !remote || env != "prod" || path != "./data/app.log" {
+		t.Errorf("Expected remote prod ./data/app.log, got %v %s %s", remote, env, path)
+	}
+	if _, path, remote := splitCopyArg(cfg, "./local:file"); remote || path != "./local:file" {
+		t.Errorf("Expected local path, got %v %s", remote, path)
+	}
+
+	env := Environment{Dir: "/home/app/web/"}
+	tests := map[string]string{
+		"./data/app.log": "/home/app/web/data/app.log",
+		"":               "/home/app/web/",
+		"/etc/hosts":     "/etc/hosts",
+		"~/backup":       "~/backup",
+	}
+	for in, want := range tests {
+		if got := resolveRemotePath(env, in); got != want {
+			t.Errorf("resolveRemotePath(%q): expected %s, got %s", in, want, got)
+		}
+	}
+}
+
+func TestPruneSummary(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want string
+	}{
+		{"Reclaimed", "Deleted Images\nabc123\nTotal reclaimed space: 1.2GB", "1.2GB reclaimed"},
+		{"Rmi Lines", "Untagged: ghcr.io/acme/app:v1\nDeleted: abc123\nUntagged: ghcr.io/acme/app:v2\nDeleted: def456", "2 removed"},
+		{"Volume Names", "app-data\ncache", "2 removed"},
+		{"Nothing", "", "0 removed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pruneSummary(tt.out); got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"0B", 0, true},
+		{"512kB", 512000, true},
+		{"1.5GB", 1500000000, true},
+		{"2MiB", 2 << 20, true},
+		{"n/a", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseSize(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseSize(%q): expected %d/%v, got %d/%v", tt.in, tt.want, tt.ok, got, ok)
+		}
+	}
+	if got := reclaimedBytes("Deleted Images\nabc\nTotal reclaimed space: 1.5GB\nTotal reclaimed space: 0B"); got != 1500000000 {
+		t.Errorf("Expected 1500000000 reclaimed bytes, got %d", got)
+	}
+}
+
+func TestPruneResult(t *testing.T) {
+	if got := pruneResult(0, 0, true); !strings.Contains(got, "nothing to reclaim") {
+		t.Errorf("Expected nothing-to-reclaim message, got %q", got)
+	}
+	if got := pruneResult(1500000000, 2100000000, true); !strings.Contains(got, "2.1GB freed on disk") || !strings.Contains(got, "1.5GB") {
+		t.Errorf("Expected df delta and podman total, got %q", got)
+	}
+	if got := pruneResult(512000, 0, false); !strings.Contains(got, "512kB") {
+		t.Errorf("Expected podman total without df, got %q", got)
+	}
+}
+
+func TestParseHostStats(t *testing.T) {
+	out := "service=active\ncontainer=running\nversion=v1.2.0\ndisk=41%\nload=0.42\nmem=63\nupdates=7"
+	var s HostStats
+	parseHostStats(out, &s)
+	if !s.ServiceActive || s.DiskPercent != 41 || s.Version != "v1.2.0" {
+		t.Errorf("Expected status fields to be parsed, got %+v", s.EnvStatus)
+	}
+	if s.Load != "0.42" || s.MemPercent != 63 || s.Updates != 7 {
+		t.Errorf("Expected load 0.42, mem 63, updates 7, got %s %d %d", s.Load, s.MemPercent, s.Updates)
+	}
+
+	s = HostStats{}
+	parseHostStats("service=inactive\nload=1.00", &s)
+	if s.Updates != -1 {
+		t.Errorf("Expected unknown updates (-1) without a package manager, got %d", s.Updates)
+	}
+}
+
+func TestFormatHostStatsOffline(t *testing.T) {
+	row := formatHostStats(HostStats{EnvStatus: EnvStatus{Env: "prod", Host: "10.0.0.1", Error: "ssh: connect timed out"}})
+	if !strings.Contains(row, "prod") || !strings.Contains(row, "offline") {
+		t.Errorf("Expected offline row, got %q", row)
+	}
+}
+
+func TestDoInitOverrides(t *testing.T) {
+	dir := t.TempDir()
+	old := configPath
+	configPath = filepath.Join(dir, "deploy.yaml")
+	t.Cleanup(func() { configPath = old })
+
+	os.WriteFile(configPath, []byte("old"), 0644)
+	doInit(InitOptions{Force: true, App: "My App", User: "alice", Host: "203.0.113.7"})
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatalf("Expected deploy.yaml, got %v", err)
+	}
+	out := string(data)
+	for _, want := range []string{`app_name: "my-app"`, `binary_name: "my-app-server"`, `host: "203.0.113.7"`, `user: "alice"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in generated config", want)
+		}
+	}
+
+	doInit(InitOptions{Force: true, App: "x", Binary: "xd"})
+	data, _ = os.ReadFile(configPath)
+	if !strings.Contains(string(data), `binary_name: "xd"`) || !strings.Contains(string(data), `host: "vps.example.com"`) {
+		t.Errorf("Expected binary override and default host, got:\n%s", data)
+	}
+}
+
+func TestChownPaths(t *testing.T) {
+	env := Environment{Dir: "/srv/app/", Quadlet: Quadlet{ChownVolumes: []string{"./data", "./uploads/", "/var/lib/app", "./../other"}}}
+	got := chownPaths(env)
+	want := []string{"/srv/app/data", "/srv/app/uploads", "/var/lib/app", "/srv/other"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if outside := pathsOutsideDir(env.Dir, got); !slices.Equal(outside, []string{"/var/lib/app", "/srv/other"}) {
+		t.Errorf("Expected the last two paths outside target_dir, got %v", outside)
+	}
+	if outside := pathsOutsideDir("/srv/app", []string{"/srv/app", "/srv/apple"}); !slices.Equal(outside, []string{"/srv/apple"}) {
+		t.Errorf("Expected only /srv/apple outside, got %v", outside)
+	}
+}
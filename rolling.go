@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// Rolling restarts keep a second, transient instance of the app serving while the main
+// service restarts. Both containers carry the same Traefik labels, so Traefik load-balances
+// across them during the cutover. The app must tolerate two concurrent instances
+// (shared volumes, no fixed published ports).
+
+// rollingServiceName is the unit name of the transient instance.
+func rollingServiceName(serviceName string) string {
+	return serviceName + "-rolling"
+}
+
+// rollingEnv derives the transient instance's env: same image and labels as the main
+// service, but a unique name and no published ports (they would clash).
+func rollingEnv(env Environment, image string) Environment {
+	t := env
+	t.Quadlet.ServiceName = rollingServiceName(env.Quadlet.ServiceName)
+	t.Quadlet.Image = image
+	t.Quadlet.Ports = nil
+	// Outside the pod: a second app in its network namespace would clash on the port
+	t.Pod = PodConfig{}
+	if t.Quadlet.ContainerName != "" {
+		t.Quadlet.ContainerName += "-rolling"
+	}
+	// The shared service name is what makes Traefik route to both instances
+	t.Quadlet.Labels = generateTraefikLabels(env.Quadlet.ServiceName, routerWithHealthPath(env.Quadlet), "myresolver", env.AuthProvider)
+	return t
+}
+
+// rollingWaitScript waits until the transient container is healthy: via its podman
+// health_cmd if set, otherwise until it is running (after health_start_period).
+func rollingWaitScript(q Quadlet) string {
+	container := "systemd-" + q.ServiceName
+	if q.ContainerName != "" {
+		container = q.ContainerName
+	}
+	probe := fmt.Sprintf(`[ "$(podman container inspect -f '{{.State.Running}}' %s 2>/dev/null)" = true ]`, container)
+	if q.HealthCmd != "" {
+		probe = fmt.Sprintf("podman healthcheck run %s > /dev/null 2>&1", container)
+	}
+	retries, interval, startPeriod := healthCheckTiming(q)
+	return fmt.Sprintf(`sleep %d; for i in $(seq 1 %d); do if %s; then echo "OK"; exit 0; fi; sleep %d; done; echo "Transient instance did not become healthy"; exit 1`,
+		startPeriod, retries, probe, interval)
+}
+
+// rollingStopTimeout bounds stopping and removing the transient instance.
+const rollingStopTimeout = 60 * time.Second
+
+// startRollingInstance uploads and starts the transient quadlet running image, then waits
+// for it to become healthy. The returned stop func removes it again; it is also
+// registered as an exit hook so a fatal error never leaves it behind.
+func startRollingInstance(ctx context.Context, env Environment, image string) (func(), error) {
+	t := rollingEnv(env, image)
+	name := t.Quadlet.ServiceName
+	unitPath := fmt.Sprintf("~/.config/containers/systemd/%s.container", name)
+
+	path, _ := generateQuadlet(t, filepath.Join("build", "rolling"))
+	if err := runRsyncContext(ctx, env, []string{path}, remotePath(env, "~/.config/containers/systemd/")); err != nil {
+		return nil, fmt.Errorf("uploading transient quadlet failed: %w", err)
+	}
+
+	stopped := false
+	stop := func() {
+		if stopped {
+			return
+		}
+		stopped = true
+		logInfo("🧹 Stopping transient instance %s...", name)
+		// A fresh context: cleanup must also work once a release --timeout has cancelled ctx
+		stopCtx, cancel := context.WithTimeout(context.Background(), rollingStopTimeout)
+		defer cancel()
+		if err := runSSHContext(stopCtx, env, fmt.Sprintf("systemctl --user stop %s.service; rm -f %s && systemctl --user daemon-reload", name, unitPath)); err != nil {
+			logWarn("Failed to remove transient instance %s: %v", name, err)
+		}
+	}
+	onExit(stop)
+
+	logInfo("🔀 Starting transient instance %s (%s)...", name, image)
+	if err := runSSHContext(ctx, env, fmt.Sprintf("systemctl --user daemon-reload && systemctl --user start %s.service", name)); err != nil {
+		stop()
+		return nil, fmt.Errorf("starting transient instance failed: %w", err)
+	}
+	if err := runSSHContext(ctx, env, rollingWaitScript(t.Quadlet)); err != nil {
+		stop()
+		return nil, fmt.Errorf("transient instance %s is not healthy: %w", name, err)
+	}
+	return stop, nil
+}
+
+// runningImage returns the image of the main service's container, or "" if it is not running.
+func runningImage(ctx context.Context, env Environment) string {
+	container := "systemd-" + env.Quadlet.ServiceName
+	if env.Quadlet.ContainerName != "" {
+		container = env.Quadlet.ContainerName
+	}
+	out, err := runSSHOutputContext(ctx, env, fmt.Sprintf("podman container inspect -f '{{.State.Running}} {{.ImageName}}' %s", container))
+	if err != nil {
+		return ""
+	}
+	var running bool
+	var image string
+	if n, _ := fmt.Sscanf(out, "%t %s", &running, &image); n != 2 || !running {
+		return ""
+	}
+	return image
+}
+
+// doRollingRestart restarts the service while a transient instance keeps serving.
+func doRollingRestart(envName string) {
+	_, env := loadEnv(envName)
+	serviceName := env.Quadlet.ServiceName
+
+	image := runningImage(context.Background(), env)
+	if image == "" && !dryRun {
+		logFatal("%s is not running on %s; nothing to keep serving. Use 'deploy restart %s'.", serviceName, env.Host, envName)
+	}
+	stop, err := startRollingInstance(context.Background(), env, image)
+	if err != nil {
+		logFatal("Rolling restart aborted, %s untouched: %v", serviceName, err)
+	}
+	defer stop()
+
+	logInfo("⚙️  Restarting %s while the transient instance serves...", serviceName)
+	if err := runSSH(env, fmt.Sprintf("systemctl --user restart %s.service && sleep 2 && systemctl --user is-active %s.service", serviceName, serviceName)); err != nil {
+		logFatal("Restart failed: %v", err)
+	}
+	if checkScript, target := healthCheckScript(env.Quadlet); checkScript != "" {
+		logInfo("🩺 Checking %s (%s)...", serviceName, target)
+		if err := runSSH(env, checkScript); err != nil {
+			logFatal("Health check after restart failed: %v", err)
+		}
+	}
+	stop()
+	logSuccess("Rolling restart of '%s' completed.", serviceName)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRollingEnv(t *testing.T) {
+	env := Environment{Quadlet: Quadlet{
+		ServiceName:   "app",
+		Image:         "localhost/app:latest",
+		ContainerName: "api",
+		Ports:         []string{"8080:8080"},
+		Router:        RouterConfig{Domain: "app.example.com", InternalPort: 8080},
+	}}
+	r := rollingEnv(env, "localhost/app:v1.2.0")
+
+	if r.Quadlet.ServiceName != "app-rolling" || r.Quadlet.ContainerName != "api-rolling" {
+		t.Errorf("Expected unique names, got %s / %s", r.Quadlet.ServiceName, r.Quadlet.ContainerName)
+	}
+	if r.Quadlet.Image != "localhost/app:v1.2.0" {
+		t.Errorf("Expected the running image, got %s", r.Quadlet.Image)
+	}
+	if len(r.Quadlet.Ports) != 0 {
+		t.Errorf("Expected published ports to be dropped, got %v", r.Quadlet.Ports)
+	}
+	// Same Traefik service as the main unit, so both instances get traffic
+	if !slices.Contains(r.Quadlet.Labels, "traefik.http.services.app.loadbalancer.server.port=8080") {
+		t.Errorf("Expected labels of service 'app', got %v", r.Quadlet.Labels)
+	}
+	if env.Quadlet.ServiceName != "app" || len(env.Quadlet.Ports) != 1 {
+		t.Error("Expected the original env to be left untouched")
+	}
+}
+
+func TestRollingWaitScript(t *testing.T) {
+	script := rollingWaitScript(Quadlet{ServiceName: "app-rolling"})
+	if !strings.Contains(script, "podman container inspect -f '{{.State.Running}}' systemd-app-rolling") {
+		t.Errorf("Expected a running check without health_cmd, got %s", script)
+	}
+	script = rollingWaitScript(Quadlet{ServiceName: "app-rolling", HealthCmd: "/app -health"})
+	if !strings.Contains(script, "podman healthcheck run systemd-app-rolling") {
+		t.Errorf("Expected podman healthcheck with health_cmd, got %s", script)
+	}
+}
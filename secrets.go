@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// expandHome resolves a leading "~/" since exec'd tools (unlike ssh) do not.
+func expandHome(p string) string {
+	if strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, p[2:])
+		}
+	}
+	return p
+}
+
+// ageDecryptArgs / ageEncryptArgs build the age CLI calls for the env's secrets file.
+// Without a recipients_file, age encrypts to the recipient of the identity itself.
+func ageDecryptArgs(s SecretsConfig) []string {
+	return []string{"--decrypt", "-i", expandHome(s.Identity), s.File}
+}
+
+func ageEncryptArgs(s SecretsConfig, out string) []string {
+	args := []string{"--encrypt", "--armor", "-o", out}
+	if s.RecipientsFile != "" {
+		return append(args, "-R", expandHome(s.RecipientsFile))
+	}
+	return append(args, "-i", expandHome(s.Identity))
+}
+
+func requireSecrets(env Environment) error {
+	if env.Secrets.File == "" || env.Secrets.Identity == "" {
+		return fmt.Errorf("'secrets.file' and 'secrets.identity' are required")
+	}
+	if _, err := exec.LookPath("age"); err != nil {
+		return fmt.Errorf("age not found locally (https://age-encryption.org)")
+	}
+	return nil
+}
+
+// decryptSecrets writes the plaintext of the secrets file to a private temp file.
+// The caller must run cleanup. A missing secrets file yields an empty one (first edit).
+func decryptSecrets(s SecretsConfig) (path string, cleanup func(), err error) {
+	dir, err := os.MkdirTemp("", "deploy-secrets-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup = func() { os.RemoveAll(dir) }
+	path = filepath.Join(dir, ".env")
+
+	var plain []byte
+	if _, statErr := os.Stat(s.File); statErr == nil {
+		var stderr bytes.Buffer
+		cmd := exec.Command("age", ageDecryptArgs(s)...)
+		cmd.Stderr = &stderr
+		if plain, err = cmd.Output(); err != nil {
+			cleanup()
+			return "", nil, fmt.Errorf("decrypting %s failed: %v %s", s.File, err, strings.TrimSpace(stderr.String()))
+		}
+	}
+	if err := os.WriteFile(path, plain, 0600); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return path, cleanup, nil
+}
+
+// localEnvFile returns the file to upload as the remote .env: sync_env_file as-is, or the
+// decrypted secrets file. ok is false if the env has neither.
+func localEnvFile(env Environment) (path string, cleanup func(), ok bool, err error) {
+	switch {
+	case env.SyncEnvFile != "" && env.Secrets.File != "":
+		return "", nil, false, fmt.Errorf("set either 'sync_env_file' or 'secrets.file', not both")
+	case env.SyncEnvFile != "":
+		return env.SyncEnvFile, func() {}, true, nil
+	case env.Secrets.File == "":
+		return "", nil, false, nil
+	}
+	if err := requireSecrets(env); err != nil {
+		return "", nil, false, err
+	}
+	if dryRun {
+		logDebug("[DRY] age %s", strings.Join(ageDecryptArgs(env.Secrets), " "))
+		return env.Secrets.File, func() {}, true, nil
+	}
+	path, cleanup, err = decryptSecrets(env.Secrets)
+	return path, cleanup, err == nil, err
+}
+
+// EnvTemplateData is passed to env files that contain "{{": the build metadata
+// ({{ .Version }}, {{ .Commit }}, {{ .Date }}) plus env_template_vars as {{ .Vars.KEY }}.
+type EnvTemplateData struct {
+	BuildMetadata
+	Vars map[string]string
+}
+
+// renderEnvFile renders path as a text/template into a private temp file. Files without
+// template delimiters are returned unchanged, so plain .env files keep working as-is.
+func renderEnvFile(path string, data EnvTemplateData) (string, func(), error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	if !bytes.Contains(raw, []byte("{{")) {
+		return path, func() {}, nil
+	}
+	tmpl, err := template.New(filepath.Base(path)).Option("missingkey=error").Parse(string(raw))
+	if err != nil {
+		return "", nil, fmt.Errorf("env file template %s: %w", path, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", nil, fmt.Errorf("env file template %s: %w", path, err)
+	}
+
+	dir, err := os.MkdirTemp("", "deploy-env-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	out := filepath.Join(dir, ".env")
+	if err := os.WriteFile(out, buf.Bytes(), 0600); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return out, cleanup, nil
+}
+
+// displayEnvFile names the source of the remote .env for prompts.
+func displayEnvFile(env Environment) string {
+	if env.SyncEnvFile != "" {
+		return env.SyncEnvFile
+	}
+	return env.Secrets.File + " (decrypted)"
+}
+
+// pushEnvFile uploads localPath as <target_dir>/.env (the temp plaintext is 0600, rsync -a keeps that).
+func pushEnvFile(ctx context.Context, env Environment, localPath string) error {
+	if err := runRsyncContext(ctx, env, []string{localPath}, remotePath(env, env.Dir+"/.env")); err != nil {
+		return fmt.Errorf("rsync failed: %w", err)
+	}
+	return nil
+}
+
+// doSecretsEdit decrypts the env's secrets into a temp file, opens $EDITOR and
+// re-encrypts if the content changed. Plaintext never touches the project directory.
+func doSecretsEdit(envName string) {
+	_, env := loadEnv(envName)
+	if err := requireSecrets(env); err != nil {
+		logFatal("%s: %v", envName, err)
+	}
+
+	path, cleanup, err := decryptSecrets(env.Secrets)
+	if err != nil {
+		logFatal("%v", err)
+	}
+	onExit(cleanup)
+	defer cleanup()
+	before, _ := os.ReadFile(path)
+
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	// $EDITOR may carry arguments ("code --wait")
+	parts := strings.Fields(editor)
+	cmd := exec.Command(parts[0], append(parts[1:], path)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		logFatal("Editor failed: %v", err)
+	}
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		logFatal("Failed to read edited secrets: %v", err)
+	}
+	if bytes.Equal(before, after) {
+		logInfo("No changes, %s left untouched.", env.Secrets.File)
+		return
+	}
+
+	// Encrypt next to the target and rename, so a failure never truncates the old file
+	tmp := env.Secrets.File + ".tmp"
+	enc := exec.Command("age", ageEncryptArgs(env.Secrets, tmp)...)
+	enc.Stdin = bytes.NewReader(after)
+	enc.Stderr = os.Stderr
+	if err := enc.Run(); err != nil {
+		os.Remove(tmp)
+		logFatal("Encrypting %s failed: %v", env.Secrets.File, err)
+	}
+	if err := os.Rename(tmp, env.Secrets.File); err != nil {
+		logFatal("Failed to replace %s: %v", env.Secrets.File, err)
+	}
+	logSuccess("🔐 Updated %s. Run 'deploy secrets push %s' to apply.", env.Secrets.File, envName)
+}
+
+// doSecretsPush decrypts the env's secrets and uploads them as the remote .env.
+func doSecretsPush(envName string) {
+	_, env := loadEnv(envName)
+	if err := requireSecrets(env); err != nil {
+		logFatal("%s: %v", envName, err)
+	}
+	if _, err := os.Stat(env.Secrets.File); err != nil {
+		logFatal("%s not found. Create it with 'deploy secrets edit %s'.", env.Secrets.File, envName)
+	}
+	if !confirm(fmt.Sprintf("Overwrite remote .env on %s with %s?", env.Host, env.Secrets.File)) {
+		return
+	}
+
+	if dryRun {
+		logDebug("[DRY] age %s | rsync -> %s:%s/.env", strings.Join(ageDecryptArgs(env.Secrets), " "), env.Host, env.Dir)
+		return
+	}
+	path, cleanup, err := decryptSecrets(env.Secrets)
+	if err != nil {
+		logFatal("%v", err)
+	}
+	onExit(cleanup)
+	defer cleanup()
+
+	if err := pushEnvFile(context.Background(), env, path); err != nil {
+		logFatal("%v", err)
+	}
+	logSuccess("✅ Pushed secrets to %s:%s/.env. Restart the service to pick them up.", env.Host, env.Dir)
+}
+
+// readSecretValue reads a secret from stdin: piped input as-is (trailing newline dropped),
+// or a hidden prompt on a terminal.
+func readSecretValue(name string) (string, error) {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return "", err
+	}
+	if info.Mode()&os.ModeCharDevice == 0 {
+		data, err := io.ReadAll(os.Stdin)
+		return strings.TrimRight(string(data), "\r\n"), err
+	}
+
+	stty := func(arg string) {
+		c := exec.Command("stty", arg)
+		c.Stdin = os.Stdin
+		c.Run()
+	}
+	stty("-echo")
+	defer stty("echo")
+	value := prompt(fmt.Sprintf("Value for secret '%s' (hidden)", name))
+	fmt.Println()
+	return value, nil
+}
+
+// doPodmanSecretSet creates (or replaces) a podman secret on the server.
+// The value travels over ssh stdin, so it never shows up in argv or shell history.
+func doPodmanSecretSet(envName, name string) {
+	_, env := loadEnv(envName)
+	known := false
+	for _, s := range env.Quadlet.Secrets {
+		known = known || s.Name == name
+	}
+	if !known {
+		logWarn("Secret '%s' is not listed in quadlet.secrets of %s; the app will not see it.", name, envName)
+	}
+
+	value, err := readSecretValue(name)
+	if err != nil {
+		logFatal("Failed to read secret value: %v", err)
+	}
+	if value == "" {
+		logFatal("Empty value, secret '%s' not changed.", name)
+	}
+
+	cmd := fmt.Sprintf("podman secret create --replace %s -", shellQuote(name))
+	if dryRun {
+		logDebug("[SSH] %s < (%d bytes)", cmd, len(value))
+		return
+	}
+	c := sshCommand(context.Background(), env, cmd)
+	c.Stdin = strings.NewReader(value)
+	if err := runCommand("SSH", c); err != nil {
+		logFatal("Failed to set secret '%s' on %s: %v", name, env.Host, err)
+	}
+	logSuccess("🔑 Secret '%s' set on %s. Restart the service to pick it up.", name, env.Host)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAgeArgs(t *testing.T) {
+	home, _ := os.UserHomeDir()
+	s := SecretsConfig{File: "secrets.prod.env.age", Identity: "~/.config/age/key.txt"}
+
+	dec := strings.Join(ageDecryptArgs(s), " ")
+	if dec != "--decrypt -i "+filepath.Join(home, ".config/age/key.txt")+" secrets.prod.env.age" {
+		t.Errorf("Unexpected decrypt args: %s", dec)
+	}
+	if enc := strings.Join(ageEncryptArgs(s, "out.age"), " "); !strings.HasSuffix(enc, "-o out.age -i "+filepath.Join(home, ".config/age/key.txt")) {
+		t.Errorf("Expected encryption to the identity without recipients_file: %s", enc)
+	}
+
+	s.RecipientsFile = ".age-recipients"
+	if enc := strings.Join(ageEncryptArgs(s, "out.age"), " "); !strings.HasSuffix(enc, "-R .age-recipients") {
+		t.Errorf("Expected -R recipients_file: %s", enc)
+	}
+}
+
+func TestLocalEnvFile(t *testing.T) {
+	path, cleanup, ok, err := localEnvFile(Environment{SyncEnvFile: ".env.prod"})
+	if err != nil || !ok || path != ".env.prod" {
+		t.Errorf("Expected sync_env_file as-is, got %s %v %v", path, ok, err)
+	}
+	cleanup()
+
+	if _, _, ok, err := localEnvFile(Environment{}); ok || err != nil {
+		t.Errorf("Expected nothing to sync, got %v %v", ok, err)
+	}
+
+	env := Environment{SyncEnvFile: ".env.prod", Secrets: SecretsConfig{File: "secrets.env.age"}}
+	if _, _, _, err := localEnvFile(env); err == nil {
+		t.Error("Expected error when both sync_env_file and secrets.file are set")
+	}
+
+	if _, _, _, err := localEnvFile(Environment{Secrets: SecretsConfig{File: "secrets.env.age"}}); err == nil || !strings.Contains(err.Error(), "secrets.identity") {
+		t.Errorf("Expected missing identity error, got %v", err)
+	}
+}
+
+func TestRenderEnvFile(t *testing.T) {
+	dir := t.TempDir()
+	data := EnvTemplateData{BuildMetadata: BuildMetadata{Version: "v1.4.0", Commit: "abc123"}, Vars: map[string]string{"REGION": "eu"}}
+
+	plain := filepath.Join(dir, ".env.plain")
+	os.WriteFile(plain, []byte("DB=postgres://x\n"), 0600)
+	path, cleanup, err := renderEnvFile(plain, data)
+	if err != nil || path != plain {
+		t.Errorf("Expected plain file to pass through unchanged, got %s (%v)", path, err)
+	}
+	cleanup()
+
+	tmpl := filepath.Join(dir, ".env.tmpl")
+	os.WriteFile(tmpl, []byte("APP_VERSION={{ .Version }}\nGIT_SHA={{ .Commit }}\nREGION={{ .Vars.REGION }}\n"), 0600)
+	path, cleanup, err = renderEnvFile(tmpl, data)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer cleanup()
+	out, _ := os.ReadFile(path)
+	if string(out) != "APP_VERSION=v1.4.0\nGIT_SHA=abc123\nREGION=eu\n" {
+		t.Errorf("Unexpected rendered env file: %q", out)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected rendered file mode 0600, got %v", info.Mode().Perm())
+	}
+
+	os.WriteFile(tmpl, []byte("X={{ .Vars.MISSING }}\n"), 0600)
+	if _, _, err := renderEnvFile(tmpl, data); err == nil {
+		t.Error("Expected error for an unknown template var")
+	}
+}
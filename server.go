@@ -1,15 +1,26 @@
 package main
 
 import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"time"
 )
 
 // doServerInit generates a server.yaml template
 func doServerInit() {
-	if _, err := os.Stat("server.yaml"); err == nil {
-		logFatal("server.yaml already exists")
+	if _, err := os.Stat(serverConfigPath); err == nil {
+		logFatal("%s already exists", serverConfigPath)
 	}
 
 	defaultConfig := `host: "vps.example.com"
@@ -22,34 +33,50 @@ stack:
     email: "admin@example.com"
     dashboard: true
     network_name: "traefik-net"
+    # challenge: "dns"           # Default "http"; "dns" allows wildcard certs
+    # dns_provider: "cloudflare"
+    # dns_env: ["CF_DNS_API_TOKEN=${CF_DNS_API_TOKEN}"]
+    # entrypoints:               # Extra entrypoints (published on the host) next to web/websecure
+    #   metrics: ":9100"
+    # trusted_ips: ["10.0.0.0/8"] # LB/CDN in front of Traefik: honor its X-Forwarded-For (real client IP)
+    # proxy_protocol: true        # Also accept the PROXY protocol from trusted_ips
     
     # Global Auth Provider
     auth:
       provider: "basic" # or "authelia"
+      # users: ["admin:$2y$05$..."] # htpasswd -nbB; used by routers with 'auth: true'
 
   authelia:
     subdomain: "auth"
+    domain: "example.com"
     users_file: "users.yml"
 
   watchtower:
     schedule: "0 4 * * *" # Every day at 4am
 `
-	if err := os.WriteFile("server.yaml", []byte(defaultConfig), 0644); err != nil {
-		logFatal("Failed to write server.yaml: %v", err)
+	if err := os.WriteFile(serverConfigPath, []byte(defaultConfig), 0644); err != nil {
+		logFatal("Failed to write %s: %v", serverConfigPath, err)
+	}
+	logSuccess("Created %s. Please edit it with your VPS details.", serverConfigPath)
+}
+
+// serverEnv is the SSH target for the infrastructure stack in server.yaml.
+func serverEnv(cfg ServerConfig) Environment {
+	return Environment{
+		Host:       cfg.Host,
+		User:       cfg.User,
+		Port:       cfg.SSHPort,
+		SSHKey:     cfg.SSHKey,
+		JumpHost:   cfg.JumpHost,
+		SSHTimeout: cfg.SSHTimeout,
+		Dir:        "/root", // Default to root home for infrastructure
 	}
-	logSuccess("Created server.yaml. Please edit it with your VPS details.")
 }
 
 // doServerProvision installs the stack defined in server.yaml
 func doServerProvision() {
 	cfg := loadServerConfig()
-	env := Environment{
-		Host:   cfg.Host,
-		User:   cfg.User,
-		Port:   cfg.SSHPort,
-		SSHKey: cfg.SSHKey,
-		Dir:    "/root", // Default to root home for infrastructure
-	}
+	env := serverEnv(cfg)
 
 	logInfo("🚀 Provisioning Server Stack on %s...", env.Host)
 
@@ -76,6 +103,215 @@ func doServerProvision() {
 	logSuccess("✅ Server Provisioning Complete.")
 }
 
+// stackUnits lists the systemd units 'server provision' installs for cfg.
+func stackUnits(cfg ServerConfig) []string {
+	units := []string{"traefik"}
+	if cfg.Stack.Traefik.Auth.Provider == "authelia" {
+		units = append(units, "authelia")
+	}
+	if cfg.Stack.Watchtower.Schedule != "" {
+		units = append(units, "watchtower")
+	}
+	return units
+}
+
+// acmeFile is where provisionTraefik stores the Let's Encrypt certificates.
+const acmeFile = "~/traefik/letsencrypt/acme.json"
+
+// AcmeCert is one certificate stored by Traefik in acme.json.
+type AcmeCert struct {
+	Domain   string
+	NotAfter time.Time
+}
+
+// parseAcmeCerts extracts the certificates of every resolver in a Traefik acme.json.
+func parseAcmeCerts(data []byte) ([]AcmeCert, error) {
+	var resolvers map[string]struct {
+		Certificates []struct {
+			Domain struct {
+				Main string `json:"main"`
+			} `json:"domain"`
+			Certificate string `json:"certificate"`
+		} `json:"Certificates"`
+	}
+	if err := json.Unmarshal(data, &resolvers); err != nil {
+		return nil, fmt.Errorf("invalid acme.json: %w", err)
+	}
+	var certs []AcmeCert
+	for _, r := range resolvers {
+		for _, c := range r.Certificates {
+			if c.Certificate == "" {
+				continue // Order still pending
+			}
+			pemData, err := base64.StdEncoding.DecodeString(c.Certificate)
+			if err != nil {
+				return nil, fmt.Errorf("certificate for %s: %w", c.Domain.Main, err)
+			}
+			block, _ := pem.Decode(pemData)
+			if block == nil {
+				return nil, fmt.Errorf("certificate for %s: no PEM data", c.Domain.Main)
+			}
+			x, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("certificate for %s: %w", c.Domain.Main, err)
+			}
+			certs = append(certs, AcmeCert{Domain: c.Domain.Main, NotAfter: x.NotAfter})
+		}
+	}
+	sort.Slice(certs, func(i, j int) bool { return certs[i].NotAfter.Before(certs[j].NotAfter) })
+	return certs, nil
+}
+
+// doServerStatus reports the state of the stack units and each certificate's expiry.
+// It exits non-zero when a certificate is inside certWarnWindow, so it can run from cron.
+func doServerStatus() {
+	cfg := loadServerConfig()
+	env := serverEnv(cfg)
+	logInfo("🩺 Checking server stack on %s...", env.Host)
+
+	units := stackUnits(cfg)
+	var script strings.Builder
+	for _, u := range units {
+		fmt.Fprintf(&script, "echo \"%s=$(systemctl --user is-active %s.service 2>/dev/null)\"; ", u, u)
+	}
+	out, err := runSSHOutput(env, script.String())
+	if err != nil && out == "" {
+		logFatal("SSH connection failed. Check host/user/key in server.yaml: %v", err)
+	}
+	states := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+			states[k] = v
+		}
+	}
+	for _, u := range units {
+		state := states[u]
+		if state == "" {
+			state = "unknown"
+		}
+		color := Red
+		if state == "active" {
+			color = Green
+		}
+		fmt.Printf("  %-12s %s%s%s\n", u+".service", color, state, Reset)
+	}
+
+	certs, err := readAcmeCerts(env)
+	if err != nil {
+		logWarn("Could not read certificates: %v", err)
+		return
+	}
+	if len(certs) == 0 {
+		fmt.Printf("  %-12s %s\n", "certs", "none yet (acme.json is empty)")
+		return
+	}
+	now := time.Now()
+	for _, c := range certs {
+		days := int(c.NotAfter.Sub(now).Hours() / 24)
+		color := Green
+		if c.NotAfter.Before(now.Add(certWarnWindow)) {
+			color = Red
+		}
+		fmt.Printf("  %-12s %s%s expires %s (%dd)%s\n", "cert", color, c.Domain, c.NotAfter.Format("2006-01-02"), days, Reset)
+	}
+	if expiring := expiringCerts(certs, now, certWarnWindow); len(expiring) > 0 {
+		for _, c := range expiring {
+			logWarn("Certificate for %s expires %s. Check the Traefik logs for failed renewals.", c.Domain, c.NotAfter.Format("2006-01-02"))
+		}
+		os.Exit(1)
+	}
+}
+
+// certWarnWindow is how close to expiry a certificate makes 'server status' fail.
+// Traefik renews 30 days ahead, so anything inside it means renewals are failing.
+const certWarnWindow = 14 * 24 * time.Hour
+
+// readAcmeCerts fetches and parses acme.json; an empty file (no certs issued yet) is not an error.
+func readAcmeCerts(env Environment) ([]AcmeCert, error) {
+	out, err := runSSHOutput(env, fmt.Sprintf("if [ -s %s ]; then cat %s; else echo EMPTY; fi", acmeFile, acmeFile))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", acmeFile, err)
+	}
+	if out == "" || out == "EMPTY" {
+		return nil, nil
+	}
+	return parseAcmeCerts([]byte(out))
+}
+
+// expiringCerts returns the certs that expire within window of now (or already have).
+func expiringCerts(certs []AcmeCert, now time.Time, window time.Duration) []AcmeCert {
+	var out []AcmeCert
+	for _, c := range certs {
+		if c.NotAfter.Before(now.Add(window)) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// defaultTraefikVersion is used when traefik.version is unset and GitHub cannot be asked.
+const defaultTraefikVersion = "v3.0"
+
+// latestTraefikVersion asks GitHub for the newest Traefik release, falling back loudly.
+func latestTraefikVersion() string {
+	tag, err := fetchLatestGitHubRelease("traefik/traefik")
+	if err == nil {
+		logInfo("   Using latest Traefik release %s (pin it with traefik.version).", tag)
+		return tag
+	}
+	var ghErr *GitHubError
+	if errors.As(err, &ghErr) && ghErr.RateLimited {
+		logWarn("GitHub rate limit hit, using Traefik %s. Set GITHUB_TOKEN or traefik.version.", defaultTraefikVersion)
+	} else {
+		logWarn("Could not fetch the latest Traefik release (%v), using %s.", err, defaultTraefikVersion)
+	}
+	return defaultTraefikVersion
+}
+
+// expandDNSEnv resolves ${VAR} in traefik.dns_env; as in deploy.yaml an unset variable is
+// an error, not an empty credential.
+func expandDNSEnv(kvs []string) ([]string, error) {
+	var missing []string
+	out := make([]string, 0, len(kvs))
+	for _, kv := range kvs {
+		if key, _, ok := strings.Cut(kv, "="); !ok || key == "" {
+			return nil, fmt.Errorf("traefik.dns_env: '%s' is not KEY=value", kv)
+		}
+		v := expandStrict(kv, &missing)
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, fmt.Errorf("traefik.dns_env: value of '%s' contains a newline", kv)
+		}
+		out = append(out, v)
+	}
+	if err := unsetVarsError("traefik.dns_env", missing); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// uploadDNSEnv writes the DNS provider credentials to ~/traefik/dns.env (0600, rsync -a
+// keeps that) instead of putting them into the world-readable traefik.container.
+func uploadDNSEnv(env Environment, dnsEnv []string) {
+	if dryRun {
+		logDebug("[DNS-ENV] %d variable(s) -> ~/traefik/dns.env", len(dnsEnv))
+		return
+	}
+	dir, err := os.MkdirTemp("", "deploy-dns-")
+	if err != nil {
+		logFatal("Failed to create temp dir: %v", err)
+	}
+	path := filepath.Join(dir, "dns.env")
+	err = os.WriteFile(path, []byte(strings.Join(dnsEnv, "\n")+"\n"), 0600)
+	if err == nil {
+		err = runRsyncSafe(env, []string{path}, remotePath(env, "~/traefik/dns.env"))
+	}
+	// Before logFatal, which would skip a defer: no plaintext credentials left in /tmp
+	os.RemoveAll(dir)
+	if err != nil {
+		logFatal("Uploading traefik.dns_env failed: %v", err)
+	}
+}
+
 func provisionTraefik(env Environment, tCfg TraefikStack) {
 	logInfo("📦 Provisioning Traefik...")
 
@@ -83,11 +319,51 @@ func provisionTraefik(env Environment, tCfg TraefikStack) {
 	if netName == "" {
 		netName = "traefik-net"
 	}
+	if tCfg.Version == "" {
+		tCfg.Version = latestTraefikVersion()
+	}
 
 	// Ensure network exists (blindly try to create, ignore if exists)
 	// Actually better to use a systemd network unit or create it once.
 	// For simplicity, we'll generate a network unit.
 
+	challenge := tCfg.Challenge
+	if challenge == "" {
+		challenge = "http"
+	}
+	var dnsEnv []string
+	switch challenge {
+	case "http":
+	case "dns":
+		if tCfg.DNSProvider == "" {
+			logFatal("traefik.challenge 'dns' requires traefik.dns_provider (e.g. cloudflare)")
+		}
+		// Keep API tokens out of server.yaml: "CF_DNS_API_TOKEN=${CF_DNS_API_TOKEN}"
+		var err error
+		if dnsEnv, err = expandDNSEnv(tCfg.DNSEnv); err != nil {
+			logFatal("%v", err)
+		}
+	default:
+		logFatal("Invalid traefik.challenge '%s'. Use 'http' or 'dns'.", challenge)
+	}
+	for name, addr := range tCfg.EntryPoints {
+		if name == "web" || name == "websecure" {
+			logFatal("traefik.entrypoints: '%s' is built in and cannot be redefined", name)
+		}
+		hostPort, _, _ := strings.Cut(addr, "/")
+		if _, port, err := net.SplitHostPort(hostPort); err != nil || port == "" {
+			logFatal("traefik.entrypoints.%s: invalid address '%s' (want e.g. ':9100' or ':53/udp')", name, addr)
+		}
+	}
+	for _, ip := range tCfg.TrustedIPs {
+		if _, _, err := net.ParseCIDR(ip); err != nil && net.ParseIP(ip) == nil {
+			logFatal("traefik.trusted_ips: '%s' is neither an IP nor a CIDR", ip)
+		}
+	}
+	if tCfg.ProxyProtocol && len(tCfg.TrustedIPs) == 0 {
+		logFatal("traefik.proxy_protocol requires traefik.trusted_ips")
+	}
+
 	data := TraefikTemplateData{
 		TraefikConfig: TraefikConfig{
 			Version:      tCfg.Version,
@@ -95,12 +371,19 @@ func provisionTraefik(env Environment, tCfg TraefikStack) {
 			Dashboard:    tCfg.Dashboard,
 			NetworkName:  netName,
 			CertResolver: "myresolver", // Hardcoded standard
+			Challenge:    challenge,
+			DNSProvider:  tCfg.DNSProvider,
+			DNSEnv:       dnsEnv,
+			EntryPoints:  tCfg.EntryPoints,
+
+			TrustedIPs:    tCfg.TrustedIPs,
+			ProxyProtocol: tCfg.ProxyProtocol,
 		},
 		HostUID: "0", // Infrastructure usually runs as root/podman
 	}
 	// We might need to check if user is root vs non-root for UID.
 	// For now assume root or we need to fetch UID.
-	if uid := getCmdOutput("ssh", append(getSSHBaseArgs(env), "id -u")...); uid != "" {
+	if uid := remoteUID(env); uid != "" {
 		data.HostUID = uid
 	}
 
@@ -118,7 +401,20 @@ func provisionTraefik(env Environment, tCfg TraefikStack) {
 	runSSH(env, "mkdir -p ~/traefik/dynamic_conf ~/traefik/letsencrypt ~/.config/containers/systemd")
 	runSSH(env, "touch ~/traefik/letsencrypt/acme.json && chmod 600 ~/traefik/letsencrypt/acme.json")
 
-	runRsync(env, []string{"build/stack/traefik.yml"}, fmt.Sprintf("%s@%s:~/traefik/", env.User, env.Host))
+	runRsync(env, []string{"build/stack/traefik.yml"}, remotePath(env, "~/traefik/"))
+	if len(dnsEnv) > 0 {
+		uploadDNSEnv(env, dnsEnv)
+	}
+
+	// Shared basic auth behind router.auth (the authelia provider brings its own middleware)
+	if tCfg.Auth.Provider != "authelia" {
+		if len(tCfg.Auth.Users) == 0 {
+			logWarn("traefik.auth.users is empty: routers with 'auth: true' will fail until global-auth@file exists.")
+		} else {
+			genFile("build/stack/global-auth.yml", globalAuthMiddlewareTmpl, tCfg.Auth)
+			runRsync(env, []string{"build/stack/global-auth.yml"}, remotePath(env, "~/traefik/dynamic_conf/"))
+		}
+	}
 
 	// Dashboard Auth (Basic)
 	// logic for dashboard auth... if basic?
@@ -127,20 +423,128 @@ func provisionTraefik(env Environment, tCfg TraefikStack) {
 	// For now, skipping explicit dashboard auth setup to keep "zero-config" promise or add it later.
 
 	runRsync(env, []string{"build/stack/traefik.container", "build/stack/" + netName + ".network"},
-		fmt.Sprintf("%s@%s:~/.config/containers/systemd/", env.User, env.Host))
+		remotePath(env, "~/.config/containers/systemd/"))
 
 	// Reload & Start
 	runSSH(env, "systemctl --user daemon-reload && systemctl --user restart traefik.service")
 }
 
+// autheliaSecrets are the secret files under ~/authelia/secrets the container reads.
+var autheliaSecrets = []string{"jwt", "session", "storage"}
+
+// autheliaSecretScript creates ~/authelia/secrets/<name> (0600) with a random value unless
+// it already has one. It writes to a temp file first, so a failed generation never
+// leaves an empty secret behind.
+func autheliaSecretScript(name string) string {
+	return fmt.Sprintf(`f=~/authelia/secrets/%s; [ -s "$f" ] || { head -c 48 /dev/urandom | base64 | tr -d '\n' > "$f.tmp" && [ -s "$f.tmp" ] && chmod 600 "$f.tmp" && mv "$f.tmp" "$f"; }`, name)
+}
+
 func provisionAuthelia(env Environment, tCfg TraefikStack, aCfg AutheliaConfig) {
 	logInfo("🔐 Provisioning Authelia...")
-	// TODO: Generate authelia configuration.yml, users.yml, and container
-	// For this task, we will just create placeholders or basic setup.
-	logWarn("Authelia provisioning is a placeholder in this milestone.")
+
+	if aCfg.Domain == "" {
+		logFatal("stack.authelia.domain is required when auth.provider is 'authelia'")
+	}
+	if aCfg.Subdomain == "" {
+		aCfg.Subdomain = "auth"
+	}
+	usersFile := aCfg.UsersFile
+	if usersFile == "" {
+		usersFile = "users.yml"
+	}
+	if _, err := os.Stat(usersFile); err != nil && !dryRun {
+		logFatal("Authelia users file '%s' not found locally. See https://www.authelia.com/reference/guides/passwords/", usersFile)
+	}
+
+	netName := tCfg.NetworkName
+	if netName == "" {
+		netName = "traefik-net"
+	}
+	data := AutheliaTemplateData{
+		AutheliaConfig: aCfg,
+		NetworkName:    netName,
+		CertResolver:   "myresolver", // Same convention as provisionTraefik
+	}
+
+	if !dryRun {
+		os.MkdirAll("build/stack/authelia", 0755)
+	}
+	genFile("build/stack/authelia/configuration.yml", autheliaConfigTmpl, data)
+	genFile("build/stack/authelia.container", autheliaContainerTmpl, data)
+	genFile("build/stack/authelia.yml", autheliaMiddlewareTmpl, data)
+
+	// Secrets are generated once on the server and never leave it
+	if err := runSSH(env, "mkdir -p ~/authelia/secrets ~/traefik/dynamic_conf ~/.config/containers/systemd && chmod 700 ~/authelia/secrets"); err != nil {
+		logFatal("Creating the Authelia directories failed: %v", err)
+	}
+	for _, name := range autheliaSecrets {
+		if err := runSSH(env, autheliaSecretScript(name)); err != nil {
+			logFatal("Generating the Authelia %s secret failed, not starting Authelia: %v", name, err)
+		}
+	}
+
+	// Sync
+	runRsync(env, []string{"build/stack/authelia/configuration.yml"}, remotePath(env, "~/authelia/"))
+	runRsync(env, []string{usersFile}, remotePath(env, "~/authelia/users.yml"))
+	runRsync(env, []string{"build/stack/authelia.yml"}, remotePath(env, "~/traefik/dynamic_conf/"))
+	runRsync(env, []string{"build/stack/authelia.container"}, remotePath(env, "~/.config/containers/systemd/"))
+
+	// Reload & Start
+	if err := runSSH(env, "systemctl --user daemon-reload && systemctl --user restart authelia.service"); err != nil {
+		logFatal("Starting Authelia failed: %v", err)
+	}
+	logInfo("   Portal: https://%s.%s (middleware: authelia@file)", aCfg.Subdomain, aCfg.Domain)
 }
 
 func provisionWatchtower(env Environment, wCfg WatchtowerConfig) {
 	logInfo("🔄 Provisioning Watchtower...")
-	// TODO: Watchtower container
+	if wCfg.Schedule == "" {
+		logInfo("   No watchtower.schedule configured, skipping.")
+		return
+	}
+
+	schedule, err := normalizeCronSchedule(wCfg.Schedule)
+	if err != nil {
+		logError("Invalid watchtower.schedule '%s': %v", wCfg.Schedule, err)
+		return
+	}
+
+	data := WatchtowerTemplateData{Schedule: schedule, HostUID: "0"}
+	if uid := remoteUID(env); uid != "" {
+		data.HostUID = uid
+	}
+
+	genFile("build/stack/watchtower.container", watchtowerContainerTmpl, data)
+
+	runSSH(env, "mkdir -p ~/.config/containers/systemd")
+	runRsync(env, []string{"build/stack/watchtower.container"}, remotePath(env, "~/.config/containers/systemd/"))
+
+	// Reload & Start
+	runSSH(env, "systemctl --user daemon-reload && systemctl --user restart watchtower.service")
+}
+
+// normalizeCronSchedule validates a 5- or 6-field cron expression and returns the
+// 6-field form (with seconds) that Watchtower expects.
+func normalizeCronSchedule(schedule string) (string, error) {
+	fields := strings.Fields(schedule)
+	if len(fields) != 5 && len(fields) != 6 {
+		return "", fmt.Errorf("expected 5 or 6 cron fields, got %d", len(fields))
+	}
+	for _, f := range fields {
+		if !cronFieldRe.MatchString(f) {
+			return "", fmt.Errorf("invalid cron field '%s'", f)
+		}
+	}
+	if len(fields) == 5 {
+		fields = append([]string{"0"}, fields...)
+	}
+	return strings.Join(fields, " "), nil
+}
+
+var cronFieldRe = regexp.MustCompile(`^[0-9A-Za-z*/,?#-]+$`)
+
+// remoteUID returns the numeric UID of the SSH user (needed for the podman socket path).
+func remoteUID(env Environment) string {
+	out, _ := sshCommand(context.Background(), env, "id -u").Output()
+	return strings.TrimSpace(string(out))
 }
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeCronSchedule(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "0 4 * * *", want: "0 0 4 * * *"},
+		{in: "30 0 4 * * *", want: "30 0 4 * * *"},
+		{in: "*/15 * * * MON-FRI", want: "0 */15 * * * MON-FRI"},
+		{in: "0 4 * *", wantErr: true},
+		{in: "0 4 * * * * *", wantErr: true},
+		{in: "0 4 * * $(reboot)", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := normalizeCronSchedule(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Expected error for '%s', got '%s'", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for '%s': %v", tt.in, err)
+		}
+		if got != tt.want {
+			t.Errorf("Expected '%s', got '%s'", tt.want, got)
+		}
+	}
+}
+
+// testAcmeCert returns a base64 PEM certificate as Traefik stores it in acme.json.
+func testAcmeCert(t *testing.T, domain string, notAfter time.Time) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: domain},
+		NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestParseAcmeCerts(t *testing.T) {
+	soon := time.Now().Add(5 * 24 * time.Hour).Truncate(time.Second).UTC()
+	later := time.Now().Add(60 * 24 * time.Hour).Truncate(time.Second).UTC()
+	data := fmt.Sprintf(`{"myresolver": {"Account": {}, "Certificates": [
+		{"domain": {"main": "app.example.com"}, "certificate": %q, "key": "x", "Store": "default"},
+		{"domain": {"main": "auth.example.com"}, "certificate": %q, "key": "x", "Store": "default"}
+	]}}`, testAcmeCert(t, "app.example.com", later), testAcmeCert(t, "auth.example.com", soon))
+
+	certs, err := parseAcmeCerts([]byte(data))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(certs) != 2 {
+		t.Fatalf("Expected 2 certs, got %d", len(certs))
+	}
+	if certs[0].Domain != "auth.example.com" || !certs[0].NotAfter.Equal(soon) {
+		t.Errorf("Expected auth.example.com expiring %s first, got %s %s", soon, certs[0].Domain, certs[0].NotAfter)
+	}
+
+	pending := `{"myresolver": {"Certificates": [{"domain": {"main": "new.example.com"}, "certificate": ""}]}}`
+	if certs, err := parseAcmeCerts([]byte(pending)); err != nil || len(certs) != 0 {
+		t.Errorf("Expected pending order to be skipped, got %v, %v", certs, err)
+	}
+	if _, err := parseAcmeCerts([]byte("not json")); err == nil {
+		t.Errorf("Expected error for invalid acme.json")
+	}
+}
+
+func TestStackUnits(t *testing.T) {
+	var cfg ServerConfig
+	if got := fmt.Sprint(stackUnits(cfg)); got != "[traefik]" {
+		t.Errorf("Expected [traefik], got %s", got)
+	}
+	cfg.Stack.Traefik.Auth.Provider = "authelia"
+	cfg.Stack.Watchtower.Schedule = "0 4 * * *"
+	if got := fmt.Sprint(stackUnits(cfg)); got != "[traefik authelia watchtower]" {
+		t.Errorf("Expected [traefik authelia watchtower], got %s", got)
+	}
+}
+
+func TestExpandDNSEnv(t *testing.T) {
+	t.Setenv("DEPLOY_TEST_CF_TOKEN", "s3cret")
+	got, err := expandDNSEnv([]string{"CF_DNS_API_TOKEN=${DEPLOY_TEST_CF_TOKEN}", "CF_ZONE=example.com"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if fmt.Sprint(got) != "[CF_DNS_API_TOKEN=s3cret CF_ZONE=example.com]" {
+		t.Errorf("Expected expanded dns_env, got %v", got)
+	}
+
+	if _, err := expandDNSEnv([]string{"CF_DNS_API_TOKEN=${DEPLOY_TEST_UNSET_VAR}"}); err == nil || !strings.Contains(err.Error(), "DEPLOY_TEST_UNSET_VAR") {
+		t.Errorf("Expected error naming the unset variable, got %v", err)
+	}
+	if _, err := expandDNSEnv([]string{"CF_DNS_API_TOKEN"}); err == nil {
+		t.Error("Expected error for an entry without '='")
+	}
+}
+
+func TestExpiringCerts(t *testing.T) {
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	certs := []AcmeCert{
+		{Domain: "expired.example.com", NotAfter: now.Add(-time.Hour)},
+		{Domain: "soon.example.com", NotAfter: now.Add(13 * 24 * time.Hour)},
+		{Domain: "fine.example.com", NotAfter: now.Add(40 * 24 * time.Hour)},
+	}
+	got := expiringCerts(certs, now, certWarnWindow)
+	if len(got) != 2 || got[0].Domain != "expired.example.com" || got[1].Domain != "soon.example.com" {
+		t.Errorf("Expected expired and soon certs, got %v", got)
+	}
+}
+
+func TestWatchtowerContainerQuotesSchedule(t *testing.T) {
+	schedule, err := normalizeCronSchedule("0 4 * * *")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	out, err := renderTemplate(watchtowerContainerTmpl, WatchtowerTemplateData{Schedule: schedule, HostUID: "1000"})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if !strings.Contains(out, "Environment=\"WATCHTOWER_SCHEDULE=0 0 4 * * *\"\n") {
+		t.Errorf("Expected the whole schedule in one quoted assignment, got:\n%s", out)
+	}
+}
+
+func TestAutheliaSecretScript(t *testing.T) {
+	home := t.TempDir()
+	secrets := filepath.Join(home, "authelia", "secrets")
+	os.MkdirAll(secrets, 0700)
+	run := func() {
+		c := exec.Command("sh", "-c", autheliaSecretScript("jwt"))
+		c.Env = append(os.Environ(), "HOME="+home)
+		if out, err := c.CombinedOutput(); err != nil {
+			t.Fatalf("Script failed: %v\n%s", err, out)
+		}
+	}
+
+	run()
+	path := filepath.Join(secrets, "jwt")
+	first, err := os.ReadFile(path)
+	if err != nil || len(first) < 32 {
+		t.Fatalf("Expected a generated secret, got %q (%v)", first, err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, got %v", info.Mode().Perm())
+	}
+	run()
+	if again, _ := os.ReadFile(path); string(again) != string(first) {
+		t.Error("Expected an existing secret to be kept")
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("Expected no temp file left behind, got %v", err)
+	}
+}
+
+func TestGlobalAuthMiddlewareTemplate(t *testing.T) {
+	out, err := renderTemplate(globalAuthMiddlewareTmpl, AuthConfig{Users: []string{"admin:$2y$05$abc", "ops:$2y$05$def"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := "    global-auth:\n      basicAuth:\n        users:\n          - \"admin:$2y$05$abc\"\n          - \"ops:$2y$05$def\"\n"
+	if !strings.Contains(out, want) {
+		t.Errorf("Expected users block, got:\n%s", out)
+	}
+}
@@ -3,6 +3,14 @@ package main
 type TemplateData struct {
 	Quadlet
 	TargetDir string
+	Requires  []string // Sidecar services the container depends on
+	Pod       string   // Pod quadlet the container joins (pod.enabled)
+}
+
+type PodTemplateData struct {
+	Name    string
+	Network string
+	Ports   []string
 }
 
 type MaintenanceTemplateData struct {
@@ -18,6 +26,17 @@ type TraefikTemplateData struct {
 	HostUID string
 }
 
+type WatchtowerTemplateData struct {
+	Schedule string // 6-field cron (with seconds)
+	HostUID  string
+}
+
+type AutheliaTemplateData struct {
+	AutheliaConfig
+	NetworkName  string
+	CertResolver string
+}
+
 const traefikContainerTmpl = `[Unit]
 Description=Traefik Reverse Proxy
 After=network-online.target
@@ -28,22 +47,45 @@ Image=docker.io/library/traefik:{{ .Version }}
 Network={{ if .NetworkName }}{{ .NetworkName }}{{ else }}traefik-net{{ end }}.network
 PublishPort=80:80
 PublishPort=443:443
+{{- range .PublishPorts }}
+PublishPort={{ . }}
+{{- end }}
 Volume=/run/user/{{ .HostUID }}/podman/podman.sock:/var/run/docker.sock:Z
 Volume=%h/traefik/traefik.yml:/etc/traefik/traefik.yml:ro,Z
 Volume=%h/traefik/dynamic_conf:/etc/traefik/dynamic_conf:ro,Z
 Volume=%h/traefik/letsencrypt:/letsencrypt:Z
+{{- if .DNSEnv }}
+EnvironmentFile=%h/traefik/dns.env
+{{- end }}
 Exec=--configfile=/etc/traefik/traefik.yml
 
 [Install]
 WantedBy=default.target
 `
 
-const traefikYmlTmpl = `api:
+const traefikYmlTmpl = `{{- define "trusted" }}
+{{- if .TrustedIPs }}
+    forwardedHeaders:
+      trustedIPs:
+{{- range .TrustedIPs }}
+        - "{{ . }}"
+{{- end }}
+{{- if .ProxyProtocol }}
+    proxyProtocol:
+      trustedIPs:
+{{- range .TrustedIPs }}
+        - "{{ . }}"
+{{- end }}
+{{- end }}
+{{- end }}
+{{- end -}}
+api:
   dashboard: {{ .Dashboard }}
 
 entryPoints:
   web:
     address: ":80"
+{{- template "trusted" . }}
     http:
       redirections:
         entryPoint:
@@ -51,14 +93,24 @@ entryPoints:
           scheme: https
   websecure:
     address: ":443"
+{{- template "trusted" . }}
+{{- range $name, $addr := .EntryPoints }}
+  {{ $name }}:
+    address: "{{ $addr }}"
+{{- end }}
 
 certificatesResolvers:
   {{ .CertResolver }}:
     acme:
       email: "{{ .Email }}"
       storage: "/letsencrypt/acme.json"
+{{- if eq .Challenge "dns" }}
+      dnsChallenge:
+        provider: {{ .DNSProvider }}
+{{- else }}
       httpChallenge:
         entryPoint: web
+{{- end }}
 
 providers:
   docker:
@@ -87,18 +139,40 @@ const networkTmpl = `[Network]
 Driver=bridge
 `
 
+// One pod per env: it owns the network and the published ports of all its containers.
+const podTemplate = `[Unit]
+Description={{ .Name }} Pod
+
+[Pod]
+PodName={{ .Name }}
+{{- if .Network }}
+Network={{ .Network }}
+{{- end }}
+{{- range .Ports }}
+PublishPort={{ . }}
+{{- end }}
+
+[Install]
+WantedBy=default.target
+`
+
 const quadletTemplate = `[Unit]
 Description={{ if .Description }}{{ .Description }}{{ else }}{{ .ServiceName }} Service{{ end }}
-Requires=traefik.service
-After=network-online.target traefik.service
+Requires=traefik.service{{ range .Requires }} {{ . }}.service{{ end }}
+After=network-online.target traefik.service{{ range .Requires }} {{ . }}.service{{ end }}
 Wants=network-online.target
 
 [Container]
 Image={{ .Image }}
+{{- if .ContainerName }}
+ContainerName={{ .ContainerName }}
+{{- end }}
 {{- if .Exec }}
 Exec={{ .Exec }}
 {{- end }}
-{{- if .Network }}
+{{- if .Pod }}
+Pod={{ .Pod }}.pod
+{{- else if .Network }}
 Network={{ .Network }}
 {{- end }}
 {{- if .Timezone }}
@@ -113,10 +187,19 @@ CPUQuota={{ .CPU }}
 {{- if .ReadOnly }}
 ReadOnly=true
 {{- end }}
+{{- range .Tmpfs }}
+Tmpfs={{ . }}
+{{- end }}
 {{- if .HealthCmd }}
 HealthCmd={{ .HealthCmd }}
-HealthInterval=60s
-HealthRetries=3
+HealthInterval={{ or .HealthCmdInterval "60s" }}
+HealthRetries={{ or .HealthCmdRetries 3 }}
+{{- if .HealthCmdTimeout }}
+HealthTimeout={{ .HealthCmdTimeout }}
+{{- end }}
+{{- if .HealthCmdStartPeriod }}
+HealthStartPeriod={{ .HealthCmdStartPeriod }}
+{{- end }}
 {{- end }}
 {{- range .Ports }}
 PublishPort={{ . }}
@@ -127,12 +210,24 @@ Volume={{ . }}
 {{- range .EnvVars }}
 Environment={{ . }}
 {{- end }}
+{{- range .Secrets }}
+Secret={{ .Name }},type=env,target={{ .Target }}
+{{- end }}
 {{- range .PodmanArgs }}
 PodmanArgs={{ . }}
 {{- end }}
 EnvironmentFile={{ .TargetDir }}/.env
 {{- range .Labels }}
-Label="{{ . }}"
+Label="{{ unitEscape . }}"
+{{- end }}
+{{- if .StopTimeout }}
+StopSignal=SIGTERM
+StopTimeout={{ .StopTimeout }}
+
+[Service]
+KillSignal=SIGTERM
+# Leave podman time to stop the container before systemd gives up
+TimeoutStopSec={{ add .StopTimeout 30 }}
 {{- end }}
 
 [Install]
@@ -195,3 +290,95 @@ const maintenanceHtmlTmpl = `<!doctype html>
     </div>
 </article>
 `
+
+// Secrets are generated once on the server (see provisionAuthelia) and passed via *_FILE env vars.
+const autheliaConfigTmpl = `server:
+  address: "tcp://:9091"
+
+log:
+  level: "info"
+
+authentication_backend:
+  file:
+    path: "/config/users.yml"
+
+access_control:
+  default_policy: "one_factor"
+
+session:
+  cookies:
+    - domain: "{{ .Domain }}"
+      authelia_url: "https://{{ .Subdomain }}.{{ .Domain }}"
+
+storage:
+  local:
+    path: "/config/db.sqlite3"
+
+notifier:
+  filesystem:
+    filename: "/config/notification.txt"
+`
+
+const autheliaContainerTmpl = `[Unit]
+Description=Authelia SSO
+Requires=traefik.service
+After=network-online.target traefik.service
+
+[Container]
+Image=docker.io/authelia/authelia:latest
+ContainerName=authelia
+Network={{ .NetworkName }}.network
+Volume=%h/authelia:/config:Z
+Environment=AUTHELIA_IDENTITY_VALIDATION_RESET_PASSWORD_JWT_SECRET_FILE=/config/secrets/jwt
+Environment=AUTHELIA_SESSION_SECRET_FILE=/config/secrets/session
+Environment=AUTHELIA_STORAGE_ENCRYPTION_KEY_FILE=/config/secrets/storage
+Label="traefik.enable=true"
+Label="traefik.http.routers.authelia.rule=Host(` + "`{{ .Subdomain }}.{{ .Domain }}`" + `)"
+Label="traefik.http.routers.authelia.entrypoints=websecure"
+Label="traefik.http.routers.authelia.tls.certresolver={{ .CertResolver }}"
+Label="traefik.http.services.authelia.loadbalancer.server.port=9091"
+
+[Install]
+WantedBy=default.target
+`
+
+// Served by Traefik's file provider; app routers reference it as "authelia@file".
+const autheliaMiddlewareTmpl = `http:
+  middlewares:
+    authelia:
+      forwardAuth:
+        address: "http://authelia:9091/api/authz/forward-auth"
+        trustForwardHeader: true
+        authResponseHeaders:
+          - "Remote-User"
+          - "Remote-Groups"
+          - "Remote-Email"
+          - "Remote-Name"
+`
+
+// Shared basic auth for router.auth with provider "basic"; app routers reference it as "global-auth@file".
+const globalAuthMiddlewareTmpl = `http:
+  middlewares:
+    global-auth:
+      basicAuth:
+        users:
+{{- range .Users }}
+          - "{{ . }}"
+{{- end }}
+`
+
+const watchtowerContainerTmpl = `[Unit]
+Description=Watchtower Image Updater
+After=network-online.target
+Wants=network-online.target
+
+[Container]
+Image=docker.io/containrrr/watchtower:latest
+Volume=/run/user/{{ .HostUID }}/podman/podman.sock:/var/run/docker.sock:Z
+# Quoted: systemd splits unquoted Environment= values on whitespace
+Environment="WATCHTOWER_SCHEDULE={{ unitEscape .Schedule }}"
+Environment=WATCHTOWER_CLEANUP=true
+
+[Install]
+WantedBy=default.target
+`
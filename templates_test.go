@@ -0,0 +1,261 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTraefikYmlChallenge(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     TraefikConfig
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "HTTP Challenge",
+			cfg:     TraefikConfig{Email: "a@b.c", CertResolver: "myresolver", Challenge: "http"},
+			want:    []string{"  myresolver:\n    acme:", "      httpChallenge:\n        entryPoint: web"},
+			notWant: []string{"dnsChallenge"},
+		},
+		{
+			name:    "DNS Challenge",
+			cfg:     TraefikConfig{Email: "a@b.c", CertResolver: "myresolver", Challenge: "dns", DNSProvider: "cloudflare"},
+			want:    []string{"      dnsChallenge:\n        provider: cloudflare"},
+			notWant: []string{"httpChallenge"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := renderTemplate(traefikYmlTmpl, TraefikTemplateData{TraefikConfig: tt.cfg})
+			if err != nil {
+				t.Fatalf("Render failed: %v", err)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(out, w) {
+					t.Errorf("Expected %q in:\n%s", w, out)
+				}
+			}
+			for _, nw := range tt.notWant {
+				if strings.Contains(out, nw) {
+					t.Errorf("Did not expect %q in:\n%s", nw, out)
+				}
+			}
+		})
+	}
+}
+
+func TestTraefikContainerDNSEnv(t *testing.T) {
+	data := TraefikTemplateData{
+		TraefikConfig: TraefikConfig{Version: "v3.0", Challenge: "dns", DNSEnv: []string{"CF_DNS_API_TOKEN=secret"}},
+		HostUID:       "1000",
+	}
+	out, err := renderTemplate(traefikContainerTmpl, data)
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if !strings.Contains(out, "EnvironmentFile=%h/traefik/dns.env\n") {
+		t.Errorf("Expected the DNS env file in container, got:\n%s", out)
+	}
+	if strings.Contains(out, "secret") {
+		t.Errorf("Expected no credentials in the unit, got:\n%s", out)
+	}
+
+	data.DNSEnv = nil
+	out, _ = renderTemplate(traefikContainerTmpl, data)
+	if strings.Contains(out, "Environment") {
+		t.Errorf("Expected no EnvironmentFile= without dns_env, got:\n%s", out)
+	}
+}
+
+func TestAutheliaTemplates(t *testing.T) {
+	data := AutheliaTemplateData{
+		AutheliaConfig: AutheliaConfig{Subdomain: "auth", Domain: "example.com"},
+		NetworkName:    "edge-net",
+		CertResolver:   "myresolver",
+	}
+	for _, tt := range []struct {
+		tmpl string
+		want []string
+	}{
+		{autheliaConfigTmpl, []string{
+			`- domain: "example.com"`,
+			`authelia_url: "https://auth.example.com"`,
+			`path: "/config/users.yml"`,
+		}},
+		{autheliaContainerTmpl, []string{
+			"Network=edge-net.network\n",
+			"Environment=AUTHELIA_SESSION_SECRET_FILE=/config/secrets/session\n",
+			"Environment=AUTHELIA_STORAGE_ENCRYPTION_KEY_FILE=/config/secrets/storage\n",
+			"Environment=AUTHELIA_IDENTITY_VALIDATION_RESET_PASSWORD_JWT_SECRET_FILE=/config/secrets/jwt\n",
+			"Label=\"traefik.http.routers.authelia.rule=Host(`auth.example.com`)\"",
+			"Label=\"traefik.http.routers.authelia.tls.certresolver=myresolver\"",
+		}},
+		{autheliaMiddlewareTmpl, []string{
+			`address: "http://authelia:9091/api/authz/forward-auth"`,
+			`- "Remote-User"`,
+		}},
+	} {
+		out, err := renderTemplate(tt.tmpl, data)
+		if err != nil {
+			t.Fatalf("Render failed: %v", err)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(out, want) {
+				t.Errorf("Expected %q in:\n%s", want, out)
+			}
+		}
+	}
+}
+
+func TestTraefikEntryPoints(t *testing.T) {
+	data := TraefikTemplateData{TraefikConfig: TraefikConfig{Version: "v3.0", CertResolver: "myresolver"}, HostUID: "1000"}
+
+	yml, _ := renderTemplate(traefikYmlTmpl, data)
+	container, _ := renderTemplate(traefikContainerTmpl, data)
+	if strings.Count(yml, "address:") != 2 || strings.Count(container, "PublishPort=") != 2 {
+		t.Errorf("Expected only web/websecure by default, got:\n%s\n%s", yml, container)
+	}
+
+	data.EntryPoints = map[string]string{"metrics": ":9100", "dns": ":53/udp", "admin": "127.0.0.1:8443"}
+	yml, _ = renderTemplate(traefikYmlTmpl, data)
+	container, _ = renderTemplate(traefikContainerTmpl, data)
+	for _, want := range []string{"  metrics:\n    address: \":9100\"", "  dns:\n    address: \":53/udp\"", "  admin:\n    address: \"127.0.0.1:8443\""} {
+		if !strings.Contains(yml, want) {
+			t.Errorf("Expected %q in:\n%s", want, yml)
+		}
+	}
+	for _, want := range []string{"PublishPort=9100:9100\n", "PublishPort=53:53/udp\n", "PublishPort=127.0.0.1:8443:8443\n"} {
+		if !strings.Contains(container, want) {
+			t.Errorf("Expected %q in:\n%s", want, container)
+		}
+	}
+}
+
+func TestTraefikTrustedIPs(t *testing.T) {
+	data := TraefikTemplateData{TraefikConfig: TraefikConfig{CertResolver: "myresolver"}}
+	yml, _ := renderTemplate(traefikYmlTmpl, data)
+	if strings.Contains(yml, "forwardedHeaders") || strings.Contains(yml, "proxyProtocol") {
+		t.Errorf("Expected no trusted IPs by default, got:\n%s", yml)
+	}
+
+	data.TrustedIPs = []string{"10.0.0.0/8", "203.0.113.7"}
+	yml, err := renderTemplate(traefikYmlTmpl, data)
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	block := "    forwardedHeaders:\n      trustedIPs:\n        - \"10.0.0.0/8\"\n        - \"203.0.113.7\"\n"
+	if strings.Count(yml, block) != 2 {
+		t.Errorf("Expected forwardedHeaders on web and websecure, got:\n%s", yml)
+	}
+	if !strings.HasPrefix(yml, "api:") || strings.Contains(yml, "proxyProtocol") {
+		t.Errorf("Expected plain forwarded headers without proxyProtocol, got:\n%s", yml)
+	}
+
+	data.ProxyProtocol = true
+	yml, _ = renderTemplate(traefikYmlTmpl, data)
+	if strings.Count(yml, "    proxyProtocol:\n      trustedIPs:\n        - \"10.0.0.0/8\"") != 2 {
+		t.Errorf("Expected proxyProtocol on web and websecure, got:\n%s", yml)
+	}
+}
+
+func TestQuadletStopTimeout(t *testing.T) {
+	out, err := renderTemplate(quadletTemplate, TemplateData{Quadlet: Quadlet{ServiceName: "app", Image: "app:latest", StopTimeout: 25}})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	for _, want := range []string{"StopSignal=SIGTERM\nStopTimeout=25\n", "[Service]\nKillSignal=SIGTERM\n", "TimeoutStopSec=55\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in:\n%s", want, out)
+		}
+	}
+
+	out, _ = renderTemplate(quadletTemplate, TemplateData{Quadlet: Quadlet{ServiceName: "app", Image: "app:latest"}})
+	if strings.Contains(out, "StopTimeout=") || strings.Contains(out, "[Service]") {
+		t.Errorf("Expected podman defaults without stop_timeout_seconds, got:\n%s", out)
+	}
+}
+
+func TestQuadletPodmanSecrets(t *testing.T) {
+	q := Quadlet{ServiceName: "app", Image: "app:latest", Secrets: []PodmanSecret{{Name: "db_password"}, {Name: "stripe", Env: "STRIPE_API_KEY"}}}
+	out, err := renderTemplate(quadletTemplate, TemplateData{Quadlet: q})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	for _, want := range []string{"Secret=db_password,type=env,target=DB_PASSWORD\n", "Secret=stripe,type=env,target=STRIPE_API_KEY\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in:\n%s", want, out)
+		}
+	}
+}
+
+func TestQuadletTmpfs(t *testing.T) {
+	q := Quadlet{ServiceName: "app", Image: "app:latest", ReadOnly: true, Tmpfs: []string{"/tmp", "/run:size=16m"}}
+	out, err := renderTemplate(quadletTemplate, TemplateData{Quadlet: q})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if !strings.Contains(out, "ReadOnly=true\nTmpfs=/tmp\nTmpfs=/run:size=16m\n") {
+		t.Errorf("Expected Tmpfs lines after ReadOnly in:\n%s", out)
+	}
+}
+
+func TestQuadletHealthCmd(t *testing.T) {
+	q := Quadlet{ServiceName: "app", Image: "app:latest", HealthCmd: "/app -health"}
+	out, err := renderTemplate(quadletTemplate, TemplateData{Quadlet: q})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if !strings.Contains(out, "HealthCmd=/app -health\nHealthInterval=60s\nHealthRetries=3\n") {
+		t.Errorf("Expected default health directives in:\n%s", out)
+	}
+	if strings.Contains(out, "HealthTimeout=") || strings.Contains(out, "HealthStartPeriod=") {
+		t.Errorf("Expected no timeout/start period by default, got:\n%s", out)
+	}
+
+	q.HealthCmdInterval, q.HealthCmdRetries, q.HealthCmdTimeout, q.HealthCmdStartPeriod = "5s", 2, "3s", "20s"
+	out, _ = renderTemplate(quadletTemplate, TemplateData{Quadlet: q})
+	if !strings.Contains(out, "HealthInterval=5s\nHealthRetries=2\nHealthTimeout=3s\nHealthStartPeriod=20s\n") {
+		t.Errorf("Expected custom health directives in:\n%s", out)
+	}
+}
+
+// unitUnquote reverses the quoting of a Label="..." line the way quadlet reads it.
+func unitUnquote(line string) string {
+	v := strings.TrimSuffix(strings.TrimPrefix(line, `Label="`), `"`)
+	return strings.NewReplacer(`\\`, `\`, `\"`, `"`, "%%", "%").Replace(v)
+}
+
+func TestQuadletLabelEscaping(t *testing.T) {
+	r := RouterConfig{
+		Rule:        "Host(`a.com`) || Host(`b.com`)",
+		RedirectWWW: "to_apex",
+		Domain:      "a.com",
+		Headers:     map[string]string{"X-Welcome": "hello world, 100% \"quoted\""},
+	}
+	labels := generateTraefikLabels("app", r, "resolver", "")
+	out, err := renderTemplate(quadletTemplate, TemplateData{Quadlet: Quadlet{ServiceName: "app", Image: "app:latest", Labels: labels}})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+
+	var got []string
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "Label=") {
+			got = append(got, unitUnquote(line))
+		}
+	}
+	if strings.Join(got, "\n") != strings.Join(labels, "\n") {
+		t.Errorf("Expected labels to survive quoting:\n%s\ngot:\n%s", strings.Join(labels, "\n"), strings.Join(got, "\n"))
+	}
+	for _, want := range []string{
+		"Label=\"traefik.http.routers.app.rule=Host(`a.com`) || Host(`b.com`)\"\n",
+		`Label="traefik.http.middlewares.app-headers.headers.customrequestheaders.X-Welcome=hello world, 100%% \"quoted\""` + "\n",
+		`Label="traefik.http.middlewares.app-www-redirect.redirectregex.regex=^https?://www\\.a\\.com/(.*)"` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in:\n%s", want, out)
+		}
+	}
+}
@@ -3,18 +3,27 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"text/template"
+	"time"
+
+	"golang.org/x/term"
 )
 
-const (
+// Colors are variables so --no-color (or a non-TTY stdout) can blank them.
+var (
 	Reset  = "\033[0m"
 	Red    = "\033[31m"
 	Green  = "\033[32m"
@@ -23,21 +32,124 @@ const (
 	Gray   = "\033[37m"
 )
 
-func logFatal(f string, a ...any)   { fmt.Printf(Red+"[FATAL] "+Reset+f+"\n", a...); os.Exit(1) }
-func logInfo(f string, a ...any)    { fmt.Printf(Blue+"[INFO] "+Reset+f+"\n", a...) }
-func logSuccess(f string, a ...any) { fmt.Printf(Green+"[DONE] "+Reset+f+"\n", a...) }
-func logWarn(f string, a ...any)    { fmt.Printf(Yellow+"[WARN] "+Reset+f+"\n", a...) }
-func logError(f string, a ...any)   { fmt.Printf(Red+"[ERR] "+Reset+f+"\n", a...) }
+// disableColors strips ANSI codes from all output.
+func disableColors() {
+	Reset, Red, Green, Yellow, Blue, Gray = "", "", "", "", "", ""
+}
+
+// setupLogging applies --no-color and --log-json; colors are also dropped when stdout
+// is not a terminal (CI, pipes, files).
+func setupLogging(noColor, jsonLogs bool) {
+	logJSON = jsonLogs
+	if noColor || jsonLogs || os.Getenv("NO_COLOR") != "" || !term.IsTerminal(int(os.Stdout.Fd())) {
+		disableColors()
+	}
+}
+
+// logOut receives all log* output; --log-file tees it into a file.
+var logOut io.Writer = os.Stdout
+
+var ansiCode = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// ansiStripper removes color codes before writing to w.
+type ansiStripper struct{ w io.Writer }
+
+func (s ansiStripper) Write(p []byte) (int, error) {
+	if _, err := s.w.Write(ansiCode.ReplaceAll(p, nil)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// openLogFile appends all log output (uncolored) to path in addition to stdout.
+// The file is appended to, not rotated, so repeated cron runs build one history;
+// each run starts with a header line.
+func openLogFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(f, "=== %s deploy %s ===\n", time.Now().Format(time.RFC3339), strings.Join(os.Args[1:], " "))
+	logOut = io.MultiWriter(os.Stdout, ansiStripper{f})
+	return nil
+}
+
+// logJSON switches the log* helpers to one JSON object per line (--log-json).
+var logJSON bool
+
+// logEntry is the --log-json line format.
+type logEntry struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+// logLine prints one log line as "<color>[TAG] <reset>message" or as JSON.
+func logLine(level, prefix, f string, a ...any) {
+	msg := fmt.Sprintf(f, a...)
+	if logJSON {
+		line, _ := json.Marshal(logEntry{Time: time.Now().UTC().Format(time.RFC3339), Level: level, Message: msg})
+		fmt.Fprintln(logOut, string(line))
+		return
+	}
+	fmt.Fprintln(logOut, prefix+msg)
+}
+
+func logFatal(f string, a ...any) {
+	logLine("fatal", Red+"[FATAL] "+Reset, f, a...)
+	runExitHooks()
+	os.Exit(1)
+}
+func logInfo(f string, a ...any)    { logLine("info", Blue+"[INFO] "+Reset, f, a...) }
+func logSuccess(f string, a ...any) { logLine("success", Green+"[DONE] "+Reset, f, a...) }
+func logWarn(f string, a ...any)    { logLine("warn", Yellow+"[WARN] "+Reset, f, a...) }
+func logError(f string, a ...any)   { logLine("error", Red+"[ERR] "+Reset, f, a...) }
 func logDebug(f string, a ...any) {
 	if verbose {
-		fmt.Printf(Gray+f+Reset+"\n", a...)
+		if logJSON {
+			logLine("debug", "", f, a...)
+			return
+		}
+		fmt.Fprintf(logOut, Gray+f+Reset+"\n", a...)
+	}
+}
+
+// exitHooks run before logFatal exits, since os.Exit skips deferred calls.
+var exitHooks []func()
+
+var exitHooksMu sync.Mutex
+
+// onExit registers fn to run if the process dies via logFatal.
+func onExit(fn func()) {
+	exitHooksMu.Lock()
+	defer exitHooksMu.Unlock()
+	exitHooks = append(exitHooks, fn)
+}
+
+func runExitHooks() {
+	exitHooksMu.Lock()
+	hooks := exitHooks
+	exitHooks = nil // Prevent re-entry if a hook itself calls logFatal
+	exitHooksMu.Unlock()
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i]()
 	}
 }
 
+// promptMu serializes interactive prompts when several environments release concurrently.
+var promptMu sync.Mutex
+
 func confirm(prompt string) bool {
 	if dryRun {
 		return true
 	}
+	promptMu.Lock()
+	defer promptMu.Unlock()
+	if assumeYes {
+		// Still print the prompt so logs show what was auto-approved
+		fmt.Fprintf(logOut, "%s [y/N]: y (auto-confirmed via --yes)\n", prompt)
+		return true
+	}
 	fmt.Printf("%s [y/N]: ", prompt)
 	r := bufio.NewReader(os.Stdin)
 	res, _ := r.ReadString('\n')
@@ -51,6 +163,11 @@ func prompt(label string) string {
 	return strings.TrimSpace(res)
 }
 
+// shellQuote wraps s in single quotes for safe use in a remote shell command.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
 func getCmdOutput(name string, args ...string) string {
 	out, _ := exec.Command(name, args...).Output()
 	return strings.TrimSpace(string(out))
@@ -60,10 +177,30 @@ func genFile(path string, tmplStr string, data any) {
 	if dryRun {
 		return
 	}
-	t, _ := template.New("t").Parse(tmplStr)
-	f, _ := os.Create(path)
-	defer f.Close()
-	t.Execute(f, data)
+	out, err := renderTemplate(tmplStr, data)
+	if err != nil {
+		logFatal("Template error (%s): %v", path, err)
+	}
+	os.WriteFile(path, []byte(out), 0644)
+}
+
+// unitEscape escapes s for use inside a double-quoted quadlet value (Label="..."):
+// backslashes and quotes are C-escaped, '%' is doubled so it is not read as a specifier.
+// Backticks, '=', '||', commas and spaces need no escaping inside the quotes.
+func unitEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%").Replace(s)
+}
+
+func renderTemplate(tmplStr string, data any) (string, error) {
+	t, err := template.New("t").Funcs(template.FuncMap{"join": strings.Join, "add": func(a, b int) int { return a + b }, "unitEscape": unitEscape}).Parse(tmplStr)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
 }
 
 func copyFile(src, dst string) error {
@@ -92,6 +229,15 @@ func runCommand(desc string, cmd *exec.Cmd) error {
 		logDebug("[EXEC] %s", cmd.String())
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
+	} else if streamOutput {
+		// Show progress live, but keep stderr so the error still explains itself
+		var errBuf bytes.Buffer
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = io.MultiWriter(os.Stderr, &errBuf)
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s failed: %s\nSTDERR:\n%s", desc, err, errBuf.String())
+		}
+		return nil
 	} else {
 		var outBuf, errBuf bytes.Buffer
 		cmd.Stdout = &outBuf
@@ -105,55 +251,273 @@ func runCommand(desc string, cmd *exec.Cmd) error {
 }
 
 func runCommandRaw(name string, args ...string) error {
+	return runCommandRawContext(context.Background(), name, args...)
+}
+
+func runCommandRawContext(ctx context.Context, name string, args ...string) error {
 	if dryRun {
-		fmt.Printf("[DRY] %s %v\n", name, args)
+		fmt.Fprintf(logOut, "[DRY] %s %v\n", name, args)
 		return nil
 	}
-	cmd := exec.Command(name, args...)
+	cmd := commandContext(ctx, name, args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
 }
 
+// diffLines is a small LCS line diff: unchanged lines are prefixed with "  ",
+// removed with "- " and added with "+ ". Good enough for unit files.
+func diffLines(oldText, newText string) []string {
+	a := strings.Split(strings.TrimSuffix(oldText, "\n"), "\n")
+	b := strings.Split(strings.TrimSuffix(newText, "\n"), "\n")
+
+	// lcs[i][j] = length of the LCS of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var out []string
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			out = append(out, "  "+a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, "- "+a[i])
+			i++
+		default:
+			out = append(out, "+ "+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		out = append(out, "- "+a[i])
+	}
+	for ; j < len(b); j++ {
+		out = append(out, "+ "+b[j])
+	}
+	return out
+}
+
 // --- SSH & Rsync with Multiplexing ---
 
-func getSSHBaseArgs(env Environment) []string {
+// defaultSSHTimeout is the ConnectTimeout (seconds) when ssh_connect_timeout is unset.
+const defaultSSHTimeout = 10
+
+// multiplexed reports whether ssh shares a master connection: on unless ssh_multiplex
+// is false or --no-multiplex is given.
+func multiplexed(env Environment) bool {
+	return !noMultiplex && (env.SSHMultiplex == nil || *env.SSHMultiplex)
+}
+
+// controlPath is the ControlMaster socket of env's user@host.
+func controlPath(env Environment) string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("deploy-%s-%s", env.User, env.Host))
+}
+
+// controlMasters holds the control sockets this run has used (socket -> env).
+var (
+	controlMasters   sync.Map
+	closeMastersOnce sync.Once
+)
+
+// trackControlMaster remembers env's socket for the exit hook. The hook is registered on
+// first use, so it runs after the other hooks' ssh cleanup.
+func trackControlMaster(env Environment) {
+	if !multiplexed(env) {
+		return
+	}
+	if _, loaded := controlMasters.LoadOrStore(controlPath(env), env); !loaded {
+		closeMastersOnce.Do(func() { onExit(cleanupControlMasters) })
+	}
+}
+
+// cleanupControlMasters removes this run's sockets whose master died with it, so the next
+// run does not attach to them. Live masters are shared by every deploy of that user@host
+// (another terminal's release or logs -f), so they are left to ControlPersist.
+func cleanupControlMasters() {
+	controlMasters.Range(func(socket, _ any) bool {
+		cleanupControlSocket(socket.(string), false)
+		return true
+	})
+}
+
+// controlCommand runs 'ssh -O <op>' against socket; the destination is required but unused.
+func controlCommand(op, socket string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return exec.CommandContext(ctx, "ssh", "-O", op, "-o", "ControlPath="+socket, "deploy-control").Run()
+}
+
+// closeControlMaster asks the master behind socket to exit and removes the socket if
+// it is still there. It reports whether one existed.
+func closeControlMaster(socket string) bool {
+	if _, err := os.Lstat(socket); err != nil {
+		return false
+	}
+	controlCommand("exit", socket)
+	os.Remove(socket)
+	return true
+}
+
+// cleanupControlSocket removes socket if its master is dead (a stale socket from a killed
+// run). A live master may serve another deploy, so it is only closed with force.
+// It returns what happened: "", "stale", "kept" or "closed".
+func cleanupControlSocket(socket string, force bool) string {
+	if _, err := os.Lstat(socket); err != nil {
+		return ""
+	}
+	if controlCommand("check", socket) != nil {
+		os.Remove(socket)
+		return "stale"
+	}
+	if !force {
+		return "kept"
+	}
+	closeControlMaster(socket)
+	return "closed"
+}
+
+// sshOptions are the connection options shared by ssh and rsync's -e.
+// The control socket lives on the local side, so multiplexing also works through a jump host.
+func sshOptions(env Environment) []string {
 	args := []string{}
 	// SSH Multiplexing for performance
-	socketPath := filepath.Join(os.TempDir(), fmt.Sprintf("deploy-%s-%s", env.User, env.Host))
+	if multiplexed(env) {
+		args = append(args, "-o", "ControlMaster=auto")
+		args = append(args, "-o", "ControlPersist=5m")
+		args = append(args, "-o", fmt.Sprintf("ControlPath=%s", controlPath(env)))
+	}
 
-	args = append(args, "-o", "ControlMaster=auto")
-	args = append(args, "-o", "ControlPersist=5m")
-	args = append(args, "-o", fmt.Sprintf("ControlPath=%s", socketPath))
+	// Fail fast on dead hosts instead of hanging
+	timeout := env.SSHTimeout
+	if timeout <= 0 {
+		timeout = defaultSSHTimeout
+	}
+	args = append(args, "-o", fmt.Sprintf("ConnectTimeout=%d", timeout))
 
 	if env.SSHKey != "" {
+		// Never fall back to a password prompt in non-interactive runs
+		args = append(args, "-o", "BatchMode=yes")
 		args = append(args, "-i", env.SSHKey)
 	}
-	args = append(args, "-p", fmt.Sprintf("%d", env.Port))
-	args = append(args, fmt.Sprintf("%s@%s", env.User, env.Host))
+	if env.JumpHost != "" {
+		args = append(args, "-J", env.JumpHost)
+	}
+	if env.SSHProxyCommand != "" {
+		args = append(args, "-o", "ProxyCommand="+env.SSHProxyCommand)
+	}
+	if env.Port != 0 {
+		args = append(args, "-p", fmt.Sprintf("%d", env.Port))
+	}
 	return args
 }
 
+// cancelWaitDelay bounds how long a killed command may hold its pipes open (e.g. ssh's children).
+const cancelWaitDelay = 5 * time.Second
+
+// commandContext is exec.CommandContext with a WaitDelay, so a cancelled command never hangs Wait.
+func commandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	c := exec.CommandContext(ctx, name, args...)
+	c.WaitDelay = cancelWaitDelay
+	return c
+}
+
+// sshCommand is the ssh command running args on env. Every ssh starts here, so this is
+// where the control socket is tracked for cleanup.
+func sshCommand(ctx context.Context, env Environment, args ...string) *exec.Cmd {
+	trackControlMaster(env)
+	return commandContext(ctx, "ssh", append(getSSHBaseArgs(env), args...)...)
+}
+
+func getSSHBaseArgs(env Environment) []string {
+	return append(sshOptions(env), fmt.Sprintf("%s@%s", env.User, sshHost(env.Host)))
+}
+
+// sshHost brackets literal IPv6 addresses ("2001:db8::1" -> "[2001:db8::1]"), which rsync
+// needs to tell the host from the path. ssh strips the brackets itself.
+func sshHost(host string) string {
+	if ip := net.ParseIP(host); ip != nil && strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// remotePath formats an rsync/scp remote location: user@host:path.
+func remotePath(env Environment, path string) string {
+	return fmt.Sprintf("%s@%s:%s", env.User, sshHost(env.Host), path)
+}
+
+// requireRemoteTools returns a shell snippet that fails with "<tool> not found on remote"
+// for the first missing tool. Shared by release, db and doctor checks.
+func requireRemoteTools(tools ...string) string {
+	return fmt.Sprintf(`for t in %s; do command -v "$t" >/dev/null 2>&1 || { echo "$t not found on remote" >&2; exit 1; }; done`, strings.Join(tools, " "))
+}
+
 func runSSH(env Environment, cmd string) error {
-	args := getSSHBaseArgs(env)
-	args = append(args, cmd)
+	return runSSHContext(context.Background(), env, cmd)
+}
 
+// runSSHContext is runSSH, killed when ctx ends (release --timeout).
+func runSSHContext(ctx context.Context, env Environment, cmd string) error {
 	if dryRun {
 		logDebug("[SSH] %s", cmd)
 		return nil
 	}
-	c := exec.Command("ssh", args...)
-	return runCommand("SSH", c)
+	return runCommand("SSH", sshCommand(ctx, env, cmd))
+}
+
+// runSSHOutput runs cmd remotely and returns its trimmed stdout.
+// Unlike runSSH it also executes in dry-run, so only use it for read-only queries.
+func runSSHOutput(env Environment, cmd string) (string, error) {
+	return runSSHOutputContext(context.Background(), env, cmd)
+}
+
+func runSSHOutputContext(ctx context.Context, env Environment, cmd string) (string, error) {
+	logDebug("[SSH-QUERY] %s", cmd)
+
+	var errBuf bytes.Buffer
+	c := sshCommand(ctx, env, cmd)
+	c.Stderr = &errBuf
+	out, err := c.Output()
+	if err != nil {
+		return strings.TrimSpace(string(out)), fmt.Errorf("%v: %s", err, strings.TrimSpace(errBuf.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// runSSHCapture runs a (possibly mutating) command and returns its stdout.
+// Like runSSH it is skipped in dry-run and returns "".
+func runSSHCapture(env Environment, cmd string) (string, error) {
+	if dryRun {
+		logDebug("[SSH] %s", cmd)
+		return "", nil
+	}
+	return runSSHOutput(env, cmd)
 }
 
 func runSSHStream(env Environment, cmd string) error {
-	args := getSSHBaseArgs(env)
-	args = append(args, cmd)
+	return runSSHStreamContext(context.Background(), env, cmd)
+}
+
+func runSSHStreamContext(ctx context.Context, env Environment, cmd string) error {
 	if dryRun {
 		logDebug("[SSH-STREAM] %s", cmd)
 		return nil
 	}
-	c := exec.Command("ssh", args...)
+	c := sshCommand(ctx, env, cmd)
 	c.Stdout = os.Stdout
 	c.Stderr = os.Stderr
 	return c.Run()
@@ -166,44 +530,147 @@ func runRsync(env Environment, sources []string, dest string, extraArgs ...strin
 }
 
 func runRsyncSafe(env Environment, sources []string, dest string, extraArgs ...string) error {
-	args := []string{"-avz"}
+	return runRsyncContext(context.Background(), env, sources, dest, extraArgs...)
+}
+
+// runRsyncContext is runRsyncSafe, killed when ctx ends.
+func runRsyncContext(ctx context.Context, env Environment, sources []string, dest string, extraArgs ...string) error {
+	trackControlMaster(env)
+	return runCommandRawContext(ctx, "rsync", buildRsyncArgs(env, sources, dest, extraArgs...)...)
+}
 
-	sshCmd := "ssh"
-	// Reuse multiplexed socket
-	socketPath := filepath.Join(os.TempDir(), fmt.Sprintf("deploy-%s-%s", env.User, env.Host))
-	sshCmd += fmt.Sprintf(" -o ControlMaster=auto -o ControlPath=%s -o ControlPersist=5m", socketPath)
+// buildRsyncArgs assembles the full rsync argument list (flags, ssh transport, sources, dest).
+func buildRsyncArgs(env Environment, sources []string, dest string, extraArgs ...string) []string {
+	args := []string{"-avz"}
 
-	needsE := false
-	if env.Port != 0 && env.Port != 22 {
-		sshCmd += fmt.Sprintf(" -p %d", env.Port)
-		needsE = true
+	// Same options as plain ssh, so the multiplexed socket is reused
+	sshCmd := []string{"ssh"}
+	for _, o := range sshOptions(env) {
+		if strings.ContainsAny(o, " \t'\"") {
+			o = shellQuote(o)
+		}
+		sshCmd = append(sshCmd, o)
 	}
-	if env.SSHKey != "" {
-		sshCmd += fmt.Sprintf(" -i %s", env.SSHKey)
-		needsE = true
+	args = append(args, "-e", strings.Join(sshCmd, " "))
+	if rsyncBwLimit > 0 {
+		args = append(args, fmt.Sprintf("--bwlimit=%d", rsyncBwLimit))
 	}
-	if needsE || true { // Always valid to pass -e
-		args = append(args, "-e", sshCmd)
+	if rsyncProgress {
+		args = append(args, "--info=progress2")
 	}
+	args = append(args, env.RsyncArgs...)
 
 	args = append(args, extraArgs...)
 	args = append(args, sources...)
 	args = append(args, dest)
+	return args
+}
+
+// checkRsyncArgs rejects rsync_args that would override the managed ssh transport (-e)
+// or the --delete handling of the artifact sync.
+func checkRsyncArgs(args []string) error {
+	for _, a := range args {
+		name, _, _ := strings.Cut(a, "=")
+		short := strings.HasPrefix(a, "-") && !strings.HasPrefix(a, "--")
+		if (short && strings.Contains(a, "e")) || name == "--rsh" || name == "--del" || strings.HasPrefix(name, "--delete") {
+			return fmt.Errorf("rsync_args '%s' conflicts with the managed -e/--delete flags", a)
+		}
+	}
+	return nil
+}
+
+// rsyncExcludeArgs converts artifact exclude patterns into rsync --exclude flags.
+func rsyncExcludeArgs(patterns []string) []string {
+	var args []string
+	for _, p := range patterns {
+		if p == "" {
+			continue
+		}
+		args = append(args, "--exclude="+p)
+	}
+	return args
+}
+
+// githubAPIURL and githubRetryDelay are variables so tests can point them at a local server.
+var (
+	githubAPIURL     = "https://api.github.com"
+	githubRetryDelay = time.Second
+)
+
+// GitHubError is returned by fetchLatestGitHubRelease. RateLimited distinguishes an
+// exhausted API quota (set GITHUB_TOKEN) from network or server failures.
+type GitHubError struct {
+	RateLimited bool
+	Err         error
+}
 
-	return runCommandRaw("rsync", args...)
+func (e *GitHubError) Error() string {
+	if e.RateLimited {
+		return fmt.Sprintf("GitHub API rate limit exceeded (set GITHUB_TOKEN to raise it): %v", e.Err)
+	}
+	return fmt.Sprintf("GitHub API request failed: %v", e.Err)
 }
 
+func (e *GitHubError) Unwrap() error { return e.Err }
+
+// fetchLatestGitHubRelease returns the tag of repo's latest release. Transient failures are
+// retried 3 times with exponential backoff; a rate limit is returned at once.
 func fetchLatestGitHubRelease(repo string) (string, error) {
-	resp, err := http.Get(fmt.Sprintf("https://api.github.com/repos/%s/releases/latest", repo))
+	var lastErr *GitHubError
+	delay := githubRetryDelay
+	for attempt := 1; attempt <= 3; attempt++ {
+		if attempt > 1 {
+			logDebug("GitHub request failed (%v), retrying in %s...", lastErr.Err, delay)
+			time.Sleep(delay)
+			delay *= 2
+		}
+		tag, err := fetchGitHubReleaseOnce(repo)
+		if err == nil {
+			return tag, nil
+		}
+		if lastErr = err; err.RateLimited {
+			break
+		}
+	}
+	return "", lastErr
+}
+
+func fetchGitHubReleaseOnce(repo string) (string, *GitHubError) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/repos/%s/releases/latest", githubAPIURL, repo), nil)
 	if err != nil {
-		return "", err
+		return "", &GitHubError{Err: err}
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", &GitHubError{Err: err}
 	}
 	defer resp.Body.Close()
+
+	remaining := resp.Header.Get("X-RateLimit-Remaining")
+	if n, err := strconv.Atoi(remaining); err == nil && n < 10 && resp.StatusCode == http.StatusOK {
+		logWarn("Only %d GitHub API requests left this hour; set GITHUB_TOKEN to raise the limit.", n)
+	}
+	if (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests) && remaining == "0" {
+		return "", &GitHubError{RateLimited: true, Err: fmt.Errorf("HTTP %d", resp.StatusCode)}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", &GitHubError{Err: fmt.Errorf("HTTP %d", resp.StatusCode)}
+	}
+
 	var r struct {
 		TagName string `json:"tag_name"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
-		return "", err
+		return "", &GitHubError{Err: err}
+	}
+	if r.TagName == "" {
+		return "", &GitHubError{Err: fmt.Errorf("no tag_name in response")}
 	}
 	return r.TagName, nil
 }
@@ -1,8 +1,20 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestGetSSHBaseArgs(t *testing.T) {
@@ -29,3 +41,416 @@ func TestGetSSHBaseArgs(t *testing.T) {
 		t.Errorf("Expected ControlMaster in args: %s", cmd)
 	}
 }
+
+func TestBuildRsyncArgsExcludes(t *testing.T) {
+	env := Environment{Host: "host.com", User: "user", Port: 22}
+	excludes := []string{"data/", "*.db", ".env"}
+
+	extra := append(rsyncExcludeArgs(excludes), "--delete")
+	args := buildRsyncArgs(env, []string{"build/server", "migrations"}, "user@host.com:/app/", extra...)
+
+	deleteIdx := -1
+	for i, a := range args {
+		if a == "--delete" {
+			deleteIdx = i
+		}
+	}
+	if deleteIdx == -1 {
+		t.Fatalf("Expected --delete in args: %v", args)
+	}
+
+	for _, pattern := range excludes {
+		want := "--exclude=" + pattern
+		count := 0
+		for i, a := range args {
+			if a == want {
+				count++
+				if i > deleteIdx {
+					t.Errorf("Expected %s before --delete: %v", want, args)
+				}
+			}
+		}
+		if count != 1 {
+			t.Errorf("Expected %s exactly once, got %d: %v", want, count, args)
+		}
+	}
+}
+
+func TestConfirmAssumeYes(t *testing.T) {
+	assumeYes = true
+	defer func() { assumeYes = false }()
+
+	// Replace stdin with a closed pipe: reading from it would return EOF (=> false)
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+	w.Close()
+	oldStdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = oldStdin; r.Close() }()
+
+	if !confirm("Overwrite remote DB?") {
+		t.Errorf("Expected confirm to return true with --yes")
+	}
+}
+
+func TestRequireRemoteTools(t *testing.T) {
+	if err := exec.Command("sh", "-c", requireRemoteTools("sh")).Run(); err != nil {
+		t.Errorf("Expected sh to be found, got %v", err)
+	}
+	out, err := exec.Command("sh", "-c", requireRemoteTools("sh", "no-such-tool-xyz")).CombinedOutput()
+	if err == nil {
+		t.Error("Expected failure for a missing tool")
+	}
+	if !strings.Contains(string(out), "no-such-tool-xyz not found on remote") {
+		t.Errorf("Expected missing tool in output, got %q", out)
+	}
+}
+
+func TestSSHArgsJumpHost(t *testing.T) {
+	env := Environment{Host: "10.0.0.5", User: "app", Port: 22, JumpHost: "admin@bastion.example.com:2222"}
+
+	cmd := strings.Join(getSSHBaseArgs(env), " ")
+	if !strings.Contains(cmd, "-J admin@bastion.example.com:2222") {
+		t.Errorf("Expected -J in ssh args: %s", cmd)
+	}
+	if !strings.HasSuffix(cmd, "app@10.0.0.5") {
+		t.Errorf("Expected target host last: %s", cmd)
+	}
+
+	args := buildRsyncArgs(env, []string{"build/server"}, "app@10.0.0.5:/app/")
+	var sshCmd string
+	for i, a := range args {
+		if a == "-e" && i+1 < len(args) {
+			sshCmd = args[i+1]
+		}
+	}
+	if !strings.Contains(sshCmd, "-J admin@bastion.example.com:2222") || !strings.Contains(sshCmd, "ControlPath=") {
+		t.Errorf("Expected -J and ControlPath in rsync -e: %s", sshCmd)
+	}
+
+	env.JumpHost = ""
+	if cmd := strings.Join(getSSHBaseArgs(env), " "); strings.Contains(cmd, "-J") {
+		t.Errorf("Did not expect -J without jump_host: %s", cmd)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	old := "[Container]\nImage=app:v1\nNetwork=net\n"
+	new := "[Container]\nImage=app:v2\nNetwork=net\nVolume=/data\n"
+
+	got := strings.Join(diffLines(old, new), "\n")
+	want := "  [Container]\n- Image=app:v1\n+ Image=app:v2\n  Network=net\n+ Volume=/data"
+	if got != want {
+		t.Errorf("Expected diff:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestBuildRsyncArgsBwLimitProgress(t *testing.T) {
+	env := Environment{Host: "host.com", User: "user", Port: 22}
+
+	args := strings.Join(buildRsyncArgs(env, []string{"a"}, "user@host.com:/app/"), " ")
+	if strings.Contains(args, "--bwlimit") || strings.Contains(args, "--info=progress2") {
+		t.Errorf("Expected no bwlimit/progress by default: %s", args)
+	}
+
+	rsyncBwLimit, rsyncProgress = 500, true
+	t.Cleanup(func() { rsyncBwLimit, rsyncProgress = 0, false })
+	args = strings.Join(buildRsyncArgs(env, []string{"a"}, "user@host.com:/app/"), " ")
+	if !strings.Contains(args, "--bwlimit=500") || !strings.Contains(args, "--info=progress2") {
+		t.Errorf("Expected --bwlimit=500 and --info=progress2: %s", args)
+	}
+}
+
+func TestBuildRsyncArgsCustom(t *testing.T) {
+	env := Environment{Host: "host.com", User: "user", RsyncArgs: []string{"--no-perms", "--chmod=D755,F644"}}
+	args := buildRsyncArgs(env, []string{"a"}, "user@host.com:/app/", "--delete")
+	i := slices.Index(args, "--no-perms")
+	if i < 0 || args[i+1] != "--chmod=D755,F644" || args[0] != "-avz" {
+		t.Errorf("Expected custom args after -avz, got %v", args)
+	}
+	if args[len(args)-3] != "--delete" {
+		t.Errorf("Expected managed --delete after custom args, got %v", args)
+	}
+
+	for _, bad := range []string{"-e", "-ze", "--rsh=ssh", "--delete-after", "--del"} {
+		if err := checkRsyncArgs([]string{bad}); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+	if err := checkRsyncArgs([]string{"--checksum", "-c", "--exclude=*.log"}); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+func TestSSHArgsDoNotTrackMasters(t *testing.T) {
+	env := Environment{User: "deploy", Host: "track.example.com"}
+	getSSHBaseArgs(env)
+	buildRsyncArgs(env, []string{"a"}, remotePath(env, "/app/"))
+	if _, ok := controlMasters.Load(controlPath(env)); ok {
+		t.Error("Expected the arg builders not to track a control master")
+	}
+
+	sshCommand(context.Background(), env, "true")
+	if _, ok := controlMasters.Load(controlPath(env)); !ok {
+		t.Error("Expected sshCommand to track the control master")
+	}
+	controlMasters.Delete(controlPath(env))
+}
+
+func TestCleanupControlSocket(t *testing.T) {
+	dir, err := os.MkdirTemp("/tmp", "sock") // Short path: unix sockets are limited to ~100 bytes
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "deploy-app-example.com")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	// No master answers the check, so even without force the socket is stale
+	if got := cleanupControlSocket(socket, false); got != "stale" {
+		t.Errorf("Expected stale, got %q", got)
+	}
+	if _, err := os.Lstat(socket); !os.IsNotExist(err) {
+		t.Errorf("Expected the stale socket to be removed, got %v", err)
+	}
+	if got := cleanupControlSocket(socket, true); got != "" {
+		t.Errorf("Expected nothing to clean up the second time, got %q", got)
+	}
+}
+
+func TestSSHNoMultiplex(t *testing.T) {
+	off := false
+	env := Environment{Host: "example.com", User: "app", SSHMultiplex: &off}
+	rsync := buildRsyncArgs(env, []string{"a"}, "app@example.com:/app/")
+	for _, args := range []string{strings.Join(getSSHBaseArgs(env), " "), rsync[2]} {
+		if strings.Contains(args, "Control") {
+			t.Errorf("Expected no Control* options with ssh_multiplex: false, got %s", args)
+		}
+	}
+
+	env.SSHMultiplex = nil
+	if !strings.Contains(strings.Join(getSSHBaseArgs(env), " "), "ControlMaster=auto") {
+		t.Errorf("Expected multiplexing by default")
+	}
+	noMultiplex = true
+	t.Cleanup(func() { noMultiplex = false })
+	if strings.Contains(strings.Join(getSSHBaseArgs(env), " "), "ControlPath") {
+		t.Errorf("Expected --no-multiplex to drop ControlPath")
+	}
+}
+
+func TestSSHProxyCommand(t *testing.T) {
+	env := Environment{Host: "app.tail1234.ts.net", User: "app"}
+	if strings.Contains(strings.Join(getSSHBaseArgs(env), " "), "ProxyCommand") {
+		t.Errorf("Did not expect ProxyCommand without ssh_proxy_command")
+	}
+
+	env.SSHProxyCommand = "tailscale nc %h %p"
+	if !slices.Contains(getSSHBaseArgs(env), "ProxyCommand=tailscale nc %h %p") {
+		t.Errorf("Expected ProxyCommand in ssh args: %v", getSSHBaseArgs(env))
+	}
+	args := buildRsyncArgs(env, []string{"a"}, "app@app.tail1234.ts.net:/app/")
+	if !strings.Contains(args[2], "-o 'ProxyCommand=tailscale nc %h %p'") {
+		t.Errorf("Expected quoted ProxyCommand in rsync -e: %s", args[2])
+	}
+}
+
+func TestSSHArgsConnectTimeout(t *testing.T) {
+	env := Environment{Host: "example.com", User: "app"}
+
+	cmd := strings.Join(getSSHBaseArgs(env), " ")
+	if !strings.Contains(cmd, "-o ConnectTimeout=10") {
+		t.Errorf("Expected default ConnectTimeout=10 in ssh args: %s", cmd)
+	}
+	if strings.Contains(cmd, "BatchMode") {
+		t.Errorf("Did not expect BatchMode without ssh_key: %s", cmd)
+	}
+
+	env.SSHTimeout = 3
+	env.SSHKey = "~/.ssh/id_ed25519"
+	cmd = strings.Join(getSSHBaseArgs(env), " ")
+	if !strings.Contains(cmd, "-o ConnectTimeout=3") || !strings.Contains(cmd, "-o BatchMode=yes") {
+		t.Errorf("Expected ConnectTimeout=3 and BatchMode=yes in ssh args: %s", cmd)
+	}
+
+	args := buildRsyncArgs(env, []string{"build/server"}, "app@example.com:/app/")
+	if !strings.Contains(strings.Join(args, " "), "ConnectTimeout=3") {
+		t.Errorf("Expected ConnectTimeout in rsync -e: %v", args)
+	}
+}
+
+func TestFetchLatestGitHubRelease(t *testing.T) {
+	oldURL, oldDelay := githubAPIURL, githubRetryDelay
+	githubRetryDelay = time.Millisecond
+	t.Cleanup(func() { githubAPIURL, githubRetryDelay = oldURL, oldDelay })
+	t.Setenv("GITHUB_TOKEN", "tok")
+
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			t.Errorf("Expected GITHUB_TOKEN as bearer token, got %q", r.Header.Get("Authorization"))
+		}
+		if calls < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"tag_name":"v3.1.2"}`))
+	}))
+	defer srv.Close()
+	githubAPIURL = srv.URL
+
+	tag, err := fetchLatestGitHubRelease("traefik/traefik")
+	if err != nil || tag != "v3.1.2" {
+		t.Errorf("Expected v3.1.2 after retries, got %q (%v)", tag, err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 attempts, got %d", calls)
+	}
+}
+
+func TestFetchLatestGitHubReleaseRateLimited(t *testing.T) {
+	oldURL := githubAPIURL
+	t.Cleanup(func() { githubAPIURL = oldURL })
+
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+	githubAPIURL = srv.URL
+
+	_, err := fetchLatestGitHubRelease("traefik/traefik")
+	var ghErr *GitHubError
+	if !errors.As(err, &ghErr) || !ghErr.RateLimited {
+		t.Errorf("Expected a rate-limit GitHubError, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected no retries on rate limit, got %d calls", calls)
+	}
+}
+
+func TestIPv6HostBrackets(t *testing.T) {
+	env := Environment{Host: "2001:db8::1", User: "deploy"}
+	args := getSSHBaseArgs(env)
+	if got := args[len(args)-1]; got != "deploy@[2001:db8::1]" {
+		t.Errorf("Expected bracketed ssh target, got %s", got)
+	}
+	if got := remotePath(env, "/app/"); got != "deploy@[2001:db8::1]:/app/" {
+		t.Errorf("Expected bracketed rsync destination, got %s", got)
+	}
+	for _, host := range []string{"10.0.0.1", "vps.example.com", "[2001:db8::1]"} {
+		if got := sshHost(host); got != host {
+			t.Errorf("Expected %s unchanged, got %s", host, got)
+		}
+	}
+}
+
+func TestCommandContextCancelsSlowCommand(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := runCommand("Slow", commandContext(ctx, "sleep", "10"))
+	if err == nil {
+		t.Fatal("Expected the slow command to be killed")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected cancellation shortly after the deadline, took %s", elapsed)
+	}
+	if ctx.Err() != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", ctx.Err())
+	}
+
+	// An expired release context must stop remote commands before they start
+	if _, err := runSSHOutputContext(ctx, Environment{Host: "127.0.0.1", User: "deploy"}, "true"); err == nil {
+		t.Error("Expected runSSHOutputContext to fail on an expired context")
+	}
+}
+
+func TestRunCommandStreamKeepsError(t *testing.T) {
+	old := streamOutput
+	streamOutput = true
+	t.Cleanup(func() { streamOutput = old })
+
+	err := runCommand("Build", exec.Command("sh", "-c", "echo compiling; echo 'undefined: foo' >&2; exit 3"))
+	if err == nil || !strings.Contains(err.Error(), "undefined: foo") || !strings.Contains(err.Error(), "Build failed") {
+		t.Errorf("Expected the streamed stderr in the error, got %v", err)
+	}
+	if err := runCommand("Build", exec.Command("true")); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestLogJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logOut = &buf
+	logJSON = true
+	defer func() { logOut, logJSON = os.Stdout, false }()
+	logWarn("disk at %d%%", 91)
+
+	var entry logEntry
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected one JSON log line, got error %v", err)
+	}
+	if entry.Level != "warn" || entry.Message != "disk at 91%" || entry.Time == "" {
+		t.Errorf("Expected warn 'disk at 91%%' with a timestamp, got %+v", entry)
+	}
+}
+
+func TestLogFileIsUncolored(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deploy.log")
+	defer func() { logOut = os.Stdout }()
+	if err := openLogFile(path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	logError("release %s failed", "v1.2.3")
+
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "[ERR] release v1.2.3 failed\n") {
+		t.Errorf("Expected uncolored log line in file, got %q", data)
+	}
+	if strings.Contains(string(data), "\x1b[") {
+		t.Errorf("Expected no ANSI codes in log file, got %q", data)
+	}
+}
+
+func TestDisableColors(t *testing.T) {
+	saved := []string{Reset, Red, Green, Yellow, Blue, Gray}
+	defer func() {
+		Reset, Red, Green, Yellow, Blue, Gray = saved[0], saved[1], saved[2], saved[3], saved[4], saved[5]
+	}()
+
+	disableColors()
+	if got := Red + "[ERR] " + Reset + "x"; got != "[ERR] x" {
+		t.Errorf("Expected no ANSI codes, got %q", got)
+	}
+}
+
+func TestLogFileGetsConfirmAndDryRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deploy.log")
+	defer func() { logOut, assumeYes, dryRun = os.Stdout, false, false }()
+	if err := openLogFile(path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assumeYes = true
+	confirm("Deploy to prod?")
+	dryRun = true
+	runCommandRawContext(context.Background(), "podman", "ps")
+
+	data, _ := os.ReadFile(path)
+	for _, want := range []string{"Deploy to prod? [y/N]: y (auto-confirmed via --yes)\n", "[DRY] podman [ps]\n"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected %q in log file, got %q", want, data)
+		}
+	}
+}
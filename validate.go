@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	memoryRe = regexp.MustCompile(`^[0-9]+[bkmgBKMG]$`)
+	cpuRe    = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?%$`)
+)
+
+// validateResources checks memory/cpu before they are rendered verbatim into the unit,
+// where a typo only surfaces at activation.
+func validateResources(q Quadlet) error {
+	if q.Memory != "" && !memoryRe.MatchString(q.Memory) {
+		return fmt.Errorf("memory '%s' of '%s' needs a unit: <number><b|k|m|g>, e.g. '512m'", q.Memory, q.ServiceName)
+	}
+	if q.CPU != "" && !cpuRe.MatchString(q.CPU) {
+		return fmt.Errorf("cpu '%s' of '%s' must be a percentage, e.g. '50%%' (half a core) or '200%%'", q.CPU, q.ServiceName)
+	}
+	return nil
+}
+
+// validateConfig reports all common mistakes in cfg at once instead of failing on the first.
+func validateConfig(cfg Config) []string {
+	var problems []string
+	add := func(envName, f string, a ...any) {
+		problems = append(problems, fmt.Sprintf("%s: %s", envName, fmt.Sprintf(f, a...)))
+	}
+
+	if _, err := buildOS(cfg); err != nil {
+		add("build", "%v", err)
+	}
+
+	// user@host -> service name -> env, to catch two envs fighting over one unit. Units
+	// live per systemd --user instance, so the same name on another host or user is fine.
+	services := map[string]map[string]string{}
+
+	for _, name := range sortedEnvNames(cfg) {
+		env := cfg.Environments[name]
+		if env.Host == "" {
+			add(name, "missing 'host'")
+		}
+		if env.User == "" {
+			add(name, "missing 'user'")
+		}
+		if env.Dir == "" {
+			add(name, "missing 'target_dir'")
+		}
+		if p := env.AuthProvider; p != "" && p != "basic" && p != "authelia" {
+			add(name, "invalid 'auth_provider' '%s', use 'basic' or 'authelia'", p)
+		}
+		if env.JumpHost != "" && env.SSHProxyCommand != "" {
+			add(name, "set either 'jump_host' or 'ssh_proxy_command', not both")
+		}
+		if err := checkRsyncArgs(env.RsyncArgs); err != nil {
+			add(name, "%v", err)
+		}
+		if env.SyncEnvFile != "" && env.Secrets.File != "" {
+			add(name, "set either 'sync_env_file' or 'secrets.file', not both")
+		}
+
+		q := env.Quadlet
+		if q.ServiceName == "" {
+			add(name, "missing 'quadlet.service_name'")
+		}
+		if q.ContainerUID > 0 && len(q.ChownVolumes) == 0 {
+			add(name, "'container_uid' is set but 'chown_volumes' is empty")
+		}
+		if q.StopOnDeploy && q.HealthURL != "" {
+			add(name, "'stop_on_deploy' with 'health_url': a failed check rolls back onto a stopped service")
+		}
+
+		for _, svc := range append([]Quadlet{q}, env.Sidecars...) {
+			if err := validateResources(svc); err != nil {
+				add(name, "%v", err)
+			}
+			for _, p := range routerProblems(svc.Router) {
+				add(name, "%s: %s", svc.ServiceName, p)
+			}
+			for _, sec := range svc.Secrets {
+				if sec.Name == "" {
+					add(name, "secret in '%s' is missing 'name'", svc.ServiceName)
+				}
+			}
+			for _, vol := range svc.Volumes {
+				src, _, _ := strings.Cut(vol, ":")
+				if !strings.HasPrefix(src, "./") && !strings.HasPrefix(src, "/") {
+					add(name, "volume '%s' must start with './' or '/'", vol)
+				}
+			}
+			if svc.ServiceName == "" || env.Host == "" {
+				continue
+			}
+			target := unitTarget(env)
+			if services[target] == nil {
+				services[target] = map[string]string{}
+			}
+			if other, ok := services[target][svc.ServiceName]; ok {
+				add(name, "service '%s' is also used by env '%s' on %s", svc.ServiceName, other, target)
+			} else {
+				services[target][svc.ServiceName] = name
+			}
+		}
+	}
+	return problems
+}
+
+// unitTarget is the user@host whose systemd --user instance runs env's units. Host names
+// are case-insensitive and may end in a dot; ssh config aliases are not resolved.
+func unitTarget(env Environment) string {
+	return env.User + "@" + strings.TrimSuffix(strings.ToLower(env.Host), ".")
+}
+
+func doValidate() {
+	cfg, err := readConfig()
+	if err != nil {
+		logFatal("%v", err)
+	}
+	if len(cfg.Environments) == 0 {
+		logFatal("No environments defined in deploy.yaml")
+	}
+
+	problems := validateConfig(cfg)
+	if len(problems) > 0 {
+		logError("Found %d problem(s) in deploy.yaml:", len(problems))
+		for _, p := range problems {
+			fmt.Printf("  - %s\n", p)
+		}
+		logFatal("Validation failed.")
+	}
+	logSuccess("✅ deploy.yaml looks good (%d environments).", len(cfg.Environments))
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	cfg := Config{Environments: map[string]Environment{
+		"prod": {
+			Host: "vps.com", User: "deploy", Dir: "/app",
+			Quadlet: Quadlet{
+				ServiceName:  "app",
+				ContainerUID: 65532,
+				StopOnDeploy: true,
+				HealthURL:    "http://localhost:8080/health",
+				Volumes:      []string{"./data:/data", "data:/other"},
+			},
+		},
+		"staging": {
+			Host: "VPS.com.", User: "deploy", Dir: "/staging",
+			Quadlet: Quadlet{ServiceName: "app"},
+		},
+		"qa": {Host: "qa.com", Dir: "/qa", Quadlet: Quadlet{ServiceName: "qa"}},
+	}}
+
+	problems := strings.Join(validateConfig(cfg), "\n")
+	for _, want := range []string{
+		"prod: 'container_uid' is set but 'chown_volumes' is empty",
+		"prod: 'stop_on_deploy' with 'health_url'",
+		"prod: volume 'data:/other' must start with './' or '/'",
+		"qa: missing 'user'",
+		"staging: service 'app' is also used by env 'prod' on deploy@vps.com",
+	} {
+		if !strings.Contains(problems, want) {
+			t.Errorf("Expected problem %q, got:\n%s", want, problems)
+		}
+	}
+	if strings.Contains(problems, "./data:/data") {
+		t.Errorf("Did not expect relative volume to be flagged, got:\n%s", problems)
+	}
+}
+
+func TestValidateConfigClean(t *testing.T) {
+	cfg := Config{Environments: map[string]Environment{
+		"prod":    {Host: "a.com", User: "u", Dir: "/app", Quadlet: Quadlet{ServiceName: "app"}},
+		"staging": {Host: "b.com", User: "u", Dir: "/app", Quadlet: Quadlet{ServiceName: "app"}},
+	}}
+	if problems := validateConfig(cfg); len(problems) != 0 {
+		t.Errorf("Expected no problems, got %v", problems)
+	}
+}
+
+func TestValidateConfigServicePerUserHost(t *testing.T) {
+	cfg := Config{Environments: map[string]Environment{
+		"prod":    {Host: "a.com", User: "u", Dir: "/app", Quadlet: Quadlet{ServiceName: "app"}},
+		"staging": {Host: "b.com", User: "u", Dir: "/app", Quadlet: Quadlet{ServiceName: "app"}},
+		"test":    {Host: "a.com", User: "tester", Dir: "/app", Quadlet: Quadlet{ServiceName: "app"}},
+		"preview": {Host: "A.com.", User: "u", Dir: "/preview", Quadlet: Quadlet{ServiceName: "app"}},
+	}}
+	problems := validateConfig(cfg)
+	want := "prod: service 'app' is also used by env 'preview' on u@a.com"
+	if len(problems) != 1 || problems[0] != want {
+		t.Errorf("Expected only %q, got %v", want, problems)
+	}
+}
+
+func TestValidateConfigRouterProblems(t *testing.T) {
+	cfg := Config{Environments: map[string]Environment{
+		"prod": {Host: "a.com", User: "u", Dir: "/app",
+			Quadlet:  Quadlet{ServiceName: "app", Router: RouterConfig{Domain: "app.com", RedirectWWW: "apex", RateLimit: &RateLimitConfig{Burst: 10}}},
+			Sidecars: []Quadlet{{ServiceName: "admin", Router: RouterConfig{RedirectWWW: "to_www"}}},
+		},
+	}}
+	problems := strings.Join(validateConfig(cfg), "\n")
+	for _, want := range []string{
+		"prod: app: rate_limit ignored, 'average' must be > 0",
+		"prod: app: redirect_www 'apex' ignored, use 'to_apex' or 'to_www'",
+		"prod: admin: redirect_www ignored, it needs router.domain",
+	} {
+		if !strings.Contains(problems, want) {
+			t.Errorf("Expected problem %q, got:\n%s", want, problems)
+		}
+	}
+	if got := routerProblems(RouterConfig{Domain: "app.com", RedirectWWW: "to_apex"}); len(got) != 0 {
+		t.Errorf("Expected a valid router to have no problems, got %v", got)
+	}
+}
+
+func TestValidateResources(t *testing.T) {
+	tests := []struct {
+		name    string
+		q       Quadlet
+		wantErr string
+	}{
+		{"Unset", Quadlet{}, ""},
+		{"Valid", Quadlet{Memory: "512m", CPU: "50%"}, ""},
+		{"Valid Upper And Fraction", Quadlet{Memory: "2G", CPU: "150.5%"}, ""},
+		{"Memory Without Unit", Quadlet{ServiceName: "app", Memory: "512"}, "memory '512' of 'app' needs a unit"},
+		{"Memory Long Unit", Quadlet{Memory: "512MB"}, "needs a unit"},
+		{"CPU Without Percent", Quadlet{CPU: "50"}, "must be a percentage"},
+		{"CPU Cores", Quadlet{CPU: "0.5"}, "must be a percentage"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateResources(tt.q)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build metadata of the deploy tool itself, injected via:
+//
+//	-ldflags "-X main.Version=v1.2.3 -X main.Commit=8f3a1c2 -X main.Date=2024-01-01T12:00:00Z"
+var (
+	Version = ""
+	Commit  = ""
+	Date    = ""
+)
+
+// versionString describes this binary; without ldflags it falls back to "(devel)"
+// and whatever VCS info the Go toolchain embedded.
+func versionString() string {
+	version, commit, date := Version, Commit, Date
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && commit == "":
+				commit = s.Value
+			case s.Key == "vcs.time" && date == "":
+				date = s.Value
+			}
+		}
+	}
+	if version == "" {
+		version = "(devel)"
+	}
+	if commit == "" {
+		commit = "unknown"
+	}
+	if date == "" {
+		date = "unknown"
+	}
+	return fmt.Sprintf("deploy %s (commit %s, built %s, %s %s/%s)", version, commit, date, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
+
+func doVersion() {
+	fmt.Println(versionString())
+}
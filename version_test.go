@@ -0,0 +1,22 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVersionString(t *testing.T) {
+	old := [3]string{Version, Commit, Date}
+	t.Cleanup(func() { Version, Commit, Date = old[0], old[1], old[2] })
+
+	Version, Commit, Date = "", "", ""
+	if got := versionString(); !strings.HasPrefix(got, "deploy (devel) ") {
+		t.Errorf("Expected (devel) fallback, got %s", got)
+	}
+
+	Version, Commit, Date = "v1.2.3", "8f3a1c2", "2024-01-01T12:00:00Z"
+	got := versionString()
+	if !strings.HasPrefix(got, "deploy v1.2.3 (commit 8f3a1c2, built 2024-01-01T12:00:00Z,") {
+		t.Errorf("Expected ldflags metadata, got %s", got)
+	}
+}